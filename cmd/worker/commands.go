@@ -0,0 +1,217 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dppeppel/scryarr/internal/config"
+	"github.com/dppeppel/scryarr/internal/llm"
+	"github.com/dppeppel/scryarr/internal/plex"
+	"github.com/dppeppel/scryarr/internal/store"
+	"github.com/dppeppel/scryarr/internal/taste"
+	"github.com/dppeppel/scryarr/internal/tautulli"
+	"github.com/dppeppel/scryarr/internal/tmdb"
+	"github.com/goccy/go-yaml"
+	"github.com/rs/zerolog"
+)
+
+// runCommand runs a subcommand such as "categories render" and returns the
+// process exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "categories":
+		return categoriesCommand(args[1:])
+	case "prompt":
+		return promptCommand(args[1:])
+	case "eval":
+		return evalCommand(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n", args[0])
+	return 2
+}
+
+// categoriesCommand handles "categories render", which prints categories.yml
+// with anchors and templates expanded
+func categoriesCommand(args []string) int {
+	if len(args) == 0 || args[0] != "render" {
+		fmt.Fprintln(os.Stderr, "Usage: scryarr categories render [--categories path]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("categories render", flag.ExitOnError)
+	path := fs.String("categories", *categoriesPath, "Path to categories.yml config file")
+	fs.Parse(args[1:])
+
+	cfg, err := config.LoadCategoriesConfig(*path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load categories config: %v\n", err)
+		return 1
+	}
+
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to render categories: %v\n", err)
+		return 1
+	}
+	os.Stdout.Write(out)
+	return 0
+}
+
+// promptCommand handles "prompt preview", which prints the messages the next
+// run would send to the LLM for a category. It reads the watch history like
+// a run does, but uses the stored Plex inventory and signals without
+// syncing them.
+func promptCommand(args []string) int {
+	usage := "Usage: scryarr prompt preview -category label [-variant name] [--config path] [--categories path]"
+	if len(args) == 0 || args[0] != "preview" {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("prompt preview", flag.ExitOnError)
+	label := fs.String("category", "", "Label of the category to preview")
+	variantName := fs.String("variant", "", "Experiment variant to preview the category under instead of the assigned one")
+	appPath := fs.String("config", *configPath, "Path to app.yml config file")
+	catsPath := fs.String("categories", *categoriesPath, "Path to categories.yml config file")
+	fs.Parse(args[1:])
+	if *label == "" {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	// Only warnings reach the log (stderr), so stdout holds just the prompt
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	appCfg, err := config.LoadAppConfig(*appPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load app config: %v\n", err)
+		return 1
+	}
+	categoriesCfg, err := config.LoadCategoriesConfig(*catsPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load categories config: %v\n", err)
+		return 1
+	}
+
+	var category *config.Category
+	for i := range categoriesCfg.Categories {
+		if categoriesCfg.Categories[i].Label == *label {
+			category = &categoriesCfg.Categories[i]
+			break
+		}
+	}
+	if category == nil {
+		fmt.Fprintf(os.Stderr, "Unknown category %q\n", *label)
+		return 1
+	}
+	if category.IsDynamic() {
+		fmt.Fprintf(os.Stderr, "Category %q is generated from the history at run time and cannot be previewed\n", *label)
+		return 1
+	}
+
+	db, err := openStore(appCfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	// Preview the variant the category's next run gets, unless one is named
+	applied := "none"
+	experiment, variant, err := previewVariant(appCfg, db, category, *variantName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if variant != nil {
+		varied := category.WithVariant(variant)
+		category = &varied
+		source := "assigned to the next run"
+		if *variantName != "" {
+			source = "from -variant"
+		}
+		applied = fmt.Sprintf("%s of experiment %s (%s)", variant.Name, experiment.Name, source)
+	}
+	o := NewOrchestrator(appCfg, categoriesCfg, db)
+	if servers := appCfg.Plex.AllServers(); len(servers) > 0 {
+		o.resolveOwner(plex.NewClient(servers[0].URL, servers[0].Token, appCfg.Plex.PageSize))
+	}
+
+	// Build the audience profile the way a run does
+	tautulliClient := tautulli.NewClient(appCfg.Tautulli.URL, appCfg.Tautulli.APIKey)
+	history, err := o.historySource(tautulliClient).GetHistory(o.maxLookbackDays())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to fetch watch history, previewing without it: %v\n", err)
+		history = []tautulli.HistoryItem{}
+	}
+	o.fillHistoryTMDbIDs(history)
+	var meta taste.MetadataSource
+	if tmdbClient, err := tmdb.NewClient(config.LoadTMDbConfig().APIKey, db); err == nil {
+		meta = tmdbClient
+	}
+
+	rec := appCfg.RecommenderFor(category)
+	audience, err := category.AudienceUsers(appCfg.UserGroups)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	audienceProfile := o.newProfileBuilder(history, meta).forAudience(audience, rec.LookbackDays)
+
+	req, _, err := o.categoryRequest(category, rec, audienceProfile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to build request: %v\n", err)
+		return 1
+	}
+	prompts, err := llm.LoadPrompts(appCfg.Recommender.PromptsDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	prompt, err := prompts.For(category.Prompt, req.Task)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	messages, err := prompt.Render(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("# category: %s\n", category.Label)
+	fmt.Printf("# variant: %s\n", applied)
+	fmt.Printf("# model: %s (temperature %g)\n", rec.Model, rec.Temperature)
+	fmt.Printf("# prompt: %s (version %s)\n", prompt.Name, prompt.Version)
+	fmt.Printf("\n--- system ---\n%s\n", messages.System)
+	fmt.Printf("\n--- user ---\n%s\n", messages.User)
+	return 0
+}
+
+// previewVariant returns the experiment and variant a preview applies: the
+// named variant when one is given, otherwise the one the category's next run
+// is assigned. Both are nil for a category outside any experiment.
+func previewVariant(appCfg *config.AppConfig, runs store.RunRepository, category *config.Category, name string) (*config.Experiment, *config.Variant, error) {
+	experiment := appCfg.ExperimentFor(category)
+	if experiment == nil {
+		if name != "" {
+			return nil, nil, fmt.Errorf("category %q is not in any experiment", category.Label)
+		}
+		return nil, nil, nil
+	}
+
+	if name == "" {
+		count, err := runs.CountExperimentRuns(category.Label, experiment.Name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to count experiment runs: %w", err)
+		}
+		return experiment, experiment.Assign(category.Label, count), nil
+	}
+	for i := range experiment.Variants {
+		if experiment.Variants[i].Name == name {
+			return experiment, &experiment.Variants[i], nil
+		}
+	}
+	return nil, nil, fmt.Errorf("experiment %q has no variant %q", experiment.Name, name)
+}
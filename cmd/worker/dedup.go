@@ -0,0 +1,43 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/dppeppel/scryarr/internal/config"
+	"github.com/dppeppel/scryarr/internal/resolve"
+)
+
+// labelPriorities maps category labels to their priority. Generated seed
+// categories are added as they are generated.
+type labelPriorities map[string]int
+
+// categoriesByPriority returns the configured categories, highest priority
+// first and in file order within a priority, with their label priorities
+func (o *Orchestrator) categoriesByPriority() ([]config.Category, labelPriorities) {
+	categories := append([]config.Category(nil), o.categoriesCfg.Categories...)
+	sort.SliceStable(categories, func(i, j int) bool {
+		return categories[i].Priority > categories[j].Priority
+	})
+
+	priorities := make(labelPriorities, len(categories))
+	for _, category := range categories {
+		priorities[category.Label] = category.Priority
+	}
+	return categories, priorities
+}
+
+// dedupFor returns the dedup policy of a category. Under
+// cross_category_dedup a category yields to every other label of equal or
+// higher priority, so higher-priority categories keep contested titles.
+// Labels that are no longer configured never claim titles.
+func (o *Orchestrator) dedupFor(category *config.Category, rec config.RecommenderRun, priorities labelPriorities) resolve.Dedup {
+	dedup := resolve.Dedup{Days: rec.DedupDays}
+	if !o.appCfg.Recommender.CrossCategoryDedup {
+		return dedup
+	}
+	dedup.Yields = func(label string) bool {
+		priority, ok := priorities[label]
+		return ok && priority >= category.Priority
+	}
+	return dedup
+}
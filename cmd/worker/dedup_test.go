@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/dppeppel/scryarr/internal/config"
+	"github.com/dppeppel/scryarr/internal/store"
+)
+
+func TestCategoriesByPriority(t *testing.T) {
+	o := newTestOrchestrator(store.NewMemoryStore(),
+		config.Category{Label: "A"},
+		config.Category{Label: "B", Priority: 5},
+		config.Category{Label: "C"},
+		config.Category{Label: "D", Priority: 5},
+	)
+
+	categories, priorities := o.categoriesByPriority()
+	var labels string
+	for _, category := range categories {
+		labels += category.Label
+	}
+	if labels != "BDAC" {
+		t.Errorf("order = %s, want BDAC", labels)
+	}
+	if priorities["B"] != 5 || priorities["A"] != 0 {
+		t.Errorf("priorities = %v", priorities)
+	}
+}
+
+func TestDedupFor(t *testing.T) {
+	o := newTestOrchestrator(store.NewMemoryStore())
+	priorities := labelPriorities{"High": 5, "Peer": 0, "Low": -1}
+	category := &config.Category{Label: "Mine"}
+	rec := config.RecommenderRun{DedupDays: 30}
+
+	if dedup := o.dedupFor(category, rec, priorities); dedup.Yields != nil || dedup.Days != 30 {
+		t.Errorf("without cross_category_dedup: %+v", dedup)
+	}
+
+	o.appCfg.Recommender.CrossCategoryDedup = true
+	dedup := o.dedupFor(category, rec, priorities)
+	for label, want := range map[string]bool{"High": true, "Peer": true, "Low": false, "Removed": false} {
+		if got := dedup.Yields(label); got != want {
+			t.Errorf("Yields(%q) = %v, want %v", label, got, want)
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dppeppel/scryarr/internal/config"
+	"github.com/dppeppel/scryarr/internal/logging"
+	"github.com/dppeppel/scryarr/internal/replay"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Files of a fixture directory
+const (
+	fixtureManifestFile = "fixture.json"
+	fixtureCassetteFile = "cassette.json"
+	fixtureAppFile      = "app.yml"
+	fixtureCatsFile     = "categories.yml"
+	fixturePromptsDir   = "prompts"
+	fixtureGoldenDir    = "golden"
+	fixtureRequestsFile = "requests.log"
+)
+
+// fixtureManifest describes how a fixture was recorded
+type fixtureManifest struct {
+	RecordedAt string   `json:"recorded_at"`
+	LLMAPIBase string   `json:"llm_api_base,omitempty"`
+	SecretEnv  []string `json:"secret_env"` // secret env vars that were set; replayed as placeholders
+}
+
+// timestampPattern matches RFC3339 timestamps, which differ on every run
+var timestampPattern = regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})`)
+
+// evalCommand handles "eval": with -record it runs the pipeline live and
+// records the HTTP exchanges and outputs into a fixture directory; otherwise
+// it replays each fixture offline and diffs the outputs against its golden
+// files
+func evalCommand(args []string) int {
+	usage := "Usage: scryarr eval [-update] fixture-dir...\n       scryarr eval -record fixture-dir [--config path] [--categories path]"
+
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	record := fs.String("record", "", "Run live and record the run into this fixture directory")
+	update := fs.Bool("update", false, "Rewrite the golden files with the replayed outputs")
+	appPath := fs.String("config", *configPath, "Path to app.yml config file (with -record)")
+	catsPath := fs.String("categories", *categoriesPath, "Path to categories.yml config file (with -record)")
+	fs.Parse(args)
+
+	if *record != "" {
+		if err := recordFixture(*record, *appPath, *catsPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to record fixture: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	// Only warnings reach the log (stderr), so stdout holds just the report
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	code := 0
+	for _, dir := range fs.Args() {
+		ok, err := evalFixture(dir, *update)
+		if err != nil {
+			fmt.Printf("FAIL %s: %v\n", dir, err)
+			code = 1
+		} else if !ok {
+			code = 1
+		}
+	}
+	return code
+}
+
+// recordFixture runs the pipeline against the live services with a fresh
+// database and writes the scrubbed exchanges, config and outputs to dir
+func recordFixture(dir, appPath, catsPath string) error {
+	appCfg, err := config.LoadAppConfig(appPath)
+	if err != nil {
+		return fmt.Errorf("failed to load app config: %w", err)
+	}
+	logging.Setup(appCfg.App.LogLevel)
+	categoriesCfg, err := config.LoadCategoriesConfig(catsPath)
+	if err != nil {
+		return fmt.Errorf("failed to load categories config: %w", err)
+	}
+
+	manifest := fixtureManifest{
+		RecordedAt: time.Now().UTC().Format(time.RFC3339),
+		LLMAPIBase: os.Getenv("LLM_API_BASE"),
+	}
+	var secrets []string
+	for _, name := range appCfg.SecretEnv() {
+		if value := os.Getenv(name); value != "" {
+			manifest.SecretEnv = append(manifest.SecretEnv, name)
+			secrets = append(secrets, value)
+		}
+	}
+	sort.Strings(manifest.SecretEnv)
+	if appCfg.Paths.DBURL != "" {
+		secrets = append(secrets, appCfg.Paths.DBURL)
+	}
+	scrub := replay.NewScrubber(secrets...)
+	manifest.LLMAPIBase = scrub.String(manifest.LLMAPIBase)
+
+	// Keep the config the run used, scrubbed like the exchanges
+	if err := os.MkdirAll(filepath.Join(dir, fixturePromptsDir), 0755); err != nil {
+		return err
+	}
+	if err := copyScrubbed(appPath, filepath.Join(dir, fixtureAppFile), scrub); err != nil {
+		return err
+	}
+	if err := copyScrubbed(catsPath, filepath.Join(dir, fixtureCatsFile), scrub); err != nil {
+		return err
+	}
+	templates, _ := filepath.Glob(filepath.Join(appCfg.Recommender.PromptsDir, "*.tmpl"))
+	for _, path := range templates {
+		if err := copyScrubbed(path, filepath.Join(dir, fixturePromptsDir, filepath.Base(path)), scrub); err != nil {
+			return err
+		}
+	}
+
+	recorder := replay.NewRecorder(http.DefaultTransport, secrets...)
+	http.DefaultTransport = recorder
+	outputs, err := runFixture(appCfg, categoriesCfg)
+	if err != nil {
+		return err
+	}
+	exchanges := recorder.Exchanges()
+	outputs[fixtureRequestsFile] = requestsLog(exchanges)
+
+	if err := recorder.Save(filepath.Join(dir, fixtureCassetteFile)); err != nil {
+		return fmt.Errorf("failed to save cassette: %w", err)
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, fixtureManifestFile), data, 0644); err != nil {
+		return err
+	}
+	if err := writeGolden(dir, outputs); err != nil {
+		return err
+	}
+
+	log.Info().Str("fixture", dir).Int("exchanges", len(exchanges)).Int("outputs", len(outputs)).Msg("Recorded fixture")
+	return nil
+}
+
+// evalFixture replays a fixture and reports whether its outputs match the
+// golden files. With update, the golden files are rewritten instead.
+func evalFixture(dir string, update bool) (bool, error) {
+	data, err := os.ReadFile(filepath.Join(dir, fixtureManifestFile))
+	if err != nil {
+		return false, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	var manifest fixtureManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return false, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	cassette, err := replay.LoadCassette(filepath.Join(dir, fixtureCassetteFile))
+	if err != nil {
+		return false, err
+	}
+
+	// Secrets that were set when recording get placeholders, so the same
+	// clients are created; nothing reaches the network anyway
+	appPath := filepath.Join(dir, fixtureAppFile)
+	appCfg, err := config.LoadAppConfig(appPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to load app config: %w", err)
+	}
+	for _, name := range appCfg.SecretEnv() {
+		os.Unsetenv(name)
+	}
+	for _, name := range manifest.SecretEnv {
+		os.Setenv(name, replay.Redacted)
+	}
+	os.Unsetenv("DB_URL")
+	os.Setenv("LLM_API_BASE", manifest.LLMAPIBase)
+	if appCfg, err = config.LoadAppConfig(appPath); err != nil {
+		return false, fmt.Errorf("failed to load app config: %w", err)
+	}
+	appCfg.Recommender.PromptsDir = filepath.Join(dir, fixturePromptsDir)
+	categoriesCfg, err := config.LoadCategoriesConfig(filepath.Join(dir, fixtureCatsFile))
+	if err != nil {
+		return false, fmt.Errorf("failed to load categories config: %w", err)
+	}
+
+	replayer := replay.NewReplayer(cassette)
+	http.DefaultTransport = replayer
+	outputs, err := runFixture(appCfg, categoriesCfg)
+	if err != nil {
+		return false, err
+	}
+	outputs[fixtureRequestsFile] = requestsLog(replayer.Calls())
+
+	if update {
+		if err := writeGolden(dir, outputs); err != nil {
+			return false, err
+		}
+		fmt.Printf("updated %s\n", dir)
+		return true, nil
+	}
+
+	golden, err := readOutputs(filepath.Join(dir, fixtureGoldenDir))
+	if err != nil {
+		return false, fmt.Errorf("failed to read golden files: %w", err)
+	}
+
+	names := make(map[string]bool)
+	for name := range golden {
+		names[name] = true
+	}
+	for name := range outputs {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var report strings.Builder
+	for _, name := range sorted {
+		want, inGolden := golden[name]
+		got, inOutputs := outputs[name]
+		switch {
+		case !inOutputs:
+			fmt.Fprintf(&report, "  %s: missing from the outputs\n", name)
+		case !inGolden:
+			fmt.Fprintf(&report, "  %s: not in the golden files\n", name)
+		case want != got:
+			fmt.Fprintf(&report, "  %s:\n%s", name, diffLines(want, got))
+		}
+	}
+	for _, miss := range replayer.Misses() {
+		fmt.Fprintf(&report, "  no recorded response: %s\n", miss)
+	}
+
+	if report.Len() > 0 {
+		fmt.Printf("FAIL %s\n%s", dir, report.String())
+		return false, nil
+	}
+	fmt.Printf("ok   %s (%d outputs)\n", dir, len(outputs))
+	return true, nil
+}
+
+// runFixture runs every category with a fresh database and output
+// directories, and returns the outputs keyed by path ("json/...", "pmm/...")
+func runFixture(appCfg *config.AppConfig, categoriesCfg *config.CategoriesConfig) (map[string]string, error) {
+	tmp, err := os.MkdirTemp("", "scryarr-eval-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	appCfg.Paths.DBURL = ""
+	appCfg.Paths.DBPath = filepath.Join(tmp, "scryarr.sqlite")
+	appCfg.Paths.JSONOutDir = filepath.Join(tmp, "json")
+	appCfg.Paths.PMMOutDir = filepath.Join(tmp, "pmm")
+	for _, dir := range []string{appCfg.Paths.JSONOutDir, appCfg.Paths.PMMOutDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := openStore(appCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	if err := NewOrchestrator(appCfg, categoriesCfg, db).RunCategories("eval", nil); err != nil {
+		return nil, fmt.Errorf("run failed: %w", err)
+	}
+	return readOutputs(tmp, "json", "pmm")
+}
+
+// readOutputs reads the files under root (or under its subdirs, when
+// given) keyed by slash-separated relative path, with timestamps masked
+func readOutputs(root string, subdirs ...string) (map[string]string, error) {
+	if len(subdirs) == 0 {
+		subdirs = []string{"."}
+	}
+	outputs := make(map[string]string)
+	for _, subdir := range subdirs {
+		err := filepath.Walk(filepath.Join(root, subdir), func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			outputs[filepath.ToSlash(rel)] = timestampPattern.ReplaceAllString(string(data), "<timestamp>")
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return outputs, nil
+}
+
+// writeGolden replaces the fixture's golden files with outputs
+func writeGolden(dir string, outputs map[string]string) error {
+	golden := filepath.Join(dir, fixtureGoldenDir)
+	if err := os.RemoveAll(golden); err != nil {
+		return err
+	}
+	for name, content := range outputs {
+		path := filepath.Join(golden, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requestsLog lists the requests that change something (everything but
+// GETs), such as Plex collection edits and LLM calls, one per line
+func requestsLog(exchanges []replay.Exchange) string {
+	var b strings.Builder
+	for _, exchange := range exchanges {
+		if exchange.Method == http.MethodGet {
+			continue
+		}
+		fmt.Fprintf(&b, "%s %s\n", exchange.Method, timestampPattern.ReplaceAllString(exchange.URL, "<timestamp>"))
+	}
+	return b.String()
+}
+
+// copyScrubbed copies a text file with secrets redacted
+func copyScrubbed(src, dst string, scrub *replay.Scrubber) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, []byte(scrub.String(string(data))), 0644)
+}
+
+// diffLines returns the lines removed from want ("-") and added in got
+// ("+"), with the line number in want before each run of changes
+func diffLines(want, got string) string {
+	a := strings.Split(want, "\n")
+	b := strings.Split(got, "\n")
+
+	// lcs[i][j] is the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var out strings.Builder
+	inHunk := false
+	hunk := func(line int) {
+		if !inHunk {
+			fmt.Fprintf(&out, "    @@ line %d\n", line+1)
+			inHunk = true
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			inHunk = false
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			hunk(i)
+			fmt.Fprintf(&out, "    -%s\n", a[i])
+			i++
+		default:
+			hunk(i)
+			fmt.Fprintf(&out, "    +%s\n", b[j])
+			j++
+		}
+	}
+	return out.String()
+}
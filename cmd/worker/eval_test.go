@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// basicFixture was recorded against fake Tautulli, Plex, TMDb and LLM
+// endpoints: one mood category whose picks are one owned title, published
+// as a Plex collection, and one to acquire, written as PMM YAML
+const basicFixture = "testdata/eval/basic"
+
+// isolateEval restores the process state evalFixture changes
+func isolateEval(t *testing.T) {
+	t.Helper()
+	transport := http.DefaultTransport
+	t.Cleanup(func() { http.DefaultTransport = transport })
+	for _, name := range []string{"TAUTULLI_API_KEY", "PLEX_TOKEN", "TMDB_API_KEY", "LLM_API_KEY", "LLM_API_BASE", "DB_URL"} {
+		t.Setenv(name, os.Getenv(name))
+	}
+}
+
+func TestEvalFixtureMatchesGolden(t *testing.T) {
+	isolateEval(t)
+
+	ok, err := evalFixture(basicFixture, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Errorf("replayed outputs differ from %s/golden", basicFixture)
+	}
+}
+
+func TestEvalFixtureReportsDifferences(t *testing.T) {
+	isolateEval(t)
+
+	// Work on a copy, so the committed golden files stay untouched
+	dir := filepath.Join(t.TempDir(), "basic")
+	err := filepath.Walk(basicFixture, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(basicFixture, path)
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(dir, rel), 0755)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dir, rel), data, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolved := filepath.Join(dir, fixtureGoldenDir, "json", "resolved_cozy_sci-fi.json")
+	data, err := os.ReadFile(resolved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited := strings.Replace(string(data), "Quiet, thoughtful first contact.", "Loud, thoughtless first contact.", 1)
+	if err := os.WriteFile(resolved, []byte(edited), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := evalFixture(dir, false); err != nil || ok {
+		t.Fatalf("edited golden: ok = %v, err = %v, want a reported difference", ok, err)
+	}
+
+	// -update accepts the replayed outputs again
+	if ok, err := evalFixture(dir, true); err != nil || !ok {
+		t.Fatalf("update: ok = %v, err = %v", ok, err)
+	}
+	if ok, err := evalFixture(dir, false); err != nil || !ok {
+		t.Errorf("after update: ok = %v, err = %v", ok, err)
+	}
+}
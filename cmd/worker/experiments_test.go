@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/dppeppel/scryarr/internal/config"
+	"github.com/dppeppel/scryarr/internal/store"
+)
+
+func TestRunSplitRotatesPerCategory(t *testing.T) {
+	db := store.NewMemoryStore()
+	o := newTestOrchestrator(db)
+	o.appCfg.Experiments = []config.Experiment{{
+		Name:     "shootout",
+		Split:    config.SplitRun,
+		Variants: []config.Variant{{Name: "a"}, {Name: "b"}},
+	}}
+	cozy := &config.Category{Label: "Cozy"}
+	space := &config.Category{Label: "Space"}
+
+	run := func(category *config.Category) string {
+		t.Helper()
+		jobID, _ := db.CreateJobRun("oneshot")
+		catRunID, err := db.CreateCategoryRun(jobID, category.Label, "mood")
+		if err != nil {
+			t.Fatal(err)
+		}
+		o.applyExperiment(category, catRunID)
+		latest, _ := db.GetLatestCategoryRun(category.Label)
+		return latest.Variant
+	}
+
+	// Jobs that run only Space (webhook refreshes) must not decide which
+	// variant Cozy gets next
+	var cozyVariants []string
+	cozyVariants = append(cozyVariants, run(cozy))
+	run(space)
+	cozyVariants = append(cozyVariants, run(cozy))
+	run(space)
+	run(space)
+	cozyVariants = append(cozyVariants, run(cozy))
+
+	if got := cozyVariants[0] + cozyVariants[1] + cozyVariants[2]; got != "aba" {
+		t.Errorf("Cozy variants = %v, want a, b, a", cozyVariants)
+	}
+}
+
+func TestPreviewVariant(t *testing.T) {
+	db := store.NewMemoryStore()
+	appCfg := &config.AppConfig{Experiments: []config.Experiment{{
+		Name:       "shootout",
+		Split:      config.SplitRun,
+		Categories: []string{"Cozy"},
+		Variants:   []config.Variant{{Name: "a"}, {Name: "b"}},
+	}}}
+	cozy := &config.Category{Label: "Cozy"}
+
+	// Without -variant the preview follows the rotation
+	jobID, _ := db.CreateJobRun("oneshot")
+	catRunID, _ := db.CreateCategoryRun(jobID, "Cozy", "mood")
+	db.RecordCategoryRunVariant(catRunID, "shootout", "a")
+	_, variant, err := previewVariant(appCfg, db, cozy, "")
+	if err != nil || variant == nil || variant.Name != "b" {
+		t.Errorf("assigned variant = %+v, %v, want b", variant, err)
+	}
+
+	_, variant, err = previewVariant(appCfg, db, cozy, "a")
+	if err != nil || variant == nil || variant.Name != "a" {
+		t.Errorf("named variant = %+v, %v, want a", variant, err)
+	}
+	if _, _, err := previewVariant(appCfg, db, cozy, "c"); err == nil {
+		t.Errorf("unknown variant was accepted")
+	}
+
+	// Categories outside the experiment preview as they are
+	if _, variant, err := previewVariant(appCfg, db, &config.Category{Label: "Space"}, ""); err != nil || variant != nil {
+		t.Errorf("Space variant = %+v, %v, want none", variant, err)
+	}
+	if _, _, err := previewVariant(appCfg, db, &config.Category{Label: "Space"}, "a"); err == nil {
+		t.Errorf("-variant accepted for a category outside any experiment")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dppeppel/scryarr/internal/llm"
+	"github.com/dppeppel/scryarr/internal/resolve"
+	"github.com/dppeppel/scryarr/internal/store"
+	"github.com/rs/zerolog/log"
+)
+
+// applyFeedback adds the audience's recommendation feedback to the taste
+// profile. Thumbs up is weighted up and thumbs down sent as a negative
+// example; both seen and not interested exclude the title outright. Only a
+// user's latest feedback on a title counts, and household feedback (no user)
+// applies to every audience. It returns the titles to drop or demote and
+// the excluded titles for the prompt's already_seen list.
+func (o *Orchestrator) applyFeedback(profile *llm.TasteProfile, audience []string) ([]resolve.Dislike, []string) {
+	feedback, err := o.store.ListFeedback("", "")
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to load recommendation feedback")
+		return nil, nil
+	}
+
+	var dislikes []resolve.Dislike
+	var excluded []string
+	seen := make(map[string]bool)
+	for _, f := range feedback {
+		if f.User != "" && len(audience) > 0 && !containsFold(audience, f.User) {
+			continue
+		}
+		key := fmt.Sprintf("%s|%s:%d", f.User, f.MediaType, f.TMDbID)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		entry := fmt.Sprintf("%s (%d)", f.Title, f.Year)
+		switch {
+		case f.IsExclusion():
+			dislikes = append(dislikes, resolve.Dislike{TMDbID: f.TMDbID, MediaType: f.MediaType, Exclude: true})
+			if f.Title != "" {
+				excluded = append(excluded, entry)
+			}
+		case f.Feedback == store.FeedbackDown:
+			dislikes = append(dislikes, resolve.Dislike{TMDbID: f.TMDbID, MediaType: f.MediaType})
+			if f.Title != "" && len(profile.Disliked) < maxSignalTitles {
+				profile.Disliked = append(profile.Disliked, entry+" (thumbs down)")
+			}
+		case f.Feedback == store.FeedbackUp:
+			if f.Title != "" && len(profile.HighlyRated) < maxSignalTitles {
+				profile.HighlyRated = append(profile.HighlyRated, entry+" (thumbs up)")
+			}
+		}
+	}
+
+	if len(seen) > 0 {
+		log.Debug().Int("feedback", len(seen)).Int("excluded", len(excluded)).Msg("Applied recommendation feedback")
+	}
+	return dislikes, excluded
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dppeppel/scryarr/internal/config"
+	"github.com/dppeppel/scryarr/internal/llm"
+	"github.com/dppeppel/scryarr/internal/store"
+)
+
+func newTestOrchestrator(db store.Repository, categories ...config.Category) *Orchestrator {
+	return NewOrchestrator(&config.AppConfig{}, &config.CategoriesConfig{Categories: categories}, db)
+}
+
+func TestApplyFeedback(t *testing.T) {
+	db := store.NewMemoryStore()
+	start := time.Now().Add(-time.Hour)
+	record := func(minutes int, user, kind string, tmdbID int, title string) {
+		t.Helper()
+		f := &store.Feedback{Label: "Cozy", TMDbID: tmdbID, MediaType: "movie", Title: title, Year: 2000,
+			User: user, Feedback: kind, CreatedAt: start.Add(time.Duration(minutes) * time.Minute)}
+		if _, err := db.RecordFeedback(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	record(1, "alice", store.FeedbackDown, 1, "Changed Mind")
+	record(2, "alice", store.FeedbackUp, 1, "Changed Mind") // latest wins
+	record(3, "alice", store.FeedbackDown, 2, "Dud")
+	record(4, "", store.FeedbackSeen, 3, "Seen It")          // household
+	record(5, "bob", store.FeedbackNotInterested, 4, "Nope") // another audience
+	record(6, "bob", store.FeedbackUp, 1, "Changed Mind")
+
+	o := newTestOrchestrator(db)
+	profile := &llm.TasteProfile{}
+	dislikes, excluded := o.applyFeedback(profile, []string{"Alice"})
+
+	if len(profile.HighlyRated) != 1 || profile.HighlyRated[0] != "Changed Mind (2000) (thumbs up)" {
+		t.Errorf("highly rated = %v", profile.HighlyRated)
+	}
+	if len(profile.Disliked) != 1 || profile.Disliked[0] != "Dud (2000) (thumbs down)" {
+		t.Errorf("disliked = %v", profile.Disliked)
+	}
+	if len(excluded) != 1 || excluded[0] != "Seen It (2000)" {
+		t.Errorf("excluded = %v", excluded)
+	}
+	if len(dislikes) != 2 {
+		t.Fatalf("dislikes = %+v, want Dud and Seen It", dislikes)
+	}
+	for _, d := range dislikes {
+		if d.TMDbID == 3 && !d.Exclude || d.TMDbID == 2 && d.Exclude {
+			t.Errorf("dislike %+v has the wrong exclusion", d)
+		}
+	}
+
+	// The whole household sees every user's feedback
+	profile = &llm.TasteProfile{}
+	_, excluded = o.applyFeedback(profile, nil)
+	if len(excluded) != 2 {
+		t.Errorf("household excluded = %v, want Seen It and Nope", excluded)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dppeppel/scryarr/internal/store"
+	"github.com/dppeppel/scryarr/internal/tautulli"
+)
+
+func TestHistorySourceReadsTautulliWebhookEvents(t *testing.T) {
+	db := store.NewMemoryStore()
+	watched := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+	for _, e := range []store.WatchEvent{
+		{Source: store.EventSourceTautulli, Event: "watched", User: "alice", RatingKey: "10", MediaType: "movie", Title: "Contact", Year: 1997, OccurredAt: watched},
+		{Source: store.EventSourceTautulli, Event: "watched", User: "bob", RatingKey: "20", MediaType: "movie", Title: "Moon", Year: 2009, OccurredAt: watched},
+	} {
+		if _, err := db.RecordWatchEvent(&e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	o := newTestOrchestrator(db)
+
+	// The API knows alice's watch, dated when the session stopped
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"response": {"result": "success", "data": {"recordsFiltered": 1, "data": [
+			{"title": "Contact", "year": 1997, "media_type": "movie", "stopped": %d, "user": "alice",
+			 "rating_key": "10", "watched_status": 1}]}}}`, watched.Add(10*time.Minute).Unix())
+	}))
+	defer api.Close()
+
+	history, err := o.historySource(tautulli.NewClient(api.URL, "key")).GetHistory(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("history = %+v, want Contact once and Moon", history)
+	}
+
+	// With the API down the webhook events still make up the history
+	api.Close()
+	history, err = o.historySource(tautulli.NewClient(api.URL, "key")).GetHistory(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].WatchedStatus != 1 {
+		t.Errorf("fallback history = %+v", history)
+	}
+}
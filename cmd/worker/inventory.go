@@ -0,0 +1,161 @@
+package main
+
+import (
+	"time"
+
+	"github.com/dppeppel/scryarr/internal/plex"
+	"github.com/dppeppel/scryarr/internal/store"
+	"github.com/dppeppel/scryarr/internal/tautulli"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultFullSyncHours is how often a full inventory reconcile runs when unset
+const defaultFullSyncHours = 168
+
+// pruneServers drops the stored inventory, sync state and ratings of servers
+// that are no longer configured. This covers the rows written under the
+// implicit "default" server before plex.url was replaced by plex.servers,
+// which would otherwise keep counting as owned titles.
+func (o *Orchestrator) pruneServers() {
+	var names []string
+	for _, server := range o.appCfg.Plex.AllServers() {
+		names = append(names, server.Name)
+	}
+	removed, err := o.store.PrunePlexServers(names)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to prune inventory of removed Plex servers")
+		return
+	}
+	if removed > 0 {
+		log.Info().Int64("items", removed).Strs("servers", names).Msg("Pruned inventory of unconfigured Plex servers")
+	}
+}
+
+// syncInventory refreshes a server's rows in plex_inventory. Normally only items
+// updated since the last sync are fetched and upserted; a full fetch replaces
+// the server's rows on the first run and every full_sync_hours so deletions are
+// reconciled.
+func (o *Orchestrator) syncInventory(server string, plexClient *plex.Client) {
+	state, err := o.store.GetPlexSyncState(server)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to load Plex sync state, forcing full sync")
+		state = nil
+	}
+
+	fullSyncHours := o.appCfg.Plex.FullSyncHours
+	if fullSyncHours <= 0 {
+		fullSyncHours = defaultFullSyncHours
+	}
+
+	syncStartedAt := time.Now().UTC()
+	full := state == nil || state.LastFullSyncAt.IsZero() ||
+		syncStartedAt.Sub(state.LastFullSyncAt) >= time.Duration(fullSyncHours)*time.Hour
+
+	var updatedSince int64
+	if !full {
+		updatedSince = state.LastSyncAt.Unix()
+	}
+
+	// Load cached TMDb IDs from database
+	cachedTMDbIDs, err := o.store.GetPlexInventoryCache(server)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to load Plex inventory cache")
+		cachedTMDbIDs = make(map[string]int)
+	}
+	log.Info().Str("server", server).Int("cached_items", len(cachedTMDbIDs)).Msg("Loaded TMDb ID cache")
+
+	// Fetch Plex inventory (cache reduces API calls for TV shows)
+	log.Info().Str("server", server).Bool("full", full).Msg("Fetching Plex inventory")
+	inventory, fetchErr := plexClient.GetInventory(cachedTMDbIDs, updatedSince)
+
+	var dbItems []store.InventoryItem
+	withTMDbID := 0
+	for _, item := range inventory {
+		dbItems = append(dbItems, store.InventoryItem{
+			Server:       server,
+			SectionKey:   item.SectionKey,
+			SectionTitle: item.SectionTitle,
+			RatingKey:    item.RatingKey,
+			TMDbID:       item.TMDbID,
+			MediaType:    item.Type,
+			Title:        item.Title,
+			Year:         item.Year,
+			AddedAt:      item.AddedAt,
+			UpdatedAt:    item.UpdatedAt,
+		})
+		if item.TMDbID > 0 {
+			withTMDbID++
+		}
+	}
+
+	// A partial fetch must never replace the table, or the missing sections
+	// would be treated as deleted. Upsert what we have and retry next run.
+	if fetchErr != nil {
+		log.Warn().Err(fetchErr).Str("server", server).Msg("Plex inventory fetch incomplete, keeping existing rows")
+		if len(dbItems) > 0 {
+			if err := o.store.UpsertPlexInventory(dbItems); err != nil {
+				log.Warn().Err(err).Msg("Failed to update Plex inventory in DB")
+			}
+		}
+		return
+	}
+
+	if full {
+		err = o.store.ReplacePlexInventory(server, dbItems)
+	} else {
+		err = o.store.UpsertPlexInventory(dbItems)
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to update Plex inventory in DB")
+		return
+	}
+
+	newState := &store.PlexSyncState{Server: server, LastSyncAt: syncStartedAt}
+	if full {
+		newState.LastFullSyncAt = syncStartedAt
+	} else {
+		newState.LastFullSyncAt = state.LastFullSyncAt
+	}
+	if err := o.store.SavePlexSyncState(newState); err != nil {
+		log.Warn().Err(err).Msg("Failed to save Plex sync state")
+	}
+
+	log.Info().Str("server", server).Bool("full", full).Int("total", len(inventory)).Int("with_tmdb_id", withTMDbID).Msg("Plex inventory summary")
+}
+
+// fillHistoryTMDbIDs sets TMDb IDs on history entries whose GUID carries none
+// (plex:// GUIDs) from the inventory of the server Tautulli monitors. Movies
+// match on their rating key and episodes on their show's.
+func (o *Orchestrator) fillHistoryTMDbIDs(history []tautulli.HistoryItem) {
+	server := o.appCfg.Tautulli.PlexServer
+	if server == "" {
+		servers := o.appCfg.Plex.AllServers()
+		if len(servers) == 0 {
+			return
+		}
+		server = servers[0].Name
+	}
+
+	tmdbIDs, err := o.store.GetPlexInventoryCache(server)
+	if err != nil {
+		log.Warn().Err(err).Str("server", server).Msg("Failed to load inventory for history IDs")
+		return
+	}
+
+	filled := 0
+	for i := range history {
+		item := &history[i]
+		if item.TMDbID > 0 {
+			continue
+		}
+		key := item.RatingKey
+		if item.MediaType == "episode" {
+			key = item.GrandparentRatingKey
+		}
+		if id := tmdbIDs[key]; id > 0 {
+			item.TMDbID = id
+			filled++
+		}
+	}
+	log.Debug().Str("server", server).Int("filled", filled).Msg("Filled history TMDb IDs from inventory")
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dppeppel/scryarr/internal/config"
+	"github.com/dppeppel/scryarr/internal/resolve"
+	"github.com/dppeppel/scryarr/internal/store"
+	"github.com/dppeppel/scryarr/internal/tautulli"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultLibraryCandidates caps the unwatched titles offered to the LLM for a
+// library-scope category
+const defaultLibraryCandidates = 300
+
+// watchedTitles is the Tautulli history keyed for matching against the
+// inventory. Titles match on TMDb ID when the history has one. Otherwise
+// movies match on title and year and shows on series title, since an
+// episode's year says little about the show's.
+type watchedTitles struct {
+	tmdbIDs map[string]bool
+	movies  map[string]bool
+	shows   map[string]bool
+}
+
+func newWatchedTitles(history []tautulli.HistoryItem) *watchedTitles {
+	w := &watchedTitles{
+		tmdbIDs: make(map[string]bool),
+		movies:  make(map[string]bool),
+		shows:   make(map[string]bool),
+	}
+	for _, item := range history {
+		if item.TMDbID > 0 {
+			mediaType := "movie"
+			if item.MediaType == "episode" {
+				mediaType = "tv"
+			}
+			w.tmdbIDs[tmdbKey(mediaType, item.TMDbID)] = true
+		}
+		if item.MediaType == "episode" {
+			w.shows[resolve.NormalizeTitle(item.GrandparentTitle)] = true
+			continue
+		}
+		w.movies[movieKey(item.Title, item.Year)] = true
+	}
+	return w
+}
+
+func (w *watchedTitles) contains(item store.InventoryItem) bool {
+	if item.TMDbID > 0 && w.tmdbIDs[tmdbKey(item.MediaType, item.TMDbID)] {
+		return true
+	}
+	if item.MediaType == "tv" {
+		return w.shows[resolve.NormalizeTitle(item.Title)]
+	}
+	return w.movies[movieKey(item.Title, item.Year)]
+}
+
+func tmdbKey(mediaType string, tmdbID int) string {
+	return fmt.Sprintf("%s:%d", mediaType, tmdbID)
+}
+
+func movieKey(title string, year int) string {
+	return fmt.Sprintf("%s|%d", resolve.NormalizeTitle(title), year)
+}
+
+// libraryCandidates returns the unwatched inventory titles a library-scope
+// category may choose from, most recently added first. Items without a TMDb
+// ID are skipped because they cannot be published or deduplicated.
+func (o *Orchestrator) libraryCandidates(category *config.Category, watched *watchedTitles) ([]store.InventoryItem, error) {
+	items, err := o.store.ListPlexInventory(resolve.InventoryScope(category))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Plex inventory: %w", err)
+	}
+
+	limit := o.appCfg.Recommender.LibraryCandidates
+	if limit <= 0 {
+		limit = defaultLibraryCandidates
+	}
+
+	var candidates []store.InventoryItem
+	seen := make(map[string]bool)
+	for _, item := range items {
+		if item.TMDbID == 0 || watched.contains(item) {
+			continue
+		}
+		if len(category.MediaTypes) > 0 && !contains(category.MediaTypes, item.MediaType) {
+			continue
+		}
+		// The same title on several servers or sections is offered once
+		key := tmdbKey(item.MediaType, item.TMDbID)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		candidates = append(candidates, item)
+		if len(candidates) >= limit {
+			break
+		}
+	}
+
+	log.Info().Str("category", category.Label).Int("inventory", len(items)).Int("candidates", len(candidates)).Msg("Built library candidates")
+	return candidates, nil
+}
+
+// candidateTitles formats candidates the way the LLM is asked to echo them
+func candidateTitles(candidates []store.InventoryItem) []string {
+	titles := make([]string, len(candidates))
+	for i, item := range candidates {
+		titles[i] = fmt.Sprintf("%s (%d) [%s]", item.Title, item.Year, item.MediaType)
+	}
+	return titles
+}
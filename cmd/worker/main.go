@@ -5,11 +5,13 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 
 	"github.com/dppeppel/scryarr/internal/api"
 	"github.com/dppeppel/scryarr/internal/config"
+	"github.com/dppeppel/scryarr/internal/history"
 	"github.com/dppeppel/scryarr/internal/llm"
 	"github.com/dppeppel/scryarr/internal/logging"
 	"github.com/dppeppel/scryarr/internal/plex"
@@ -23,11 +25,15 @@ import (
 )
 
 var (
-	configPath       = flag.String("config", "/config/app.yml", "Path to app.yml config file")
-	categoriesPath   = flag.String("categories", "/config/categories.yml", "Path to categories.yml config file")
+	configPath     = flag.String("config", "/config/app.yml", "Path to app.yml config file")
+	categoriesPath = flag.String("categories", "/config/categories.yml", "Path to categories.yml config file")
 )
 
 func main() {
+	// Subcommands come before any flags, e.g. "scryarr categories render"
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(runCommand(os.Args[1:]))
+	}
 	flag.Parse()
 
 	// Load configuration
@@ -55,8 +61,8 @@ func main() {
 		log.Fatal().Err(err).Msg("Failed to create PMM output directory")
 	}
 
-	// Initialize store
-	db, err := store.NewStore(appCfg.Paths.DBPath)
+	// Initialize store (SQLite by default, Postgres when db_url is set)
+	db, err := openStore(appCfg)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to initialize database")
 	}
@@ -76,6 +82,10 @@ func main() {
 			appCfg.API.BindAddr,
 			func() error { return orch.Run() },
 		)
+		apiServer.SetWebhookToken(appCfg.Webhooks.Token)
+		if appCfg.Webhooks.Refresh {
+			apiServer.SetWatchFunc(newRefresher(orch).notify)
+		}
 
 		go func() {
 			if err := apiServer.Start(); err != nil {
@@ -124,12 +134,14 @@ func main() {
 type Orchestrator struct {
 	appCfg        *config.AppConfig
 	categoriesCfg *config.CategoriesConfig
-	store         *store.Store
+	store         store.Repository
 	mu            sync.Mutex // Prevent concurrent runs
+
+	ownerAccount string // Plex account name of the default signal user
 }
 
 // NewOrchestrator creates a new orchestrator
-func NewOrchestrator(appCfg *config.AppConfig, categoriesCfg *config.CategoriesConfig, store *store.Store) *Orchestrator {
+func NewOrchestrator(appCfg *config.AppConfig, categoriesCfg *config.CategoriesConfig, store store.Repository) *Orchestrator {
 	return &Orchestrator{
 		appCfg:        appCfg,
 		categoriesCfg: categoriesCfg,
@@ -139,99 +151,129 @@ func NewOrchestrator(appCfg *config.AppConfig, categoriesCfg *config.CategoriesC
 
 // Run executes a full recommendation cycle
 func (o *Orchestrator) Run() error {
+	return o.RunCategories(o.appCfg.App.Mode, nil)
+}
+
+// RunCategories executes a recommendation cycle recorded under mode for the
+// categories include accepts, or for all of them when include is nil
+func (o *Orchestrator) RunCategories(mode string, include func(*config.Category) bool) error {
 	o.mu.Lock()
 	defer o.mu.Unlock()
 
-	log.Info().Msg("Starting job run")
+	log.Info().Str("mode", mode).Msg("Starting job run")
 
 	// Create job run record
-	jobID, err := o.store.CreateJobRun(o.appCfg.App.Mode)
+	jobID, err := o.store.CreateJobRun(mode)
 	if err != nil {
 		return fmt.Errorf("failed to create job run: %w", err)
 	}
 
 	// Initialize clients
 	tautulliClient := tautulli.NewClient(o.appCfg.Tautulli.URL, o.appCfg.Tautulli.APIKey)
-	plexClient := plex.NewClient(o.appCfg.Plex.URL, o.appCfg.Plex.Token)
 	tmdbCfg := config.LoadTMDbConfig()
 	tmdbClient, err := tmdb.NewClient(tmdbCfg.APIKey, o.store)
 	if err != nil {
 		o.store.UpdateJobRun(jobID, "failed", strPtr(err.Error()))
 		return fmt.Errorf("failed to create TMDb client: %w", err)
 	}
-	llmCfg := config.LoadLLMConfig()
-	llmClient := llm.NewClient(llmCfg, o.appCfg.Recommender.Model)
-	resolver := resolve.NewResolver(tmdbClient, o.store)
-	publisher := publish.NewPublisher(o.appCfg.Paths.JSONOutDir, o.appCfg.Paths.PMMOutDir)
-
-	// Load cached TMDb IDs from database
-	cachedTMDbIDs, err := o.store.GetPlexInventoryCache()
+	llmClients := newLLMClients(o.appCfg)
+	prompts, err := llm.LoadPrompts(o.appCfg.Recommender.PromptsDir)
 	if err != nil {
-		log.Warn().Err(err).Msg("Failed to load Plex inventory cache")
-		cachedTMDbIDs = make(map[string]int)
+		o.store.UpdateJobRun(jobID, "failed", strPtr(err.Error()))
+		return fmt.Errorf("failed to load prompt templates: %w", err)
 	}
-	log.Info().Int("cached_items", len(cachedTMDbIDs)).Msg("Loaded TMDb ID cache")
-
-	// Fetch Plex inventory (cache reduces API calls for TV shows)
-	log.Info().Msg("Fetching Plex inventory")
-	inventory, err := plexClient.GetInventory(cachedTMDbIDs)
-	if err != nil {
-		log.Warn().Err(err).Msg("Failed to fetch Plex inventory, continuing without it")
-	} else {
-		// Store items with TMDb IDs in database
-		var dbItems []struct{ RatingKey string; TMDbID int; MediaType string }
-		for _, item := range inventory {
-			if item.TMDbID > 0 {
-				dbItems = append(dbItems, struct{ RatingKey string; TMDbID int; MediaType string }{item.RatingKey, item.TMDbID, item.Type})
-			}
-		}
-		if len(dbItems) > 0 {
-			if err := o.store.UpdatePlexInventory(dbItems); err != nil {
-				log.Warn().Err(err).Msg("Failed to update Plex inventory in DB")
-			}
+	resolver := resolve.NewResolver(tmdbClient, o.store, o.store)
+	publisher := publish.NewPublisher(o.appCfg.Paths.JSONOutDir, o.appCfg.Paths.PMMOutDir)
+	publisher.SetPMMEnabled(o.appCfg.Publish.PMM.IsEnabled())
+
+	// Sync Plex inventory for each server (incremental, with periodic full reconciles)
+	o.pruneServers()
+	plexClients := make(map[string]*plex.Client)
+	for _, server := range o.appCfg.Plex.AllServers() {
+		plexClient := plex.NewClient(server.URL, server.Token, o.appCfg.Plex.PageSize)
+		plexClient.SetSectionFilter(server.IncludeSections, server.ExcludeSections)
+		o.syncInventory(server.Name, plexClient)
+		o.resolveOwner(plexClient)
+		if o.appCfg.Plex.Signals.Ratings {
+			o.syncRatings(server.Name, plexClient)
 		}
+		plexClients[server.Name] = plexClient
+	}
+	if servers := o.appCfg.Plex.AllServers(); o.appCfg.Plex.Signals.Watchlist && len(servers) > 0 {
+		o.syncWatchlists(plexClients[servers[0].Name])
+	}
 
-		log.Info().Int("total", len(inventory)).Int("with_tmdb_id", len(dbItems)).Msg("Plex inventory summary")
+	// Library-scope categories publish to Plex even when the target is off
+	var plexPublisher *publish.PlexPublisher
+	if o.appCfg.Publish.Plex.Enabled || o.hasLibraryCategories() {
+		plexPublisher = publish.NewPlexPublisher(plexClients, o.store, o.appCfg.Publish.Plex)
+		plexPublisher.SetUserGroups(o.appCfg.UserGroups)
 	}
 
 	// Fetch watch history for taste profile
 	log.Info().Msg("Fetching watch history")
-	history, err := tautulliClient.GetHistory(o.appCfg.Tautulli.LookbackDays)
+	history, err := o.historySource(tautulliClient).GetHistory(o.maxLookbackDays())
 	if err != nil {
 		log.Warn().Err(err).Msg("Failed to fetch watch history")
 		history = []tautulli.HistoryItem{}
 	}
+	o.fillHistoryTMDbIDs(history)
+	profiles := o.newProfileBuilder(history, tmdbClient)
 
-	// Build taste profile (titles watched recently)
-	var tasteProfile []string
-	for _, item := range history {
-		if item.ParentTitle != "" {
-			tasteProfile = append(tasteProfile, fmt.Sprintf("%s (%d)", item.ParentTitle, item.Year))
-		} else {
-			tasteProfile = append(tasteProfile, fmt.Sprintf("%s (%d)", item.Title, item.Year))
-		}
-		if len(tasteProfile) >= 50 {
-			break
-		}
-	}
+	// Higher-priority categories run first so they win contested titles
+	categories, priorities := o.categoriesByPriority()
 
-	// Process each category
-	for _, category := range o.categoriesCfg.Categories {
+	runCategory := func(category *config.Category) {
 		log.Info().Str("category", category.Label).Msg("Processing category")
 
 		catRunID, err := o.store.CreateCategoryRun(jobID, category.Label, category.Type)
 		if err != nil {
 			log.Error().Err(err).Str("category", category.Label).Msg("Failed to create category run")
-			continue
+			return
 		}
 
-		if err := o.processCategory(&category, catRunID, llmClient, resolver, publisher, tasteProfile); err != nil {
+		// Experiment variants run as a varied copy of the category
+		category = o.applyExperiment(category, catRunID)
+
+		if err := o.processCategory(category, catRunID, llmClients, resolver, publisher, plexPublisher, profiles, priorities, prompts); err != nil {
 			log.Error().Err(err).Str("category", category.Label).Msg("Category processing failed")
 			o.store.UpdateCategoryRun(catRunID, "failed", nil, strPtr(err.Error()))
+		}
+	}
+
+	// Process each category
+	for _, category := range categories {
+		if include != nil && !include(&category) {
+			continue
+		}
+		if !category.IsDynamic() {
+			runCategory(&category)
 			continue
 		}
+
+		// Dynamic categories run as the seed categories generated from the
+		// history. Without any history, keep the previous seeds published.
+		generated, err := o.seedCategories(&category, profiles)
+		if err != nil {
+			log.Error().Err(err).Str("category", category.Label).Msg("Failed to generate seed categories")
+			continue
+		}
+		if len(history) == 0 {
+			log.Warn().Str("category", category.Label).Msg("No watch history, skipping seed categories")
+			continue
+		}
+		log.Info().Str("category", category.Label).Int("seeds", len(generated)).Msg("Generated seed categories")
+		o.syncSeedCategories(&category, generated, publisher, plexPublisher)
+		for i := range generated {
+			priorities[generated[i].Label] = generated[i].Priority
+		}
+		for i := range generated {
+			runCategory(&generated[i])
+		}
 	}
 
+	o.recordEffectiveness(jobID, history)
+
 	// Mark job as completed
 	if err := o.store.UpdateJobRun(jobID, "completed", nil); err != nil {
 		log.Error().Err(err).Msg("Failed to update job run status")
@@ -241,47 +283,130 @@ func (o *Orchestrator) Run() error {
 	return nil
 }
 
-func (o *Orchestrator) processCategory(
-	category *config.Category,
-	catRunID int64,
-	llmClient *llm.Client,
-	resolver *resolve.Resolver,
-	publisher *publish.Publisher,
-	tasteProfile []string,
-) error {
+// categoryRequest builds the LLM request for a category: new titles for
+// discover categories, and for library categories a ranking of the
+// unwatched candidates, which are also returned
+func (o *Orchestrator) categoryRequest(category *config.Category, rec config.RecommenderRun, audienceProfile *audienceProfile) (llm.PromptRequest, []store.InventoryItem, error) {
 	// Build constraints
 	constraints := map[string]interface{}{
-		"count":                  o.appCfg.Recommender.RecsPerCategory,
+		"count":                  rec.Count,
 		"recency_weight":         o.appCfg.Recommender.RecencyWeight,
 		"diversity_min_fraction": o.appCfg.Recommender.DiversityMinFrac,
 	}
 
-	// Get already seen (from watch history or Plex inventory)
-	var alreadySeen []string
+	if category.IsLibraryScope() {
+		candidates, err := o.libraryCandidates(category, newWatchedTitles(audienceProfile.History))
+		if err != nil {
+			return llm.PromptRequest{}, nil, err
+		}
+		if len(candidates) == 0 {
+			return llm.PromptRequest{}, nil, fmt.Errorf("no unwatched library titles in scope")
+		}
+		return llm.NewRankLibraryRequest(category, constraints, audienceProfile.Taste, candidateTitles(candidates)), candidates, nil
+	}
+
+	// Get already seen (from watch history or Plex inventory); titles
+	// excluded through feedback are listed too
+	alreadySeen := audienceProfile.Excluded
 	// TODO: Build from Plex inventory
 
 	// Get already recommended (last 60 days)
 	var alreadyRecommended []string
 	// TODO: Build from recommendation history
 
-	// Generate recommendations via LLM
-	llmResp, err := llmClient.GenerateRecommendations(category, constraints, tasteProfile, alreadySeen, alreadyRecommended)
+	return llm.NewRecommendRequest(category, constraints, audienceProfile.Taste, alreadySeen, alreadyRecommended), nil, nil
+}
+
+func (o *Orchestrator) processCategory(
+	category *config.Category,
+	catRunID int64,
+	llmClients *llmClients,
+	resolver *resolve.Resolver,
+	publisher *publish.Publisher,
+	plexPublisher *publish.PlexPublisher,
+	profiles *profileBuilder,
+	priorities labelPriorities,
+	prompts *llm.Prompts,
+) error {
+	// Global recommender settings with the category's overrides
+	rec := o.appCfg.RecommenderFor(category)
+	o.recordRecommender(catRunID, rec)
+	llmClient, err := llmClients.get(rec)
 	if err != nil {
-		return fmt.Errorf("LLM generation failed: %w", err)
+		return err
 	}
 
-	// Resolve to TMDb IDs
-	resolved, err := resolver.Resolve(llmResp, category.Label)
+	// Taste and watch state come from the category's audience only
+	audience, err := category.AudienceUsers(o.appCfg.UserGroups)
 	if err != nil {
-		return fmt.Errorf("resolution failed: %w", err)
+		return err
+	}
+	audienceProfile := profiles.forAudience(audience, rec.LookbackDays)
+	if category.IsLibraryScope() && plexPublisher == nil {
+		return fmt.Errorf("library-scope category needs a Plex server to publish to")
+	}
+
+	// Build the LLM request and render it with the category's prompt
+	req, candidates, err := o.categoryRequest(category, rec, audienceProfile)
+	if err != nil {
+		return err
+	}
+	prompt, err := prompts.For(category.Prompt, req.Task)
+	if err != nil {
+		return err
+	}
+	if err := o.store.RecordCategoryRunPrompt(catRunID, prompt.Name, prompt.Version); err != nil {
+		log.Warn().Err(err).Msg("Failed to record prompt template")
+	}
+
+	var llmResp *llm.LLMResponse
+	var resolved *resolve.ResolvedOutput
+	if category.IsLibraryScope() {
+		// Rank unwatched titles already in the library
+		llmResp, err = llmClient.RankLibrary(category, prompt, req)
+		if err != nil {
+			return fmt.Errorf("LLM ranking failed: %w", err)
+		}
+
+		resolved, err = resolver.ResolveLibrary(llmResp, category, candidates, audienceProfile.Dislikes)
+		if err != nil {
+			return fmt.Errorf("resolution failed: %w", err)
+		}
+	} else {
+		// Generate recommendations via LLM
+		llmResp, err = llmClient.GenerateRecommendations(category, prompt, req)
+		if err != nil {
+			return fmt.Errorf("LLM generation failed: %w", err)
+		}
+
+		// Resolve to TMDb IDs
+		resolved, err = resolver.Resolve(llmResp, category, catRunID, audienceProfile.Dislikes, o.dedupFor(category, rec, priorities))
+		if err != nil {
+			return fmt.Errorf("resolution failed: %w", err)
+		}
 	}
 
-	// Publish outputs
-	result, err := publisher.Publish(category.Label, llmResp, resolved)
+	// Publish outputs. In-library picks get PMM collections only when they
+	// are not published straight to Plex.
+	plexDirect := category.IsLibraryScope() || (plexPublisher != nil && o.appCfg.Publish.Plex.Enabled)
+	result, err := publisher.Publish(category, llmResp, resolved, !plexDirect)
 	if err != nil {
 		return fmt.Errorf("publish failed: %w", err)
 	}
 
+	// Publish in-library picks straight to Plex collections. For discover
+	// categories a failure here leaves the file outputs in place, so it does
+	// not fail the category; for library categories Plex is the only output.
+	if category.IsLibraryScope() {
+		if _, err := plexPublisher.Publish(category, resolved); err != nil {
+			return fmt.Errorf("plex collection publish failed: %w", err)
+		}
+	} else if plexDirect {
+		if _, err := plexPublisher.Publish(category, resolved); err != nil {
+			log.Warn().Err(err).Str("category", category.Label).Msg("Plex collection publish incomplete")
+		}
+	}
+
 	// Update category run with paths
 	paths := map[string]*string{
 		"raw_json":      &result.RawJSONPath,
@@ -297,6 +422,75 @@ func (o *Orchestrator) processCategory(
 	return nil
 }
 
+// applyExperiment returns the category under the variant it runs this time
+// and tags the category run with it. A run split rotates on the category's
+// own earlier runs in the experiment, so jobs that skip the category (such
+// as webhook refreshes of other categories) do not shift its rotation.
+func (o *Orchestrator) applyExperiment(category *config.Category, catRunID int64) *config.Category {
+	experiment := o.appCfg.ExperimentFor(category)
+	if experiment == nil {
+		return category
+	}
+	runs, err := o.store.CountExperimentRuns(category.Label, experiment.Name)
+	if err != nil {
+		log.Warn().Err(err).Str("category", category.Label).Msg("Failed to count experiment runs")
+	}
+	variant := experiment.Assign(category.Label, runs)
+
+	log.Info().Str("category", category.Label).Str("experiment", experiment.Name).Str("variant", variant.Name).Msg("Running experiment variant")
+	if err := o.store.RecordCategoryRunVariant(catRunID, experiment.Name, variant.Name); err != nil {
+		log.Warn().Err(err).Msg("Failed to record experiment variant")
+	}
+	varied := category.WithVariant(variant)
+	return &varied
+}
+
+// historySource returns the configured watch history source. Watches stored
+// from Tautulli webhooks back up Tautulli's history API: they fill in while
+// the API is unreachable and are dropped as duplicates otherwise.
+func (o *Orchestrator) historySource(tautulliClient *tautulli.Client) history.Source {
+	tautulliEvents := history.NewEventSource(o.store, store.EventSourceTautulli)
+	switch o.appCfg.History.Source {
+	case history.SourcePlex:
+		return history.NewEventSource(o.store, store.EventSourcePlex)
+	case history.SourceBoth:
+		return history.Merge(
+			[]string{history.SourceTautulli, "tautulli webhooks", history.SourcePlex},
+			[]history.Source{tautulliClient, tautulliEvents, history.NewEventSource(o.store, store.EventSourcePlex)},
+		)
+	default:
+		return history.Merge(
+			[]string{history.SourceTautulli, "tautulli webhooks"},
+			[]history.Source{tautulliClient, tautulliEvents},
+		)
+	}
+}
+
+// usesPlexWebhooks reports whether Plex webhook events feed the history
+func (o *Orchestrator) usesPlexWebhooks() bool {
+	source := o.appCfg.History.Source
+	return source == history.SourcePlex || source == history.SourceBoth
+}
+
+// hasLibraryCategories reports whether any category mines the library
+func (o *Orchestrator) hasLibraryCategories() bool {
+	for _, category := range o.categoriesCfg.Categories {
+		if category.IsLibraryScope() {
+			return true
+		}
+	}
+	return false
+}
+
+// openStore opens the configured database: SQLite by default, Postgres
+// when db_url is set
+func openStore(appCfg *config.AppConfig) (*store.Store, error) {
+	if appCfg.Paths.DBURL != "" {
+		return store.Open(appCfg.Paths.DBURL)
+	}
+	return store.NewStore(appCfg.Paths.DBPath)
+}
+
 func strPtr(s string) *string {
 	return &s
 }
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"time"
+
+	"github.com/dppeppel/scryarr/internal/metrics"
+	"github.com/dppeppel/scryarr/internal/tautulli"
+	"github.com/rs/zerolog/log"
+)
+
+// recordEffectiveness measures the recommendations made within the history
+// window against the current inventory and the run's watch history, and
+// stores the stats under the job. Older recommendations are left out: their
+// later watches may have fallen out of the history.
+func (o *Orchestrator) recordEffectiveness(jobID int64, history []tautulli.HistoryItem) {
+	now := time.Now().UTC()
+	records, err := o.store.ListRecommendationsSince(now.AddDate(0, 0, -o.maxLookbackDays()))
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to load recommendation history for metrics")
+		return
+	}
+	inventory, err := o.store.ListPlexInventory(nil)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to load Plex inventory for metrics")
+		return
+	}
+
+	stats := metrics.Compute(records, inventory, history, now)
+	if err := o.store.SaveEffectiveness(jobID, stats); err != nil {
+		log.Warn().Err(err).Msg("Failed to store effectiveness metrics")
+		return
+	}
+	log.Info().Int("recommendations", len(records)).Int("stats", len(stats)).Msg("Recorded recommendation effectiveness")
+}
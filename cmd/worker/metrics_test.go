@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dppeppel/scryarr/internal/store"
+	"github.com/dppeppel/scryarr/internal/tautulli"
+)
+
+func TestRecordEffectiveness(t *testing.T) {
+	db := store.NewMemoryStore()
+	o := newTestOrchestrator(db)
+	o.appCfg.Tautulli.LookbackDays = 30
+
+	jobID, _ := db.CreateJobRun("oneshot")
+	catRunID, _ := db.CreateCategoryRun(jobID, "Cozy", "mood")
+	db.RecordCategoryRunSettings(catRunID, "gpt-4o-mini", "", "{}")
+	for _, id := range []int{1, 2, 3, 4} {
+		db.RecordRecommendation("Cozy", id, "movie", catRunID)
+	}
+
+	later := time.Now().Add(time.Hour).Unix()
+	db.ReplacePlexInventory("home", []store.InventoryItem{
+		{Server: "home", RatingKey: "a", TMDbID: 1, MediaType: "movie", AddedAt: later},
+		{Server: "home", RatingKey: "b", TMDbID: 2, MediaType: "movie", AddedAt: later},
+		{Server: "home", RatingKey: "c", TMDbID: 3, MediaType: "movie", AddedAt: 1}, // owned before
+	})
+	history := []tautulli.HistoryItem{
+		{TMDbID: 1, MediaType: "movie", WatchedAt: later, PercentComplete: 100, WatchedStatus: 1},
+		{TMDbID: 2, MediaType: "movie", WatchedAt: later, PercentComplete: 20},
+	}
+
+	o.recordEffectiveness(jobID, history)
+
+	stats, err := db.GetEffectiveness(jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byScope := make(map[string]store.EffectivenessStat)
+	for _, st := range stats {
+		byScope[st.Scope+"|"+st.Name] = st
+	}
+	for _, key := range []string{store.StatScopeCategory + "|Cozy", store.StatScopeModel + "|gpt-4o-mini"} {
+		st, ok := byScope[key]
+		if !ok {
+			t.Fatalf("no %s stat in %+v", key, stats)
+		}
+		if st.Recommended != 4 || st.Acquired != 2 || st.Watched != 2 || st.Completed != 1 {
+			t.Errorf("%s = %+v, want 4 recommended, 2 acquired, 2 watched, 1 completed", key, st)
+		}
+	}
+}
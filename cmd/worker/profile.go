@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dppeppel/scryarr/internal/llm"
+	"github.com/dppeppel/scryarr/internal/resolve"
+	"github.com/dppeppel/scryarr/internal/taste"
+	"github.com/dppeppel/scryarr/internal/tautulli"
+	"github.com/rs/zerolog/log"
+)
+
+// filterHistory keeps the entries watched by one of users (Tautulli
+// usernames, case-insensitive). An empty user list keeps everything.
+func filterHistory(history []tautulli.HistoryItem, users []string) []tautulli.HistoryItem {
+	if len(users) == 0 {
+		return history
+	}
+	var filtered []tautulli.HistoryItem
+	for _, item := range history {
+		if containsFold(users, item.User) {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
+
+// filterSince keeps the entries watched at or after since
+func filterSince(history []tautulli.HistoryItem, since time.Time) []tautulli.HistoryItem {
+	var filtered []tautulli.HistoryItem
+	for _, item := range history {
+		if item.WatchedAt >= since.Unix() {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
+
+// audienceProfile is what a category needs to know about its audience
+type audienceProfile struct {
+	Taste    *llm.TasteProfile
+	History  []tautulli.HistoryItem
+	Dislikes []resolve.Dislike // low ratings, abandoned and excluded titles with TMDb IDs
+	Excluded []string          // titles excluded through feedback, "Title (Year)"
+}
+
+// profileBuilder builds taste profiles from the run's history, once per
+// audience
+type profileBuilder struct {
+	o       *Orchestrator
+	history []tautulli.HistoryItem
+	meta    taste.MetadataSource
+	built   map[string]*audienceProfile
+}
+
+func (o *Orchestrator) newProfileBuilder(history []tautulli.HistoryItem, meta taste.MetadataSource) *profileBuilder {
+	return &profileBuilder{
+		o:       o,
+		history: history,
+		meta:    meta,
+		built:   make(map[string]*audienceProfile),
+	}
+}
+
+// forAudience returns the audience's history of the last lookbackDays and
+// the taste profile built from it plus the Plex ratings and watchlists of the
+// matching signal users and their recommendation feedback. An empty audience
+// covers every user.
+func (b *profileBuilder) forAudience(audience []string, lookbackDays int) *audienceProfile {
+	key := fmt.Sprintf("%s|%d", audienceKey(audience), lookbackDays)
+	if built, ok := b.built[key]; ok {
+		return built
+	}
+
+	history := filterSince(filterHistory(b.history, audience), time.Now().AddDate(0, 0, -lookbackDays))
+	weighted := taste.Build(history, taste.Options{
+		RecencyWeight: b.o.appCfg.Recommender.RecencyWeight,
+		MaxTitles:     b.o.appCfg.Recommender.ProfileTitles,
+	}, b.meta)
+
+	profile := &llm.TasteProfile{
+		TopTitles: weighted.Entries(),
+		Genres:    taste.TagNames(weighted.Genres),
+		Keywords:  taste.TagNames(weighted.Keywords),
+	}
+	dislikes := b.o.applySignals(profile, audience)
+	feedbackDislikes, excluded := b.o.applyFeedback(profile, audience)
+	dislikes = append(dislikes, feedbackDislikes...)
+
+	// Quickly abandoned titles count as dislikes too
+	for _, t := range weighted.Abandoned {
+		if len(profile.Disliked) >= maxSignalTitles {
+			break
+		}
+		profile.Disliked = append(profile.Disliked, t.String()+" (abandoned)")
+		if t.TMDbID > 0 {
+			dislikes = append(dislikes, resolve.Dislike{TMDbID: t.TMDbID, MediaType: t.MediaType})
+		}
+	}
+
+	log.Info().Strs("audience", audience).Int("titles", len(profile.TopTitles)).Int("disliked", len(profile.Disliked)).Strs("genres", profile.Genres).Msg("Built taste profile")
+
+	built := &audienceProfile{Taste: profile, History: history, Dislikes: dislikes, Excluded: excluded}
+	b.built[key] = built
+	return built
+}
+
+func audienceKey(audience []string) string {
+	users := make([]string, len(audience))
+	for i, u := range audience {
+		users[i] = strings.ToLower(u)
+	}
+	sort.Strings(users)
+	return strings.Join(users, ",")
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
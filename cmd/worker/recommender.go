@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/dppeppel/scryarr/internal/config"
+	"github.com/dppeppel/scryarr/internal/llm"
+	"github.com/rs/zerolog/log"
+)
+
+// llmClients hands out one LLM client per provider, model and temperature,
+// so categories that override the recommender share clients
+type llmClients struct {
+	appCfg  *config.AppConfig
+	clients map[string]*llm.Client
+}
+
+func newLLMClients(appCfg *config.AppConfig) *llmClients {
+	return &llmClients{appCfg: appCfg, clients: make(map[string]*llm.Client)}
+}
+
+// get returns the client for a category's effective recommender settings
+func (c *llmClients) get(rec config.RecommenderRun) (*llm.Client, error) {
+	key := fmt.Sprintf("%s|%s|%g", rec.Provider, rec.Model, rec.Temperature)
+	if client, ok := c.clients[key]; ok {
+		return client, nil
+	}
+
+	llmCfg, err := c.appCfg.LLMConfigFor(rec.Provider)
+	if err != nil {
+		return nil, err
+	}
+	client := llm.NewClient(llmCfg, rec.Model)
+	client.SetTemperature(rec.Temperature)
+	c.clients[key] = client
+	return client, nil
+}
+
+// recordRecommender stores the effective recommender settings on the
+// category run
+func (o *Orchestrator) recordRecommender(catRunID int64, rec config.RecommenderRun) {
+	settings, err := json.Marshal(rec)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to encode recommender settings")
+		return
+	}
+	if err := o.store.RecordCategoryRunSettings(catRunID, rec.Model, rec.Provider, string(settings)); err != nil {
+		log.Warn().Err(err).Msg("Failed to record recommender settings")
+	}
+}
+
+// maxLookbackDays is the longest history window any category or
+// experiment variant needs
+func (o *Orchestrator) maxLookbackDays() int {
+	days := o.appCfg.Tautulli.LookbackDays
+	for i := range o.categoriesCfg.Categories {
+		if rec := o.appCfg.RecommenderFor(&o.categoriesCfg.Categories[i]); rec.LookbackDays > days {
+			days = rec.LookbackDays
+		}
+	}
+	for _, experiment := range o.appCfg.Experiments {
+		for _, variant := range experiment.Variants {
+			if variant.Recommender != nil && variant.Recommender.LookbackDays > days {
+				days = variant.Recommender.LookbackDays
+			}
+		}
+	}
+	return days
+}
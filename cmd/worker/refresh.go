@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dppeppel/scryarr/internal/config"
+	"github.com/dppeppel/scryarr/internal/store"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// defaultDebounceMinutes is how long webhook events must stop arriving
+	// before a refresh runs
+	defaultDebounceMinutes = 10
+
+	// refreshMinPercent is the completion below which a stopped watch does
+	// not refresh anything; the taste profile ignores such watches too
+	refreshMinPercent = 20
+
+	// refreshMode is the job run mode recorded for webhook refreshes
+	refreshMode = "webhook"
+)
+
+// refresher re-runs the categories affected by webhook watch events. Events
+// are collected until none arrive for the debounce delay, so a binge
+// triggers one refresh rather than one per episode.
+type refresher struct {
+	orch  *Orchestrator
+	delay time.Duration
+
+	mu    sync.Mutex
+	users map[string]bool // users who watched something since the last refresh
+	timer *time.Timer
+}
+
+func newRefresher(orch *Orchestrator) *refresher {
+	minutes := orch.appCfg.Webhooks.DebounceMinutes
+	if minutes <= 0 {
+		minutes = defaultDebounceMinutes
+	}
+	return &refresher{
+		orch:  orch,
+		delay: time.Duration(minutes) * time.Minute,
+		users: make(map[string]bool),
+	}
+}
+
+// notify schedules a refresh for the event's user, restarting the debounce
+func (r *refresher) notify(event store.WatchEvent) {
+	if event.Event == "stop" && event.PercentComplete < refreshMinPercent {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.users[event.User] = true
+	if r.timer == nil {
+		r.timer = time.AfterFunc(r.delay, r.refresh)
+		return
+	}
+	r.timer.Reset(r.delay)
+}
+
+func (r *refresher) refresh() {
+	r.mu.Lock()
+	users := make([]string, 0, len(r.users))
+	for user := range r.users {
+		users = append(users, user)
+	}
+	r.users = make(map[string]bool)
+	r.timer = nil
+	r.mu.Unlock()
+
+	if len(users) == 0 {
+		return
+	}
+
+	log.Info().Strs("users", users).Msg("Refreshing categories after watch events")
+	if err := r.orch.RunCategories(refreshMode, r.orch.affectedBy(users)); err != nil {
+		log.Error().Err(err).Msg("Webhook refresh failed")
+	}
+}
+
+// affectedBy selects the categories whose taste profile includes any of
+// users: household categories and those whose audience names one of them
+func (o *Orchestrator) affectedBy(users []string) func(*config.Category) bool {
+	return func(category *config.Category) bool {
+		audience, err := category.AudienceUsers(o.appCfg.UserGroups)
+		if err != nil {
+			return false
+		}
+		if len(audience) == 0 {
+			return true
+		}
+		for _, user := range users {
+			if containsFold(audience, user) {
+				return true
+			}
+		}
+		return false
+	}
+}
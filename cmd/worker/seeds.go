@@ -0,0 +1,161 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/dppeppel/scryarr/internal/config"
+	"github.com/dppeppel/scryarr/internal/publish"
+	"github.com/dppeppel/scryarr/internal/resolve"
+	"github.com/dppeppel/scryarr/internal/store"
+	"github.com/dppeppel/scryarr/internal/taste"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultRecentSeeds is how many seed categories a recent_seeds category
+// generates when count is unset
+const defaultRecentSeeds = 3
+
+// seedCategories expands a recent_seeds category into one title_seed
+// category per seed, picked from the audience's finished titles
+func (o *Orchestrator) seedCategories(category *config.Category, profiles *profileBuilder) ([]config.Category, error) {
+	audience, err := category.AudienceUsers(o.appCfg.UserGroups)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := config.RecentSeeds{}
+	if category.RecentSeeds != nil {
+		opts = *category.RecentSeeds
+	}
+	if opts.Count <= 0 {
+		opts.Count = defaultRecentSeeds
+	}
+	var since time.Time
+	if opts.WithinDays > 0 {
+		since = time.Now().AddDate(0, 0, -opts.WithinDays)
+	}
+
+	lookback := o.appCfg.RecommenderFor(category).LookbackDays
+	finished := taste.Finished(profiles.forAudience(audience, lookback).History, since)
+	if len(category.MediaTypes) > 0 {
+		kept := finished[:0]
+		for _, t := range finished {
+			if contains(category.MediaTypes, t.MediaType) {
+				kept = append(kept, t)
+			}
+		}
+		finished = kept
+	}
+
+	// Rank by the audience's ratings when asked, or require a minimum one;
+	// otherwise the most recently finished titles win
+	if opts.Order == config.SeedOrderRating || opts.MinRating > 0 {
+		finished = rankByRating(finished, newRatingIndex(o.audienceRatings(audience)), opts)
+	}
+	if len(finished) > opts.Count {
+		finished = finished[:opts.Count]
+	}
+
+	categories := make([]config.Category, len(finished))
+	for i, t := range finished {
+		categories[i] = category.SeedCategory(config.TitleSeed{Title: t.Title, Year: t.Year, Medium: t.MediaType})
+	}
+	return categories, nil
+}
+
+// rankByRating drops titles rated below opts.MinRating (unrated titles count
+// as zero) and, for order: rating, sorts the rest best rated first with ties
+// kept most recent first
+func rankByRating(titles []taste.Title, ratings *ratingIndex, opts config.RecentSeeds) []taste.Title {
+	type rated struct {
+		title  taste.Title
+		rating float64
+	}
+	var kept []rated
+	for _, t := range titles {
+		if rating := ratings.lookup(t); rating >= opts.MinRating {
+			kept = append(kept, rated{title: t, rating: rating})
+		}
+	}
+	if opts.Order == config.SeedOrderRating {
+		sort.SliceStable(kept, func(i, j int) bool { return kept[i].rating > kept[j].rating })
+	}
+
+	ranked := make([]taste.Title, len(kept))
+	for i, r := range kept {
+		ranked[i] = r.title
+	}
+	return ranked
+}
+
+// ratingIndex looks up a viewer's rating for a title by TMDb ID, falling
+// back to title and year
+type ratingIndex struct {
+	byTMDbID map[string]float64
+	byTitle  map[string]float64
+}
+
+// newRatingIndex indexes ratings, keeping the first (most recent) per title
+func newRatingIndex(ratings []store.UserRating) *ratingIndex {
+	idx := &ratingIndex{
+		byTMDbID: make(map[string]float64),
+		byTitle:  make(map[string]float64),
+	}
+	for _, r := range ratings {
+		if r.TMDbID > 0 {
+			if _, ok := idx.byTMDbID[tmdbKey(r.MediaType, r.TMDbID)]; !ok {
+				idx.byTMDbID[tmdbKey(r.MediaType, r.TMDbID)] = r.Rating
+			}
+		}
+		if _, ok := idx.byTitle[movieKey(r.Title, r.Year)]; !ok {
+			idx.byTitle[movieKey(r.Title, r.Year)] = r.Rating
+		}
+	}
+	return idx
+}
+
+// lookup returns the title's rating, or zero when unrated
+func (idx *ratingIndex) lookup(t taste.Title) float64 {
+	if t.TMDbID > 0 {
+		if rating, ok := idx.byTMDbID[tmdbKey(t.MediaType, t.TMDbID)]; ok {
+			return rating
+		}
+	}
+	return idx.byTitle[movieKey(t.Title, t.Year)]
+}
+
+// syncSeedCategories records the categories generated for a dynamic parent
+// and retires the outputs of those that aged out: their PMM YAML is removed
+// and, when publishing to Plex, their collections are emptied and deleted
+func (o *Orchestrator) syncSeedCategories(parent *config.Category, generated []config.Category, publisher *publish.Publisher, plexPublisher *publish.PlexPublisher) {
+	active := make([]store.DynamicCategory, len(generated))
+	for i, c := range generated {
+		active[i] = store.DynamicCategory{
+			Label:      c.Label,
+			SeedTitle:  c.Seed.Title,
+			SeedYear:   c.Seed.Year,
+			SeedMedium: c.Seed.Medium,
+		}
+	}
+
+	retired, err := o.store.SyncDynamicCategories(parent.Label, active)
+	if err != nil {
+		log.Warn().Err(err).Str("category", parent.Label).Msg("Failed to record generated categories")
+		return
+	}
+
+	for _, r := range retired {
+		log.Info().Str("category", parent.Label).Str("retired", r.Label).Msg("Retiring aged-out seed category")
+		if err := publisher.Retire(r.Label); err != nil {
+			log.Warn().Err(err).Str("category", r.Label).Msg("Failed to remove PMM YAML")
+		}
+		if plexPublisher != nil && (parent.IsLibraryScope() || o.appCfg.Publish.Plex.Enabled) {
+			category := parent.SeedCategory(config.TitleSeed{Title: r.SeedTitle, Year: r.SeedYear, Medium: r.SeedMedium})
+			category.Label = r.Label
+			if _, err := plexPublisher.Publish(&category, &resolve.ResolvedOutput{}); err != nil {
+				log.Warn().Err(err).Str("category", r.Label).Msg("Failed to remove Plex collections")
+			}
+		}
+	}
+}
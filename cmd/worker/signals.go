@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dppeppel/scryarr/internal/config"
+	"github.com/dppeppel/scryarr/internal/llm"
+	"github.com/dppeppel/scryarr/internal/plex"
+	"github.com/dppeppel/scryarr/internal/resolve"
+	"github.com/dppeppel/scryarr/internal/store"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultHighRating = 8.0 // 4 stars
+	defaultLowRating  = 4.0 // 2 stars
+
+	// maxSignalTitles caps each signal list sent to the LLM
+	maxSignalTitles = 25
+)
+
+// ownerUserName names the server owner when no signal users are configured
+const ownerUserName = "owner"
+
+// signalUsers returns the users whose Plex signals are read
+func (o *Orchestrator) signalUsers() []config.PlexUser {
+	users := o.appCfg.Plex.Signals.Users
+	if len(users) == 0 {
+		return []config.PlexUser{{Name: ownerUserName}}
+	}
+	return users
+}
+
+// resolveOwner looks up the Plex account name of the server owner when the
+// owner is the default signal user, so its signals can match audiences by
+// name. The first successful lookup is kept.
+func (o *Orchestrator) resolveOwner(plexClient *plex.Client) {
+	signals := o.appCfg.Plex.Signals
+	if o.ownerAccount != "" || len(signals.Users) > 0 || (!signals.Ratings && !signals.Watchlist) {
+		return
+	}
+	name, err := plexClient.AccountName()
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to look up the Plex owner's account; categories with an audience skip the owner's signals")
+		return
+	}
+	o.ownerAccount = name
+}
+
+// inAudience reports whether a signal user's signals apply to an audience.
+// An empty audience takes every user; the default owner matches under its
+// Plex account name.
+func (o *Orchestrator) inAudience(audience []string, user config.PlexUser) bool {
+	if len(audience) == 0 || containsFold(audience, user.Name) {
+		return true
+	}
+	return len(o.appCfg.Plex.Signals.Users) == 0 && o.ownerAccount != "" && containsFold(audience, o.ownerAccount)
+}
+
+// userClient returns a client acting as user, or nil if the user has no token
+func userClient(plexClient *plex.Client, user config.PlexUser) *plex.Client {
+	if user.TokenEnv == "" {
+		return plexClient
+	}
+	if user.Token == "" {
+		log.Warn().Str("user", user.Name).Str("token_env", user.TokenEnv).Msg("No token for Plex user, skipping")
+		return nil
+	}
+	return plexClient.WithToken(user.Token)
+}
+
+// syncRatings stores each signal user's star ratings on a server
+func (o *Orchestrator) syncRatings(server string, plexClient *plex.Client) {
+	cachedTMDbIDs, err := o.store.GetPlexInventoryCache(server)
+	if err != nil {
+		cachedTMDbIDs = make(map[string]int)
+	}
+
+	for _, user := range o.signalUsers() {
+		if len(user.Servers) > 0 && !contains(user.Servers, server) {
+			continue
+		}
+		client := userClient(plexClient, user)
+		if client == nil {
+			continue
+		}
+
+		items, err := client.GetUserRatings(cachedTMDbIDs)
+		if err != nil {
+			// Keep the previous snapshot rather than wiping it on a failed fetch
+			log.Warn().Err(err).Str("server", server).Str("user", user.Name).Msg("Failed to fetch Plex ratings")
+			continue
+		}
+
+		ratings := make([]store.UserRating, 0, len(items))
+		for _, item := range items {
+			ratings = append(ratings, store.UserRating{
+				RatingKey: item.RatingKey,
+				TMDbID:    item.TMDbID,
+				MediaType: item.Type,
+				Title:     item.Title,
+				Year:      item.Year,
+				Rating:    item.UserRating,
+				RatedAt:   time.Unix(item.LastRatedAt, 0),
+			})
+		}
+		if err := o.store.ReplaceUserRatings(server, user.Name, ratings); err != nil {
+			log.Warn().Err(err).Msg("Failed to store Plex ratings")
+		}
+	}
+}
+
+// syncWatchlists stores each signal user's Discover watchlist. Watchlists
+// belong to the Plex account, not a server, so any server's client will do.
+func (o *Orchestrator) syncWatchlists(plexClient *plex.Client) {
+	if o.appCfg.Plex.Signals.DiscoverURL != "" {
+		plexClient.SetDiscoverURL(o.appCfg.Plex.Signals.DiscoverURL)
+	}
+
+	for _, user := range o.signalUsers() {
+		client := userClient(plexClient, user)
+		if client == nil {
+			continue
+		}
+
+		items, err := client.GetWatchlist()
+		if err != nil {
+			log.Warn().Err(err).Str("user", user.Name).Msg("Failed to fetch Plex watchlist")
+			continue
+		}
+
+		entries := make([]store.WatchlistEntry, 0, len(items))
+		for _, item := range items {
+			entry := store.WatchlistEntry{
+				GUID:      item.GUID,
+				TMDbID:    item.TMDbID,
+				MediaType: item.Type,
+				Title:     item.Title,
+				Year:      item.Year,
+			}
+			if item.AddedAt > 0 {
+				entry.AddedAt = time.Unix(item.AddedAt, 0)
+			}
+			entries = append(entries, entry)
+		}
+		if err := o.store.ReplaceWatchlist(user.Name, entries); err != nil {
+			log.Warn().Err(err).Msg("Failed to store Plex watchlist")
+		}
+	}
+}
+
+// applySignals adds stored ratings and watchlists to the taste profile.
+// Ratings at or above high_rating are weighted up; those at or below
+// low_rating are passed as dislikes and returned for the resolver. With an
+// audience, only signal users named like one of its Tautulli users are read;
+// the default owner goes by its Plex account name.
+func (o *Orchestrator) applySignals(profile *llm.TasteProfile, audience []string) []resolve.Dislike {
+	signals := o.appCfg.Plex.Signals
+	if !signals.Ratings && !signals.Watchlist && !o.usesPlexWebhooks() {
+		return nil
+	}
+
+	high := signals.HighRating
+	if high <= 0 {
+		high = defaultHighRating
+	}
+	low := signals.LowRating
+	if low <= 0 {
+		low = defaultLowRating
+	}
+
+	seen := make(map[string]bool)
+	add := func(list *[]string, entry string) bool {
+		if len(*list) < maxSignalTitles && !seen[entry] {
+			seen[entry] = true
+			*list = append(*list, entry)
+			return true
+		}
+		return false
+	}
+
+	var dislikes []resolve.Dislike
+	rate := func(r store.UserRating) {
+		entry := fmt.Sprintf("%s (%d) rated %g/10", r.Title, r.Year, r.Rating)
+		if r.Rating >= high {
+			add(&profile.HighlyRated, entry)
+		} else if r.Rating <= low && add(&profile.Disliked, entry) && r.TMDbID > 0 {
+			dislikes = append(dislikes, resolve.Dislike{TMDbID: r.TMDbID, MediaType: r.MediaType})
+		}
+	}
+
+	for _, r := range o.audienceRatings(audience) {
+		rate(r)
+	}
+
+	if signals.Watchlist {
+		for _, user := range o.signalUsers() {
+			if !o.inAudience(audience, user) {
+				continue
+			}
+			watchlist, err := o.store.GetWatchlist(user.Name)
+			if err != nil {
+				log.Warn().Err(err).Str("user", user.Name).Msg("Failed to load Plex watchlist")
+			}
+			for _, w := range watchlist {
+				add(&profile.Watchlist, fmt.Sprintf("%s (%d)", w.Title, w.Year))
+			}
+		}
+	}
+
+	log.Debug().
+		Int("highly_rated", len(profile.HighlyRated)).
+		Int("disliked", len(profile.Disliked)).
+		Int("watchlist", len(profile.Watchlist)).
+		Msg("Applied Plex taste signals")
+	return dislikes
+}
+
+// audienceRatings returns the ratings of the audience's signal users, stored
+// Plex ratings first and then those received through Plex webhooks. An empty
+// audience means every signal user.
+func (o *Orchestrator) audienceRatings(audience []string) []store.UserRating {
+	var ratings []store.UserRating
+	if o.appCfg.Plex.Signals.Ratings {
+		for _, user := range o.signalUsers() {
+			if !o.inAudience(audience, user) {
+				continue
+			}
+			stored, err := o.store.GetUserRatings(user.Name)
+			if err != nil {
+				log.Warn().Err(err).Str("user", user.Name).Msg("Failed to load Plex ratings")
+			}
+			ratings = append(ratings, stored...)
+		}
+	}
+	if o.usesPlexWebhooks() {
+		ratings = append(ratings, o.webhookRatings(audience)...)
+	}
+	return ratings
+}
+
+// webhookRatings returns the latest rating each user gave each movie or show
+// through Plex media.rate webhooks in the lookback window, most recent first.
+// Cleared ratings are dropped.
+func (o *Orchestrator) webhookRatings(audience []string) []store.UserRating {
+	since := time.Now().AddDate(0, 0, -o.appCfg.Tautulli.LookbackDays)
+	events, err := o.store.GetWatchEventsSince(since, store.EventSourcePlex)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to load Plex rating events")
+		return nil
+	}
+
+	var ratings []store.UserRating
+	seen := make(map[string]bool)
+	for i := len(events) - 1; i >= 0; i-- {
+		e := events[i]
+		if e.Event != "rate" || (len(audience) > 0 && !containsFold(audience, e.User)) {
+			continue
+		}
+		// Episode and season events carry the episode's GUIDs, not the
+		// show's, so only movie and show ratings count
+		var mediaType string
+		switch e.MediaType {
+		case "movie":
+			mediaType = "movie"
+		case "show":
+			mediaType = "tv"
+		default:
+			continue
+		}
+		key := e.User + "|" + e.RatingKey
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if e.Rating <= 0 {
+			continue
+		}
+
+		ratings = append(ratings, store.UserRating{
+			User:      e.User,
+			RatingKey: e.RatingKey,
+			TMDbID:    e.TMDbID,
+			MediaType: mediaType,
+			Title:     e.Title,
+			Year:      e.Year,
+			Rating:    e.Rating,
+			RatedAt:   e.OccurredAt,
+		})
+	}
+	return ratings
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
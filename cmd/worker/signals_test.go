@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dppeppel/scryarr/internal/llm"
+	"github.com/dppeppel/scryarr/internal/plex"
+	"github.com/dppeppel/scryarr/internal/plex/plextest"
+	"github.com/dppeppel/scryarr/internal/store"
+)
+
+func TestOwnerSignalsMatchAudienceByAccountName(t *testing.T) {
+	db := store.NewMemoryStore()
+	err := db.ReplaceUserRatings("home", ownerUserName, []store.UserRating{
+		{RatingKey: "10", TMDbID: 686, MediaType: "movie", Title: "Contact", Year: 1997, Rating: 10, RatedAt: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := newTestOrchestrator(db)
+	o.appCfg.Plex.Signals.Ratings = true
+
+	highlyRated := func(audience ...string) []string {
+		profile := &llm.TasteProfile{}
+		o.applySignals(profile, audience)
+		return profile.HighlyRated
+	}
+	if got := highlyRated("Alice"); len(got) != 0 {
+		t.Errorf("unresolved owner matched audience: %v", got)
+	}
+
+	srv := plextest.NewServer()
+	defer srv.Close()
+	srv.AccountName = "alice"
+	o.resolveOwner(plex.NewClient(srv.URL, srv.Token, 50))
+	if o.ownerAccount != "alice" {
+		t.Fatalf("owner account = %q, want alice", o.ownerAccount)
+	}
+
+	if got := highlyRated("Alice"); len(got) != 1 || got[0] != "Contact (1997) rated 10/10" {
+		t.Errorf("owner's audience: highly rated = %v", got)
+	}
+	if got := highlyRated("bob"); len(got) != 0 {
+		t.Errorf("another audience: highly rated = %v", got)
+	}
+	if got := highlyRated(); len(got) != 1 {
+		t.Errorf("household: highly rated = %v", got)
+	}
+}
+
+func TestWebhookRatingsKeepMoviesAndShows(t *testing.T) {
+	db := store.NewMemoryStore()
+	rated := time.Now().Add(-time.Hour)
+	for i, e := range []store.WatchEvent{
+		{Event: "rate", MediaType: "movie", RatingKey: "10", TMDbID: 686, Title: "Contact", Rating: 9},
+		{Event: "rate", MediaType: "show", RatingKey: "20", TMDbID: 63639, Title: "The Expanse", Rating: 8},
+		{Event: "rate", MediaType: "episode", RatingKey: "21", TMDbID: 1000001, Title: "Dulcinea", Rating: 4},
+		{Event: "rate", MediaType: "season", RatingKey: "22", Title: "Season 1", Rating: 2},
+		{Event: "scrobble", MediaType: "movie", RatingKey: "30", TMDbID: 17431, Title: "Moon"},
+	} {
+		e.Source = store.EventSourcePlex
+		e.User = "alice"
+		e.OccurredAt = rated.Add(time.Duration(i) * time.Minute)
+		if _, err := db.RecordWatchEvent(&e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	o := newTestOrchestrator(db)
+	o.appCfg.Tautulli.LookbackDays = 7
+
+	ratings := o.webhookRatings(nil)
+	if len(ratings) != 2 {
+		t.Fatalf("ratings = %+v, want The Expanse and Contact", ratings)
+	}
+	if ratings[0].TMDbID != 63639 || ratings[0].MediaType != "tv" {
+		t.Errorf("show rating = %+v, want The Expanse as tv", ratings[0])
+	}
+	if ratings[1].TMDbID != 686 || ratings[1].MediaType != "movie" {
+		t.Errorf("movie rating = %+v, want Contact as movie", ratings[1])
+	}
+}
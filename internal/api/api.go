@@ -10,6 +10,7 @@ import (
 
 	"github.com/dppeppel/scryarr/internal/config"
 	"github.com/dppeppel/scryarr/internal/logging"
+	"github.com/dppeppel/scryarr/internal/resolve"
 	"github.com/dppeppel/scryarr/internal/store"
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog"
@@ -21,19 +22,30 @@ func init() {
 	log = logging.GetLogger("api")
 }
 
+// Store is the persistence the API reads runs and stats from and records
+// webhook events and feedback to
+type Store interface {
+	store.RunRepository
+	store.WatchEventRepository
+	store.FeedbackRepository
+	store.EffectivenessRepository
+}
+
 // Server represents the HTTP API server
 type Server struct {
-	store       *store.Store
-	categories  *config.CategoriesConfig
-	jsonOutDir  string
-	pmmOutDir   string
-	bindAddr    string
-	triggerFunc func() error // Function to trigger a manual job run
+	store        Store
+	categories   *config.CategoriesConfig
+	jsonOutDir   string
+	pmmOutDir    string
+	bindAddr     string
+	triggerFunc  func() error           // Function to trigger a manual job run
+	webhookToken string                 // required on webhook requests when set
+	watchFunc    func(store.WatchEvent) // called after a webhook event is recorded
 }
 
 // NewServer creates a new API server
 func NewServer(
-	store *store.Store,
+	store Store,
 	categories *config.CategoriesConfig,
 	jsonOutDir string,
 	pmmOutDir string,
@@ -50,6 +62,16 @@ func NewServer(
 	}
 }
 
+// SetWebhookToken requires webhook requests to carry ?token=<token>
+func (s *Server) SetWebhookToken(token string) {
+	s.webhookToken = token
+}
+
+// SetWatchFunc sets a callback run for every recorded webhook watch event
+func (s *Server) SetWatchFunc(fn func(store.WatchEvent)) {
+	s.watchFunc = fn
+}
+
 // Start starts the HTTP server
 func (s *Server) Start() error {
 	r := mux.NewRouter()
@@ -59,8 +81,15 @@ func (s *Server) Start() error {
 	r.HandleFunc("/v1/runs/latest", s.handleLatestRun).Methods("GET")
 	r.HandleFunc("/v1/recs/{label}/latest", s.handleLatestRecs).Methods("GET")
 	r.HandleFunc("/v1/recs/{label}/latest/raw", s.handleLatestRecsRaw).Methods("GET")
+	r.HandleFunc("/v1/recs/{label}/latest/in_library", s.handleLatestRecsSplit(true)).Methods("GET")
+	r.HandleFunc("/v1/recs/{label}/latest/to_acquire", s.handleLatestRecsSplit(false)).Methods("GET")
+	r.HandleFunc("/v1/recs/{label}/{tmdb_id:[0-9]+}/feedback", s.handleRecordFeedback).Methods("POST")
+	r.HandleFunc("/v1/feedback", s.handleListFeedback).Methods("GET")
+	r.HandleFunc("/v1/stats/effectiveness", s.handleEffectiveness).Methods("GET")
 	r.HandleFunc("/v1/pmm/collections", s.handlePMMCollections).Methods("GET")
 	r.HandleFunc("/v1/run", s.handleTriggerRun).Methods("POST")
+	r.HandleFunc("/v1/webhooks/tautulli", s.handleTautulliWebhook).Methods("POST")
+	r.HandleFunc("/v1/webhooks/plex", s.handlePlexWebhook).Methods("POST")
 
 	log.Info().Str("addr", s.bindAddr).Msg("starting API server")
 	return http.ListenAndServe(s.bindAddr, r)
@@ -151,6 +180,40 @@ func (s *Server) handleLatestRecs(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
+// handleLatestRecsSplit serves only the in-library (or only the to-acquire)
+// picks of a category's latest resolved recommendations
+func (s *Server) handleLatestRecsSplit(inLibrary bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		label := vars["label"]
+
+		catRun, err := s.store.GetLatestCategoryRun(label)
+		if err != nil {
+			s.sendError(w, 500, "internal_error", "Failed to fetch category run")
+			return
+		}
+
+		if catRun == nil || catRun.ResolvedJSONPath == nil {
+			s.sendError(w, 404, "not_found", "No recommendations found for this category")
+			return
+		}
+
+		data, err := os.ReadFile(*catRun.ResolvedJSONPath)
+		if err != nil {
+			s.sendError(w, 500, "internal_error", "Failed to read recommendations file")
+			return
+		}
+
+		var resolved resolve.ResolvedOutput
+		if err := json.Unmarshal(data, &resolved); err != nil {
+			s.sendError(w, 500, "internal_error", "Failed to parse recommendations file")
+			return
+		}
+
+		s.sendJSON(w, resolved.Split(inLibrary))
+	}
+}
+
 func (s *Server) handleLatestRecsRaw(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	label := vars["label"]
@@ -192,10 +255,10 @@ func (s *Server) handlePMMCollections(w http.ResponseWriter, r *http.Request) {
 
 		info, _ := file.Info()
 		collections = append(collections, map[string]interface{}{
-			"filename":  file.Name(),
-			"path":      filepath.Join(s.pmmOutDir, file.Name()),
-			"size":      info.Size(),
-			"modified":  info.ModTime(),
+			"filename": file.Name(),
+			"path":     filepath.Join(s.pmmOutDir, file.Name()),
+			"size":     info.Size(),
+			"modified": info.ModTime(),
 		})
 	}
 
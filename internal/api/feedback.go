@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dppeppel/scryarr/internal/resolve"
+	"github.com/dppeppel/scryarr/internal/store"
+	"github.com/gorilla/mux"
+)
+
+// feedbackRequest is the body of a feedback POST. MediaType is only needed
+// for titles that are not in the category's latest recommendations.
+type feedbackRequest struct {
+	Feedback  string `json:"feedback"` // up, down, seen, not_interested
+	User      string `json:"user"`
+	MediaType string `json:"media_type"`
+	Note      string `json:"note"`
+}
+
+// handleRecordFeedback records a user's feedback on a recommended title
+func (s *Server) handleRecordFeedback(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	label := vars["label"]
+	tmdbID, err := strconv.Atoi(vars["tmdb_id"])
+	if err != nil || tmdbID <= 0 {
+		s.sendError(w, 400, "bad_request", "Invalid TMDb ID")
+		return
+	}
+
+	var req feedbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, 400, "bad_request", "Invalid feedback body: "+err.Error())
+		return
+	}
+	req.Feedback = strings.ToLower(strings.TrimSpace(req.Feedback))
+	if !validFeedback(req.Feedback) {
+		s.sendError(w, 400, "bad_request", "feedback must be one of: "+strings.Join(store.FeedbackKinds, ", "))
+		return
+	}
+
+	feedback := store.Feedback{
+		Label:     label,
+		TMDbID:    tmdbID,
+		MediaType: strings.ToLower(req.MediaType),
+		User:      req.User,
+		Feedback:  req.Feedback,
+		Note:      req.Note,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	if feedback.MediaType == "show" || feedback.MediaType == "series" {
+		feedback.MediaType = "tv"
+	}
+
+	// Fill in the title from the latest recommendations
+	if item := s.latestItem(label, tmdbID, feedback.MediaType); item != nil {
+		feedback.MediaType = item.Medium
+		feedback.Title = item.Title
+		feedback.Year = item.Year
+	}
+	if feedback.MediaType != "movie" && feedback.MediaType != "tv" {
+		s.sendError(w, 400, "bad_request", "Title is not in the latest recommendations; media_type (movie or tv) is required")
+		return
+	}
+
+	id, err := s.store.RecordFeedback(&feedback)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to record feedback")
+		s.sendError(w, 500, "internal_error", "Failed to record feedback")
+		return
+	}
+	log.Info().Str("label", label).Int("tmdb_id", tmdbID).Str("feedback", feedback.Feedback).Str("user", feedback.User).Msg("recorded feedback")
+
+	s.sendJSON(w, map[string]interface{}{"status": "recorded", "id": id, "feedback": feedback})
+}
+
+// handleListFeedback returns recorded feedback, newest first, optionally
+// limited to one category (?label=) or user (?user=)
+func (s *Server) handleListFeedback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	feedback, err := s.store.ListFeedback(query.Get("label"), query.Get("user"))
+	if err != nil {
+		s.sendError(w, 500, "internal_error", "Failed to fetch feedback")
+		return
+	}
+	if feedback == nil {
+		feedback = []store.Feedback{}
+	}
+
+	s.sendJSON(w, map[string]interface{}{"feedback": feedback})
+}
+
+// latestItem finds a title in the category's latest resolved
+// recommendations. An empty mediaType matches either medium.
+func (s *Server) latestItem(label string, tmdbID int, mediaType string) *resolve.ResolvedItem {
+	catRun, err := s.store.GetLatestCategoryRun(label)
+	if err != nil || catRun == nil || catRun.ResolvedJSONPath == nil {
+		return nil
+	}
+	data, err := os.ReadFile(*catRun.ResolvedJSONPath)
+	if err != nil {
+		return nil
+	}
+	var resolved resolve.ResolvedOutput
+	if err := json.Unmarshal(data, &resolved); err != nil {
+		return nil
+	}
+
+	for i, item := range resolved.Items {
+		if item.TMDbID == tmdbID && (mediaType == "" || item.Medium == mediaType) {
+			return &resolved.Items[i]
+		}
+	}
+	return nil
+}
+
+func validFeedback(kind string) bool {
+	for _, k := range store.FeedbackKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/dppeppel/scryarr/internal/metrics"
+	"github.com/dppeppel/scryarr/internal/store"
+)
+
+// effectivenessEntry is a stat with its rates
+type effectivenessEntry struct {
+	store.EffectivenessStat
+	metrics.Rates
+}
+
+// handleEffectiveness returns the recommendation effectiveness stats of the
+// latest job that computed them, or of ?job_id=, grouped by category, by
+// model and by experiment, whose variants are listed side by side
+func (s *Server) handleEffectiveness(w http.ResponseWriter, r *http.Request) {
+	var jobID int64
+	if v := r.URL.Query().Get("job_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			s.sendError(w, 400, "bad_request", "Invalid job_id")
+			return
+		}
+		jobID = id
+	}
+
+	stats, err := s.store.GetEffectiveness(jobID)
+	if err != nil {
+		s.sendError(w, 500, "internal_error", "Failed to fetch effectiveness stats")
+		return
+	}
+	if len(stats) == 0 {
+		s.sendError(w, 404, "not_found", "No effectiveness stats found")
+		return
+	}
+
+	categories := []effectivenessEntry{}
+	models := []effectivenessEntry{}
+	experiments := map[string][]effectivenessEntry{}
+	for _, st := range stats {
+		entry := effectivenessEntry{EffectivenessStat: st, Rates: metrics.RatesOf(st)}
+		switch st.Scope {
+		case store.StatScopeCategory:
+			categories = append(categories, entry)
+		case store.StatScopeModel:
+			models = append(models, entry)
+		case store.StatScopeVariant:
+			experiments[st.Experiment] = append(experiments[st.Experiment], entry)
+		}
+	}
+
+	s.sendJSON(w, map[string]interface{}{
+		"job_id":      stats[0].JobID,
+		"computed_at": stats[0].ComputedAt,
+		"categories":  categories,
+		"models":      models,
+		"experiments": experiments,
+	})
+}
@@ -0,0 +1,241 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dppeppel/scryarr/internal/plex"
+	"github.com/dppeppel/scryarr/internal/store"
+	"github.com/dppeppel/scryarr/internal/tautulli"
+)
+
+// tautulliActions are the notification actions that count as a watch
+var tautulliActions = map[string]bool{
+	"watched": true,
+	"stop":    true,
+}
+
+// plexEvents maps the Plex webhook events that are recorded to event names
+var plexEvents = map[string]string{
+	"media.scrobble": "scrobble",
+	"media.rate":     "rate",
+}
+
+// maxPlexWebhookMemory is the part of a Plex webhook body kept in memory; the
+// rest (the poster thumbnail) spills to a temporary file
+const maxPlexWebhookMemory = 1 << 20
+
+// plexPayload is the JSON "payload" part of a Plex webhook
+type plexPayload struct {
+	Event   string  `json:"event"`
+	Rating  float64 `json:"rating"` // media.rate on some server versions
+	Account struct {
+		Title string `json:"title"`
+	} `json:"Account"`
+	Metadata struct {
+		Type                 string              `json:"type"`
+		RatingKey            tautulli.FlexString `json:"ratingKey"`
+		ParentRatingKey      tautulli.FlexString `json:"parentRatingKey"`
+		GrandparentRatingKey tautulli.FlexString `json:"grandparentRatingKey"`
+		Title                string              `json:"title"`
+		GrandparentTitle     string              `json:"grandparentTitle"`
+		Year                 tautulli.FlexInt    `json:"year"`
+		GUID                 string              `json:"guid"`
+		GUIDs                []struct {
+			ID string `json:"id"`
+		} `json:"Guid"`
+		UserRating float64 `json:"userRating"`
+	} `json:"Metadata"`
+}
+
+// event converts the payload into a watch event. An episode's Guid list
+// names the episode, not the show, so episodes only take IDs from a legacy
+// agent GUID, which carries the show's. A rated show keeps its own IDs.
+func (p *plexPayload) event() store.WatchEvent {
+	meta := p.Metadata
+	ids := plex.ParseGUID(meta.GUID)
+	if meta.Type != "episode" {
+		for _, g := range meta.GUIDs {
+			ids.Merge(plex.ParseGUID(g.ID))
+		}
+	}
+
+	event := store.WatchEvent{
+		Source:               store.EventSourcePlex,
+		Event:                plexEvents[p.Event],
+		User:                 p.Account.Title,
+		RatingKey:            string(meta.RatingKey),
+		ParentRatingKey:      string(meta.ParentRatingKey),
+		GrandparentRatingKey: string(meta.GrandparentRatingKey),
+		MediaType:            meta.Type,
+		Title:                meta.Title,
+		GrandparentTitle:     meta.GrandparentTitle,
+		Year:                 int(meta.Year),
+		GUID:                 meta.GUID,
+		TMDbID:               ids.TMDbID,
+		IMDbID:               ids.IMDbID,
+		TVDBID:               ids.TVDBID,
+		OccurredAt:           time.Now(),
+	}
+	switch event.Event {
+	case "scrobble":
+		// Plex scrobbles once 90% has been played
+		event.PercentComplete = 100
+	case "rate":
+		event.Rating = meta.UserRating
+		if p.Rating > 0 {
+			event.Rating = p.Rating
+		}
+	}
+	return event
+}
+
+// tautulliPayload is the JSON body of a Tautulli webhook notification agent.
+// Tautulli fills the values from the agent's JSON data template, so numbers
+// may arrive as strings and unknown parameters as empty strings.
+type tautulliPayload struct {
+	Action               string              `json:"action"`
+	User                 string              `json:"user"`
+	RatingKey            tautulli.FlexString `json:"rating_key"`
+	ParentRatingKey      tautulli.FlexString `json:"parent_rating_key"`
+	GrandparentRatingKey tautulli.FlexString `json:"grandparent_rating_key"`
+	MediaType            string              `json:"media_type"`
+	Title                string              `json:"title"`
+	ShowName             string              `json:"show_name"`
+	Year                 tautulli.FlexInt    `json:"year"`
+	ProgressPercent      tautulli.FlexInt    `json:"progress_percent"`
+	TMDbID               tautulli.FlexInt    `json:"themoviedb_id"`
+	IMDbID               string              `json:"imdb_id"`
+	TVDBID               tautulli.FlexInt    `json:"thetvdb_id"`
+	GUID                 string              `json:"guid"`
+	Timestamp            tautulli.FlexInt    `json:"timestamp"` // unix seconds
+}
+
+// event converts the payload into a watch event
+func (p *tautulliPayload) event() store.WatchEvent {
+	ids := plex.ExternalIDs{TMDbID: int(p.TMDbID), IMDbID: p.IMDbID, TVDBID: int(p.TVDBID)}
+	ids.Merge(plex.ParseGUID(p.GUID))
+
+	occurredAt := time.Now()
+	if p.Timestamp > 0 {
+		occurredAt = time.Unix(int64(p.Timestamp), 0)
+	}
+
+	return store.WatchEvent{
+		Source:               store.EventSourceTautulli,
+		Event:                strings.ToLower(p.Action),
+		User:                 p.User,
+		RatingKey:            string(p.RatingKey),
+		ParentRatingKey:      string(p.ParentRatingKey),
+		GrandparentRatingKey: string(p.GrandparentRatingKey),
+		MediaType:            p.MediaType,
+		Title:                p.Title,
+		GrandparentTitle:     p.ShowName,
+		Year:                 int(p.Year),
+		GUID:                 p.GUID,
+		TMDbID:               ids.TMDbID,
+		IMDbID:               ids.IMDbID,
+		TVDBID:               ids.TVDBID,
+		PercentComplete:      int(p.ProgressPercent),
+		OccurredAt:           occurredAt,
+	}
+}
+
+// checkWebhookToken rejects the request unless it carries the configured
+// token. With no token configured every request is accepted.
+func (s *Server) checkWebhookToken(w http.ResponseWriter, r *http.Request) bool {
+	if s.webhookToken == "" {
+		return true
+	}
+	token := r.URL.Query().Get("token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.webhookToken)) != 1 {
+		s.sendError(w, 401, "unauthorized", "Missing or invalid webhook token")
+		return false
+	}
+	return true
+}
+
+// recordWatch stores a webhook event and hands it to the watch callback
+func (s *Server) recordWatch(w http.ResponseWriter, event store.WatchEvent) {
+	if _, err := s.store.RecordWatchEvent(&event); err != nil {
+		log.Error().Err(err).Str("source", event.Source).Msg("failed to record watch event")
+		s.sendError(w, 500, "internal_error", "Failed to record watch event")
+		return
+	}
+
+	log.Info().Str("source", event.Source).Str("event", event.Event).Str("user", event.User).
+		Str("title", event.Title).Msg("recorded watch event")
+	if s.watchFunc != nil {
+		s.watchFunc(event)
+	}
+
+	s.sendJSON(w, map[string]interface{}{
+		"status": "recorded",
+		"id":     event.ID,
+	})
+}
+
+func (s *Server) handleTautulliWebhook(w http.ResponseWriter, r *http.Request) {
+	if !s.checkWebhookToken(w, r) {
+		return
+	}
+
+	var payload tautulliPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		s.sendError(w, 400, "bad_request", "Invalid Tautulli payload: "+err.Error())
+		return
+	}
+
+	// Other notifications (play, pause, recently added...) and music are
+	// acknowledged so Tautulli does not retry them
+	mediaType := strings.ToLower(payload.MediaType)
+	if !tautulliActions[strings.ToLower(payload.Action)] || (mediaType != "movie" && mediaType != "episode") {
+		s.sendJSON(w, map[string]string{"status": "ignored"})
+		return
+	}
+	if payload.User == "" {
+		s.sendError(w, 400, "bad_request", "Payload has no user")
+		return
+	}
+
+	payload.MediaType = mediaType
+	s.recordWatch(w, payload.event())
+}
+
+func (s *Server) handlePlexWebhook(w http.ResponseWriter, r *http.Request) {
+	if !s.checkWebhookToken(w, r) {
+		return
+	}
+
+	if err := r.ParseMultipartForm(maxPlexWebhookMemory); err != nil {
+		s.sendError(w, 400, "bad_request", "Invalid Plex webhook body: "+err.Error())
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	var payload plexPayload
+	if err := json.Unmarshal([]byte(r.FormValue("payload")), &payload); err != nil {
+		s.sendError(w, 400, "bad_request", "Invalid Plex payload: "+err.Error())
+		return
+	}
+
+	// Plex sends every playback and library event to every webhook. Watches
+	// are kept for movies and episodes, ratings for movies and shows.
+	event := plexEvents[payload.Event]
+	mediaType := payload.Metadata.Type
+	watchable := mediaType == "movie" || mediaType == "episode"
+	rateable := mediaType == "movie" || mediaType == "show"
+	if event == "" || (event == "scrobble" && !watchable) || (event == "rate" && !rateable) {
+		s.sendJSON(w, map[string]string{"status": "ignored"})
+		return
+	}
+	if payload.Account.Title == "" {
+		s.sendError(w, 400, "bad_request", "Payload has no account")
+		return
+	}
+
+	s.recordWatch(w, payload.event())
+}
@@ -0,0 +1,226 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dppeppel/scryarr/internal/store"
+)
+
+// newWebhookServer returns an API server on a memory store and the events
+// its watch callback received
+func newWebhookServer(token string) (*Server, *store.MemoryStore, *[]store.WatchEvent) {
+	db := store.NewMemoryStore()
+	s := NewServer(db, nil, "", "", "", nil)
+	s.SetWebhookToken(token)
+	var received []store.WatchEvent
+	s.SetWatchFunc(func(e store.WatchEvent) { received = append(received, e) })
+	return s, db, &received
+}
+
+// status decodes the "status" field of a webhook response
+func status(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	s, _ := body["status"].(string)
+	return s
+}
+
+func TestTautulliWebhook(t *testing.T) {
+	s, db, received := newWebhookServer("")
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handleTautulliWebhook(w, httptest.NewRequest("POST", "/v1/webhooks/tautulli", strings.NewReader(body)))
+		return w
+	}
+
+	// Tautulli's JSON template sends numbers as strings and blanks as ""
+	w := post(`{"action": "Watched", "user": "alice", "rating_key": "10", "media_type": "Movie", "title": "Contact",
+		"year": "1997", "progress_percent": "92", "themoviedb_id": "", "guid": "com.plexapp.agents.themoviedb://686?lang=en",
+		"timestamp": "1780000000"}`)
+	if w.Code != http.StatusOK || status(t, w) != "recorded" {
+		t.Fatalf("watched: %d %s", w.Code, w.Body)
+	}
+	if len(*received) != 1 {
+		t.Fatalf("watch callback got %d events, want 1", len(*received))
+	}
+	e := (*received)[0]
+	if e.Source != store.EventSourceTautulli || e.Event != "watched" || e.MediaType != "movie" || e.User != "alice" ||
+		e.RatingKey != "10" || e.Year != 1997 || e.PercentComplete != 92 || e.TMDbID != 686 ||
+		!e.OccurredAt.Equal(time.Unix(1780000000, 0)) {
+		t.Errorf("event = %+v", e)
+	}
+
+	for name, body := range map[string]string{
+		"other action": `{"action": "play", "user": "alice", "rating_key": 10, "media_type": "movie"}`,
+		"music":        `{"action": "watched", "user": "alice", "rating_key": 10, "media_type": "track"}`,
+	} {
+		if w := post(body); w.Code != http.StatusOK || status(t, w) != "ignored" {
+			t.Errorf("%s: %d %s", name, w.Code, w.Body)
+		}
+	}
+	for name, body := range map[string]string{
+		"no user":  `{"action": "stop", "rating_key": 10, "media_type": "movie"}`,
+		"not json": `action=watched`,
+	} {
+		if w := post(body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", name, w.Code)
+		}
+	}
+
+	events, err := db.GetWatchEventsSince(time.Unix(0, 0), store.EventSourceTautulli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || len(*received) != 1 {
+		t.Errorf("recorded %d events, called back %d times, want only the watch", len(events), len(*received))
+	}
+}
+
+func TestWebhookToken(t *testing.T) {
+	s, _, received := newWebhookServer("s3cret")
+	body := `{"action": "watched", "user": "alice", "rating_key": 10, "media_type": "movie", "title": "Contact"}`
+
+	for _, target := range []string{"/v1/webhooks/tautulli", "/v1/webhooks/tautulli?token=wrong"} {
+		w := httptest.NewRecorder()
+		s.handleTautulliWebhook(w, httptest.NewRequest("POST", target, strings.NewReader(body)))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want 401", target, w.Code)
+		}
+	}
+	w := httptest.NewRecorder()
+	s.handleTautulliWebhook(w, httptest.NewRequest("POST", "/v1/webhooks/tautulli?token=s3cret", strings.NewReader(body)))
+	if w.Code != http.StatusOK || len(*received) != 1 {
+		t.Errorf("valid token: %d %s", w.Code, w.Body)
+	}
+}
+
+// plexRequest builds a Plex webhook request: a multipart form with the JSON
+// payload and, as Plex sends for some events, a poster thumbnail
+func plexRequest(t *testing.T, target, payload string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if err := form.WriteField("payload", payload); err != nil {
+		t.Fatal(err)
+	}
+	thumb, err := form.CreateFormFile("thumb", "thumb.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumb.Write(bytes.Repeat([]byte{0xff}, 4096))
+	form.Close()
+
+	r := httptest.NewRequest("POST", target, &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	return r
+}
+
+func TestPlexWebhook(t *testing.T) {
+	s, _, received := newWebhookServer("s3cret")
+	post := func(target, payload string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handlePlexWebhook(w, plexRequest(t, target, payload))
+		return w
+	}
+	const target = "/v1/webhooks/plex?token=s3cret"
+
+	// Watches and ratings are recorded
+	recorded := []struct {
+		name, payload string
+		want          store.WatchEvent
+	}{
+		{
+			name: "movie scrobble",
+			payload: `{"event": "media.scrobble", "Account": {"title": "alice"}, "Metadata": {"type": "movie",
+				"ratingKey": "10", "title": "Contact", "year": 1997, "guid": "plex://movie/5d7768",
+				"Guid": [{"id": "imdb://tt0118884"}, {"id": "tmdb://686"}]}}`,
+			want: store.WatchEvent{Event: "scrobble", MediaType: "movie", RatingKey: "10", Title: "Contact", Year: 1997,
+				TMDbID: 686, IMDbID: "tt0118884", PercentComplete: 100},
+		},
+		{
+			name: "episode scrobble",
+			payload: `{"event": "media.scrobble", "Account": {"title": "alice"}, "Metadata": {"type": "episode",
+				"ratingKey": 21, "grandparentRatingKey": 20, "title": "Dulcinea", "grandparentTitle": "The Expanse",
+				"guid": "com.plexapp.agents.thetvdb://280619/1/1?lang=en", "Guid": [{"id": "tmdb://1000001"}]}}`,
+			want: store.WatchEvent{Event: "scrobble", MediaType: "episode", RatingKey: "21", GrandparentRatingKey: "20",
+				Title: "Dulcinea", GrandparentTitle: "The Expanse", TVDBID: 280619, PercentComplete: 100},
+		},
+		{
+			name: "movie rating",
+			payload: `{"event": "media.rate", "Account": {"title": "alice"}, "Metadata": {"type": "movie",
+				"ratingKey": "10", "title": "Contact", "userRating": 9, "Guid": [{"id": "tmdb://686"}]}}`,
+			want: store.WatchEvent{Event: "rate", MediaType: "movie", RatingKey: "10", Title: "Contact", TMDbID: 686, Rating: 9},
+		},
+		{
+			name: "show rating in the top-level field",
+			payload: `{"event": "media.rate", "rating": 8, "Account": {"title": "alice"}, "Metadata": {"type": "show",
+				"ratingKey": "20", "title": "The Expanse", "Guid": [{"id": "tmdb://63639"}]}}`,
+			want: store.WatchEvent{Event: "rate", MediaType: "show", RatingKey: "20", Title: "The Expanse", TMDbID: 63639, Rating: 8},
+		},
+	}
+	for _, tt := range recorded {
+		*received = nil
+		w := post(target, tt.payload)
+		if w.Code != http.StatusOK || status(t, w) != "recorded" || len(*received) != 1 {
+			t.Errorf("%s: %d %s", tt.name, w.Code, w.Body)
+			continue
+		}
+		got := (*received)[0]
+		if got.Source != store.EventSourcePlex || got.User != "alice" {
+			t.Errorf("%s: event = %+v", tt.name, got)
+		}
+		got.ID, got.Source, got.User, got.GUID, got.ParentRatingKey, got.OccurredAt = 0, "", "", "", "", time.Time{}
+		if got != tt.want {
+			t.Errorf("%s: event = %+v\nwant %+v", tt.name, got, tt.want)
+		}
+	}
+
+	// Other events and media types are acknowledged without recording
+	*received = nil
+	for name, payload := range map[string]string{
+		"playback event": `{"event": "media.play", "Account": {"title": "alice"}, "Metadata": {"type": "movie", "ratingKey": "10"}}`,
+		"library event":  `{"event": "library.new", "Metadata": {"type": "movie", "ratingKey": "10"}}`,
+		"track scrobble": `{"event": "media.scrobble", "Account": {"title": "alice"}, "Metadata": {"type": "track", "ratingKey": "30"}}`,
+		"show scrobble":  `{"event": "media.scrobble", "Account": {"title": "alice"}, "Metadata": {"type": "show", "ratingKey": "20"}}`,
+		"episode rating": `{"event": "media.rate", "Account": {"title": "alice"}, "Metadata": {"type": "episode", "ratingKey": "21", "userRating": 4}}`,
+		"season rating":  `{"event": "media.rate", "Account": {"title": "alice"}, "Metadata": {"type": "season", "ratingKey": "22", "userRating": 2}}`,
+	} {
+		if w := post(target, payload); w.Code != http.StatusOK || status(t, w) != "ignored" {
+			t.Errorf("%s: %d %s", name, w.Code, w.Body)
+		}
+	}
+	if len(*received) != 0 {
+		t.Errorf("ignored payloads recorded %+v", *received)
+	}
+
+	// Bad requests
+	if w := post(target, `{"event": "media.scrobble", "Metadata": {"type": "movie", "ratingKey": "10"}}`); w.Code != http.StatusBadRequest {
+		t.Errorf("no account: status = %d, want 400", w.Code)
+	}
+	if w := post(target, `not json`); w.Code != http.StatusBadRequest {
+		t.Errorf("bad payload: status = %d, want 400", w.Code)
+	}
+	w := httptest.NewRecorder()
+	s.handlePlexWebhook(w, httptest.NewRequest("POST", target, strings.NewReader(`{"event": "media.scrobble"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("JSON body: status = %d, want 400", w.Code)
+	}
+
+	// The token is checked before the body
+	payload := recorded[0].payload
+	for _, bad := range []string{"/v1/webhooks/plex", "/v1/webhooks/plex?token=wrong"} {
+		if w := post(bad, payload); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want 401", bad, w.Code)
+		}
+	}
+}
@@ -3,50 +3,205 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/goccy/go-yaml"
 )
 
 // AppConfig represents the main application configuration from app.yml
 type AppConfig struct {
-	App          AppSettings          `yaml:"app"`
-	Paths        PathSettings         `yaml:"paths"`
-	Tautulli     TautulliSettings     `yaml:"tautulli"`
-	Plex         PlexSettings         `yaml:"plex"`
-	Recommender  RecommenderSettings  `yaml:"recommender"`
-	Overseerr    OverseerrSettings    `yaml:"overseerr"`
-	API          APISettings          `yaml:"api"`
+	App         AppSettings         `yaml:"app"`
+	Paths       PathSettings        `yaml:"paths"`
+	Tautulli    TautulliSettings    `yaml:"tautulli"`
+	History     HistorySettings     `yaml:"history"`
+	Plex        PlexSettings        `yaml:"plex"`
+	Recommender RecommenderSettings `yaml:"recommender"`
+	Overseerr   OverseerrSettings   `yaml:"overseerr"`
+	Publish     PublishSettings     `yaml:"publish"`
+	API         APISettings         `yaml:"api"`
+	Webhooks    WebhookSettings     `yaml:"webhooks"`
+	UserGroups  map[string][]string `yaml:"user_groups"` // group name -> Tautulli usernames
+	Experiments []Experiment        `yaml:"experiments,omitempty"`
 }
 
 type AppSettings struct {
-	Mode         string `yaml:"mode"`           // oneshot | loop
-	ScheduleCron string `yaml:"schedule_cron"`  // cron schedule for loop mode
-	LogLevel     string `yaml:"log_level"`      // info, debug, warn, error
+	Mode         string `yaml:"mode"`          // oneshot | loop
+	ScheduleCron string `yaml:"schedule_cron"` // cron schedule for loop mode
+	LogLevel     string `yaml:"log_level"`     // info, debug, warn, error
 }
 
 type PathSettings struct {
-	DBPath      string `yaml:"db_path"`
-	JSONOutDir  string `yaml:"json_out_dir"`
-	PMMOutDir   string `yaml:"pmm_out_dir"`
+	DBPath     string `yaml:"db_path"`
+	DBURL      string `yaml:"db_url"` // postgres://... overrides db_path; DB_URL env wins
+	JSONOutDir string `yaml:"json_out_dir"`
+	PMMOutDir  string `yaml:"pmm_out_dir"`
 }
 
 type TautulliSettings struct {
 	URL          string `yaml:"url"`
 	APIKey       string `yaml:"-"` // loaded from env
 	LookbackDays int    `yaml:"lookback_days"`
+	PlexServer   string `yaml:"plex_server"` // Plex server Tautulli monitors (default: the first)
+}
+
+// HistorySettings selects where watch history comes from
+type HistorySettings struct {
+	Source string `yaml:"source"` // tautulli (default) | plex (webhook events) | both
 }
 
 type PlexSettings struct {
-	URL   string `yaml:"url"`
-	Token string `yaml:"-"` // loaded from env
+	URL      string `yaml:"url"`
+	Token    string `yaml:"-"`         // loaded from env
+	PageSize int    `yaml:"page_size"` // items per library page (default 500)
+	// Hours between full inventory reconciles; runs in between only fetch
+	// items updated since the last sync (default 168)
+	FullSyncHours int                `yaml:"full_sync_hours"`
+	Servers       []PlexServer       `yaml:"servers,omitempty"`
+	Signals       PlexSignalSettings `yaml:"signals"`
+}
+
+// PlexSignalSettings reads explicit taste signals (star ratings and the
+// Discover watchlist) for the listed users
+type PlexSignalSettings struct {
+	Ratings     bool       `yaml:"ratings"`
+	Watchlist   bool       `yaml:"watchlist"`
+	HighRating  float64    `yaml:"high_rating"`            // 0-10; at or above is highly rated (default 8)
+	LowRating   float64    `yaml:"low_rating"`             // 0-10; at or below is a negative example (default 4)
+	Users       []PlexUser `yaml:"users,omitempty"`        // empty = server owner only
+	DiscoverURL string     `yaml:"discover_url,omitempty"` // override for the Plex Discover API
+}
+
+// PlexServer is a single Plex Media Server and the library sections to inventory
+type PlexServer struct {
+	Name            string   `yaml:"name"`
+	URL             string   `yaml:"url"`
+	TokenEnv        string   `yaml:"token_env,omitempty"`        // env var holding the token (default PLEX_TOKEN)
+	Token           string   `yaml:"-"`                          // loaded from env
+	IncludeSections []string `yaml:"include_sections,omitempty"` // section keys or titles; empty = all
+	ExcludeSections []string `yaml:"exclude_sections,omitempty"` // section keys or titles
+}
+
+// DefaultPlexServerName names the server implied by the top-level plex.url
+const DefaultPlexServerName = "default"
+
+// AllServers returns the configured Plex servers. A bare plex.url without a
+// servers list is treated as a single server named "default".
+func (p PlexSettings) AllServers() []PlexServer {
+	if len(p.Servers) > 0 {
+		return p.Servers
+	}
+	if p.URL == "" {
+		return nil
+	}
+	return []PlexServer{{Name: DefaultPlexServerName, URL: p.URL, Token: p.Token}}
 }
 
 type RecommenderSettings struct {
-	Model               string   `yaml:"model"`
-	RecsPerCategory     int      `yaml:"recs_per_category"`
-	DiversityMinFrac    float64  `yaml:"diversity_min_fraction"`
-	RecencyWeight       float64  `yaml:"recency_weight"`
-	AllowMediaTypes     []string `yaml:"allow_media_types"`
+	Model              string                 `yaml:"model"`
+	RecsPerCategory    int                    `yaml:"recs_per_category"`
+	DiversityMinFrac   float64                `yaml:"diversity_min_fraction"`
+	RecencyWeight      float64                `yaml:"recency_weight"`
+	AllowMediaTypes    []string               `yaml:"allow_media_types"`
+	ProfileTitles      int                    `yaml:"profile_titles"`       // titles in the weighted taste profile (default 25)
+	LibraryCandidates  int                    `yaml:"library_candidates"`   // titles offered to library-scope categories (default 300)
+	Temperature        *float64               `yaml:"temperature"`          // LLM sampling temperature (default 0.7)
+	DedupDays          int                    `yaml:"dedup_days"`           // days before a title may be recommended again (default 60)
+	CrossCategoryDedup bool                   `yaml:"cross_category_dedup"` // also skip titles other categories recommended; see Category.Priority
+	Provider           string                 `yaml:"provider"`             // providers entry to use; empty uses LLM_API_BASE/LLM_API_KEY
+	Providers          map[string]LLMProvider `yaml:"providers,omitempty"`
+	PromptsDir         string                 `yaml:"prompts_dir"` // prompt templates; default: prompts/ next to app.yml
+}
+
+// LLMProvider is an OpenAI-compatible endpoint categories can select
+type LLMProvider struct {
+	APIBase   string `yaml:"api_base"`
+	APIKeyEnv string `yaml:"api_key_env"` // env var holding the API key
+	APIKey    string `yaml:"-"`           // loaded from env
+}
+
+// RecommenderOverrides replace the recommender settings for one category.
+// Unset fields keep the global value.
+type RecommenderOverrides struct {
+	Model        string   `yaml:"model,omitempty"`
+	Provider     string   `yaml:"provider,omitempty"`
+	Temperature  *float64 `yaml:"temperature,omitempty"`
+	Count        int      `yaml:"count,omitempty"`         // recommendations to ask for
+	LookbackDays int      `yaml:"lookback_days,omitempty"` // history window for the taste profile
+	DedupDays    int      `yaml:"dedup_days,omitempty"`
+}
+
+// Recommender defaults
+const (
+	DefaultTemperature = 0.7
+	DefaultDedupDays   = 60
+)
+
+// RecommenderRun is the effective recommender configuration for one
+// category: the global settings with the category's overrides applied
+type RecommenderRun struct {
+	Model        string  `json:"model"`
+	Provider     string  `json:"provider,omitempty"`
+	Temperature  float64 `json:"temperature"`
+	Count        int     `json:"count"`
+	LookbackDays int     `json:"lookback_days"`
+	DedupDays    int     `json:"dedup_days"`
+}
+
+// RecommenderFor merges a category's recommender overrides over the global
+// settings
+func (c *AppConfig) RecommenderFor(category *Category) RecommenderRun {
+	rec := c.Recommender
+	run := RecommenderRun{
+		Model:        rec.Model,
+		Provider:     rec.Provider,
+		Temperature:  DefaultTemperature,
+		Count:        rec.RecsPerCategory,
+		LookbackDays: c.Tautulli.LookbackDays,
+		DedupDays:    rec.DedupDays,
+	}
+	if rec.Temperature != nil {
+		run.Temperature = *rec.Temperature
+	}
+	if run.DedupDays <= 0 {
+		run.DedupDays = DefaultDedupDays
+	}
+
+	if o := category.Recommender; o != nil {
+		if o.Model != "" {
+			run.Model = o.Model
+		}
+		if o.Provider != "" {
+			run.Provider = o.Provider
+		}
+		if o.Temperature != nil {
+			run.Temperature = *o.Temperature
+		}
+		if o.Count > 0 {
+			run.Count = o.Count
+		}
+		if o.LookbackDays > 0 {
+			run.LookbackDays = o.LookbackDays
+		}
+		if o.DedupDays > 0 {
+			run.DedupDays = o.DedupDays
+		}
+	}
+	return run
+}
+
+// LLMConfigFor returns the endpoint of a named provider; the empty name is
+// the LLM_API_BASE/LLM_API_KEY endpoint
+func (c *AppConfig) LLMConfigFor(provider string) (*LLMConfig, error) {
+	if provider == "" {
+		return LoadLLMConfig(), nil
+	}
+	p, ok := c.Recommender.Providers[provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown LLM provider %q", provider)
+	}
+	return &LLMConfig{APIBase: p.APIBase, APIKey: p.APIKey}, nil
 }
 
 type OverseerrSettings struct {
@@ -56,26 +211,205 @@ type OverseerrSettings struct {
 	RequestsPerCategory int    `yaml:"requests_per_category"`
 }
 
+// PublishSettings selects the publish targets. PMM YAML output is on by
+// default; the Plex target manages collections directly through the Plex API.
+type PublishSettings struct {
+	PMM  PMMPublishSettings  `yaml:"pmm"`
+	Plex PlexPublishSettings `yaml:"plex"`
+}
+
+type PMMPublishSettings struct {
+	Enabled *bool `yaml:"enabled"` // default true
+}
+
+// IsEnabled reports whether PMM YAML files should be written
+func (p PMMPublishSettings) IsEnabled() bool {
+	return p.Enabled == nil || *p.Enabled
+}
+
+// PlexPublishSettings controls collections created through the Plex API for
+// recommended titles already in the library
+type PlexPublishSettings struct {
+	Enabled          bool   `yaml:"enabled"`
+	Mode             string `yaml:"mode"`              // collection (default) | smart_label
+	CollectionPrefix string `yaml:"collection_prefix"` // default "!01_Recommended — "
+	Summary          string `yaml:"summary"`
+	Sort             string `yaml:"sort"` // rank (default) | title | release
+	// Where collection hubs are promoted; unset leaves visibility untouched
+	Visibility *HubVisibility       `yaml:"visibility,omitempty"`
+	Playlists  PlexPlaylistSettings `yaml:"playlists"`
+}
+
+// HubVisibility selects the Plex hubs a collection is promoted to
+type HubVisibility struct {
+	Recommended bool `yaml:"recommended"` // library Recommended tab
+	Home        bool `yaml:"home"`        // server owner's Home screen
+	Shared      bool `yaml:"shared"`      // shared users' Home screens
+}
+
+// PlexPlaylistSettings mirrors each category's picks into a playlist per user
+type PlexPlaylistSettings struct {
+	Enabled    bool       `yaml:"enabled"`
+	MediaTypes []string   `yaml:"media_types,omitempty"` // default [movie]; shows expand to every episode
+	Users      []PlexUser `yaml:"users,omitempty"`       // empty = server owner only
+}
+
+// PlexUser is a Plex account that receives playlists
+type PlexUser struct {
+	Name     string   `yaml:"name"`
+	TokenEnv string   `yaml:"token_env,omitempty"` // env var holding the user's token; empty = server token
+	Token    string   `yaml:"-"`                   // loaded from env
+	Servers  []string `yaml:"servers,omitempty"`   // servers the token is valid for; empty = all
+}
+
 type APISettings struct {
 	Enabled  bool   `yaml:"enabled"`
 	BindAddr string `yaml:"bind_addr"`
 }
 
+// WebhookSettings controls the /v1/webhooks endpoints
+type WebhookSettings struct {
+	Token string `yaml:"-"` // loaded from env; required as ?token= when set
+	// Refresh re-runs the categories a watch affects once events stop
+	// arriving for DebounceMinutes (default 10)
+	Refresh         bool `yaml:"refresh"`
+	DebounceMinutes int  `yaml:"debounce_minutes"`
+}
+
 // CategoriesConfig represents the categories.yml configuration
 type CategoriesConfig struct {
 	Categories []Category `yaml:"categories"`
 }
 
 type Category struct {
-	Label         string            `yaml:"label"`
-	Type          string            `yaml:"type"` // genre, title_seed, keyword, seed_list
-	MediaTypes    []string          `yaml:"media_types"`
-	TMDbFilters   *TMDbFilters      `yaml:"tmdb_filters,omitempty"`
-	KeywordsPrefer []string         `yaml:"keywords_prefer,omitempty"`
-	KeywordsAvoid  []string         `yaml:"keywords_avoid,omitempty"`
-	MoodKeywords   []string         `yaml:"mood_keywords,omitempty"`
-	Seed          *TitleSeed        `yaml:"seed,omitempty"`
-	Seeds         []TitleSeed       `yaml:"seeds,omitempty"`
+	Label          string                `yaml:"label"`
+	Type           string                `yaml:"type"`            // genre, title_seed, keyword, seed_list
+	Scope          string                `yaml:"scope,omitempty"` // discover (default) | library
+	User           string                `yaml:"user,omitempty"`  // Tautulli username the category is for
+	Group          string                `yaml:"group,omitempty"` // or a user_groups entry
+	MediaTypes     []string              `yaml:"media_types"`
+	TMDbFilters    *TMDbFilters          `yaml:"tmdb_filters,omitempty"`
+	KeywordsPrefer []string              `yaml:"keywords_prefer,omitempty"`
+	KeywordsAvoid  []string              `yaml:"keywords_avoid,omitempty"`
+	MoodKeywords   []string              `yaml:"mood_keywords,omitempty"`
+	Seed           *TitleSeed            `yaml:"seed,omitempty"`
+	Seeds          []TitleSeed           `yaml:"seeds,omitempty"`
+	RecentSeeds    *RecentSeeds          `yaml:"recent_seeds,omitempty"` // for type recent_seeds
+	Recommender    *RecommenderOverrides `yaml:"recommender,omitempty"`
+	Instructions   string                `yaml:"instructions,omitempty"` // free-text guidance sent to the LLM
+	Prompt         string                `yaml:"prompt,omitempty"`       // prompt template in prompts_dir, without .tmpl
+	Priority       int                   `yaml:"priority,omitempty"`     // higher runs first and wins titles under cross_category_dedup
+	Pins           []Pin                 `yaml:"pins,omitempty"`         // titles kept in every run's picks
+	Library        *LibraryScope         `yaml:"library,omitempty"`
+	Plex           *CategoryPlex         `yaml:"plex,omitempty"`
+}
+
+// Category scopes. Discover categories suggest new titles to acquire;
+// library categories pick unwatched titles from the Plex inventory.
+const (
+	ScopeDiscover = "discover"
+	ScopeLibrary  = "library"
+)
+
+// TypeRecentSeeds is a dynamic category type. Each run it expands into one
+// title_seed category per recently finished title; see RecentSeeds.
+const TypeRecentSeeds = "recent_seeds"
+
+// Placeholders in a recent_seeds label, replaced with the seed title
+const (
+	SeedTitlePlaceholder = "{title}"
+	SeedYearPlaceholder  = "{year}"
+)
+
+// RecentSeeds configures how a recent_seeds category picks its seeds from
+// the audience's history
+type RecentSeeds struct {
+	Count      int     `yaml:"count"`       // seed categories to generate (default 3)
+	Order      string  `yaml:"order"`       // recent (default) | rating
+	MinRating  float64 `yaml:"min_rating"`  // only seed titles rated at least this (0-10); 0 allows unrated
+	WithinDays int     `yaml:"within_days"` // only titles finished in this many days (default: the whole lookback)
+}
+
+// Orders for RecentSeeds
+const (
+	SeedOrderRecent = "recent"
+	SeedOrderRating = "rating"
+)
+
+// IsDynamic reports whether the category expands into generated categories
+// at run time
+func (c *Category) IsDynamic() bool {
+	return c.Type == TypeRecentSeeds
+}
+
+// SeedCategory returns the title_seed category generated from a dynamic
+// category for one seed. The label is the dynamic label with {title} and
+// {year} filled in, or the seed title appended when it has no {title}.
+func (c *Category) SeedCategory(seed TitleSeed) Category {
+	label := c.Label
+	if !strings.Contains(label, SeedTitlePlaceholder) {
+		label += " — " + SeedTitlePlaceholder
+	}
+	year := ""
+	if seed.Year > 0 {
+		year = strconv.Itoa(seed.Year)
+	}
+	label = strings.NewReplacer(SeedTitlePlaceholder, seed.Title, SeedYearPlaceholder, year).Replace(label)
+
+	generated := *c
+	generated.Label = strings.TrimSpace(strings.ReplaceAll(label, "()", ""))
+	generated.Type = "title_seed"
+	generated.Seed = &seed
+	generated.Seeds = nil
+	generated.RecentSeeds = nil
+	return generated
+}
+
+// IsLibraryScope reports whether the category mines the existing library
+func (c *Category) IsLibraryScope() bool {
+	return strings.EqualFold(c.Scope, ScopeLibrary)
+}
+
+// AudienceName returns the user or group the category targets, or "" when
+// it is for everyone
+func (c *Category) AudienceName() string {
+	if c.User != "" {
+		return c.User
+	}
+	return c.Group
+}
+
+// AudienceUsers resolves the category's user or group to Tautulli usernames.
+// It returns nil for categories meant for everyone.
+func (c *Category) AudienceUsers(groups map[string][]string) ([]string, error) {
+	switch {
+	case c.User != "" && c.Group != "":
+		return nil, fmt.Errorf("category %q sets both user and group", c.Label)
+	case c.User != "":
+		return []string{c.User}, nil
+	case c.Group != "":
+		users, ok := groups[c.Group]
+		if !ok || len(users) == 0 {
+			return nil, fmt.Errorf("category %q references unknown user group %q", c.Label, c.Group)
+		}
+		return users, nil
+	}
+	return nil, nil
+}
+
+// CategoryPlex overrides the Plex publish settings for one category
+type CategoryPlex struct {
+	Visibility *HubVisibility `yaml:"visibility,omitempty"`
+	Playlists  *bool          `yaml:"playlists,omitempty"`
+}
+
+// LibraryScope selects which Plex servers/sections count as "already in the
+// library" for a category. Sections match by key or title. An empty scope
+// means every inventoried section.
+type LibraryScope struct {
+	Servers         []string `yaml:"servers,omitempty"`
+	IncludeSections []string `yaml:"include_sections,omitempty"`
+	ExcludeSections []string `yaml:"exclude_sections,omitempty"`
 }
 
 type TMDbFilters struct {
@@ -89,6 +423,16 @@ type TitleSeed struct {
 	Medium string `yaml:"medium"` // movie | tv
 }
 
+// Pin is a title a category keeps recommending on every run, exempt from
+// dedup. Give either the TMDb ID or the title (and year) to search for.
+type Pin struct {
+	TMDbID int    `yaml:"tmdb_id,omitempty"`
+	Title  string `yaml:"title,omitempty"`
+	Year   int    `yaml:"year,omitempty"`
+	Medium string `yaml:"medium"` // movie | tv
+	Why    string `yaml:"why,omitempty"`
+}
+
 // LoadAppConfig loads the app.yml configuration file
 func LoadAppConfig(path string) (*AppConfig, error) {
 	data, err := os.ReadFile(path)
@@ -104,11 +448,82 @@ func LoadAppConfig(path string) (*AppConfig, error) {
 	// Load secrets from environment variables
 	cfg.Tautulli.APIKey = os.Getenv("TAUTULLI_API_KEY")
 	cfg.Plex.Token = os.Getenv("PLEX_TOKEN")
+	for i := range cfg.Plex.Servers {
+		server := &cfg.Plex.Servers[i]
+		if server.TokenEnv == "" {
+			server.TokenEnv = "PLEX_TOKEN"
+		}
+		server.Token = os.Getenv(server.TokenEnv)
+		if server.Name == "" {
+			server.Name = fmt.Sprintf("server%d", i+1)
+		}
+	}
+	loadUserTokens(cfg.Publish.Plex.Playlists.Users)
+	loadUserTokens(cfg.Plex.Signals.Users)
 	cfg.Overseerr.APIKey = os.Getenv("OVERSEERR_API_KEY")
+	cfg.Webhooks.Token = os.Getenv("WEBHOOK_TOKEN")
+	for name, provider := range cfg.Recommender.Providers {
+		if provider.APIKeyEnv != "" {
+			provider.APIKey = os.Getenv(provider.APIKeyEnv)
+			cfg.Recommender.Providers[name] = provider
+		}
+	}
+	if dbURL := os.Getenv("DB_URL"); dbURL != "" {
+		cfg.Paths.DBURL = dbURL
+	}
+	// Prompt templates live next to app.yml unless configured elsewhere
+	if cfg.Recommender.PromptsDir == "" {
+		cfg.Recommender.PromptsDir = "prompts"
+	}
+	if !filepath.IsAbs(cfg.Recommender.PromptsDir) {
+		cfg.Recommender.PromptsDir = filepath.Join(filepath.Dir(path), cfg.Recommender.PromptsDir)
+	}
+	if err := cfg.validateExperiments(); err != nil {
+		return nil, fmt.Errorf("invalid experiments: %w", err)
+	}
 
 	return &cfg, nil
 }
 
+// loadUserTokens reads each user's token from its token_env variable
+func loadUserTokens(users []PlexUser) {
+	for i := range users {
+		if users[i].TokenEnv != "" {
+			users[i].Token = os.Getenv(users[i].TokenEnv)
+		}
+	}
+}
+
+// SecretEnv returns the names of the environment variables secrets are
+// loaded from, including the LLM and TMDb keys read at run time
+func (c *AppConfig) SecretEnv() []string {
+	names := []string{"TAUTULLI_API_KEY", "PLEX_TOKEN", "OVERSEERR_API_KEY", "WEBHOOK_TOKEN", "LLM_API_KEY", "TMDB_API_KEY"}
+	add := func(name string) {
+		for _, n := range names {
+			if n == name {
+				return
+			}
+		}
+		names = append(names, name)
+	}
+	for _, server := range c.Plex.Servers {
+		add(server.TokenEnv)
+	}
+	for _, users := range [][]PlexUser{c.Publish.Plex.Playlists.Users, c.Plex.Signals.Users} {
+		for _, user := range users {
+			if user.TokenEnv != "" {
+				add(user.TokenEnv)
+			}
+		}
+	}
+	for _, provider := range c.Recommender.Providers {
+		if provider.APIKeyEnv != "" {
+			add(provider.APIKeyEnv)
+		}
+	}
+	return names
+}
+
 // LoadCategoriesConfig loads the categories.yml configuration file
 func LoadCategoriesConfig(path string) (*CategoriesConfig, error) {
 	data, err := os.ReadFile(path)
@@ -116,6 +531,12 @@ func LoadCategoriesConfig(path string) (*CategoriesConfig, error) {
 		return nil, fmt.Errorf("failed to read categories config: %w", err)
 	}
 
+	// Resolve anchors and templates before decoding
+	data, err = expandCategoriesYAML(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand categories config: %w", err)
+	}
+
 	var cfg CategoriesConfig
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return nil, fmt.Errorf("failed to parse categories config: %w", err)
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// Experiment splits categories, or alternating runs, between recommender
+// variants so their effectiveness can be compared
+type Experiment struct {
+	Name       string    `yaml:"name"`
+	Split      string    `yaml:"split"`                // category (default) | run
+	Categories []string  `yaml:"categories,omitempty"` // labels in the experiment; empty means every category
+	Variants   []Variant `yaml:"variants"`
+}
+
+// Variant is one arm of an experiment. Its recommender settings and prompt
+// template override the category's, and its instructions are sent to the
+// LLM with the category's own.
+type Variant struct {
+	Name         string                `yaml:"name"`
+	Recommender  *RecommenderOverrides `yaml:"recommender,omitempty"`
+	Prompt       string                `yaml:"prompt,omitempty"`
+	Instructions string                `yaml:"instructions,omitempty"`
+}
+
+// Experiment splits. A category split keeps each category on one variant;
+// a run split moves a category to the next variant on each of its runs.
+const (
+	SplitCategory = "category"
+	SplitRun      = "run"
+)
+
+// includes reports whether the experiment covers a category
+func (e *Experiment) includes(label string) bool {
+	if len(e.Categories) == 0 {
+		return true
+	}
+	for _, l := range e.Categories {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// ExperimentFor returns the experiment a category runs under: the first one
+// that includes it, or nil
+func (c *AppConfig) ExperimentFor(category *Category) *Experiment {
+	for i := range c.Experiments {
+		e := &c.Experiments[i]
+		if len(e.Variants) > 0 && e.includes(category.Label) {
+			return e
+		}
+	}
+	return nil
+}
+
+// Assign picks a category's variant given the number of its earlier runs in
+// the experiment. Run splits rotate through the variants with each of the
+// category's runs. Category splits hash the experiment name and label, so
+// assignments survive config reordering.
+func (e *Experiment) Assign(label string, runs int) *Variant {
+	n := uint64(len(e.Variants))
+	if e.Split == SplitRun {
+		return &e.Variants[uint64(runs)%n]
+	}
+	h := fnv.New64a()
+	h.Write([]byte(e.Name + "\x00" + label))
+	return &e.Variants[h.Sum64()%n]
+}
+
+// WithVariant returns a copy of the category running under a variant
+func (c *Category) WithVariant(v *Variant) Category {
+	varied := *c
+	varied.Recommender = c.Recommender.merge(v.Recommender)
+	if v.Prompt != "" {
+		varied.Prompt = v.Prompt
+	}
+	if v.Instructions != "" {
+		if varied.Instructions != "" {
+			varied.Instructions += "\n"
+		}
+		varied.Instructions += v.Instructions
+	}
+	return varied
+}
+
+// merge returns the overrides with over's set fields taking precedence
+func (o *RecommenderOverrides) merge(over *RecommenderOverrides) *RecommenderOverrides {
+	var merged RecommenderOverrides
+	if o != nil {
+		merged = *o
+	}
+	if over == nil {
+		return &merged
+	}
+	if over.Model != "" {
+		merged.Model = over.Model
+	}
+	if over.Provider != "" {
+		merged.Provider = over.Provider
+	}
+	if over.Temperature != nil {
+		merged.Temperature = over.Temperature
+	}
+	if over.Count > 0 {
+		merged.Count = over.Count
+	}
+	if over.LookbackDays > 0 {
+		merged.LookbackDays = over.LookbackDays
+	}
+	if over.DedupDays > 0 {
+		merged.DedupDays = over.DedupDays
+	}
+	return &merged
+}
+
+// validateExperiments checks experiment and variant names, which tag runs
+// and history, and the split mode
+func (c *AppConfig) validateExperiments() error {
+	names := make(map[string]bool)
+	for _, e := range c.Experiments {
+		if e.Name == "" {
+			return fmt.Errorf("experiment without a name")
+		}
+		if names[e.Name] {
+			return fmt.Errorf("duplicate experiment %q", e.Name)
+		}
+		names[e.Name] = true
+		if e.Split != "" && e.Split != SplitCategory && e.Split != SplitRun {
+			return fmt.Errorf("experiment %q: unknown split %q (want %s or %s)", e.Name, e.Split, SplitCategory, SplitRun)
+		}
+		if len(e.Variants) == 0 {
+			return fmt.Errorf("experiment %q has no variants", e.Name)
+		}
+		variants := make(map[string]bool)
+		for _, v := range e.Variants {
+			if v.Name == "" || variants[v.Name] {
+				return fmt.Errorf("experiment %q: variants need unique names", e.Name)
+			}
+			variants[v.Name] = true
+		}
+	}
+	return nil
+}
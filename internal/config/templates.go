@@ -0,0 +1,307 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+)
+
+// expandCategoriesYAML resolves anchors, aliases, merge keys and templates in
+// a categories.yml and returns the equivalent plain YAML. goccy/go-yaml drops
+// or rejects "<<: [*a, *b]" merges and lets merged keys override the
+// mapping's own, so the document is resolved here from its AST instead. The
+// whole document is resolved, so anchors may live under any top-level key
+// (templates, or a block of shared defaults); only categories is returned.
+func expandCategoriesYAML(data []byte) ([]byte, error) {
+	file, err := parser.ParseBytes(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Docs) == 0 || file.Docs[0].Body == nil {
+		return data, nil
+	}
+
+	// Resolve every top-level key, not just categories, so anchors defined
+	// anywhere above a category are known when it aliases them
+	r := &yamlResolver{anchors: make(map[string]interface{})}
+	tree, err := r.value(file.Docs[0].Body)
+	if err != nil {
+		return nil, err
+	}
+	root, ok := tree.(yaml.MapSlice)
+	if !ok {
+		return nil, fmt.Errorf("categories config must be a mapping")
+	}
+
+	templates, err := newTemplateSet(lookup(root, "templates"))
+	if err != nil {
+		return nil, err
+	}
+
+	var categories []interface{}
+	if raw := lookup(root, "categories"); raw != nil {
+		list, ok := raw.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("categories must be a list")
+		}
+		for i, item := range list {
+			category, ok := item.(yaml.MapSlice)
+			if !ok {
+				return nil, fmt.Errorf("category %d must be a mapping", i+1)
+			}
+			expanded, err := templates.apply(category, nil)
+			if err != nil {
+				return nil, fmt.Errorf("category %d: %w", i+1, err)
+			}
+			categories = append(categories, expanded)
+		}
+	}
+
+	return yaml.Marshal(yaml.MapSlice{{Key: "categories", Value: categories}})
+}
+
+// yamlResolver converts an AST into plain values (yaml.MapSlice, slices and
+// scalars), following YAML 1.1 merge semantics: a mapping's own keys win over
+// merged ones, and earlier maps in a merge list win over later ones
+type yamlResolver struct {
+	anchors map[string]interface{}
+}
+
+func (r *yamlResolver) value(node ast.Node) (interface{}, error) {
+	switch n := node.(type) {
+	case *ast.DocumentNode:
+		return r.value(n.Body)
+	case *ast.AnchorNode:
+		v, err := r.value(n.Value)
+		if err != nil {
+			return nil, err
+		}
+		r.anchors[n.Name.GetToken().Value] = v
+		return v, nil
+	case *ast.AliasNode:
+		name := n.Value.GetToken().Value
+		v, ok := r.anchors[name]
+		if !ok {
+			return nil, fmt.Errorf("line %d: unknown alias *%s", n.GetToken().Position.Line, name)
+		}
+		return v, nil
+	case *ast.TagNode:
+		return r.value(n.Value)
+	case *ast.MappingNode:
+		return r.mapping(n.Values)
+	case *ast.MappingValueNode:
+		return r.mapping([]*ast.MappingValueNode{n})
+	case *ast.SequenceNode:
+		values := make([]interface{}, 0, len(n.Values))
+		for _, item := range n.Values {
+			v, err := r.value(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	case *ast.CommentGroupNode:
+		return nil, nil
+	case ast.ScalarNode:
+		return n.GetValue(), nil
+	}
+	return nil, fmt.Errorf("line %d: unsupported YAML node %s", node.GetToken().Position.Line, node.Type())
+}
+
+func (r *yamlResolver) mapping(entries []*ast.MappingValueNode) (yaml.MapSlice, error) {
+	var own yaml.MapSlice
+	var merged []yaml.MapSlice
+	for _, entry := range entries {
+		v, err := r.value(entry.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := entry.Key.(*ast.MergeKeyNode); ok {
+			sources, err := mergeSources(v)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", entry.GetToken().Position.Line, err)
+			}
+			merged = append(merged, sources...)
+			continue
+		}
+
+		key, err := r.value(entry.Key)
+		if err != nil {
+			return nil, err
+		}
+		own = append(own, yaml.MapItem{Key: key, Value: v})
+	}
+
+	result := append(yaml.MapSlice(nil), own...)
+	for _, source := range merged {
+		for _, item := range source {
+			if !hasKey(result, item.Key) {
+				result = append(result, item)
+			}
+		}
+	}
+	return result, nil
+}
+
+// mergeSources returns the maps named by a "<<" value: one map or a list
+func mergeSources(v interface{}) ([]yaml.MapSlice, error) {
+	switch m := v.(type) {
+	case yaml.MapSlice:
+		return []yaml.MapSlice{m}, nil
+	case []interface{}:
+		sources := make([]yaml.MapSlice, 0, len(m))
+		for _, item := range m {
+			source, ok := item.(yaml.MapSlice)
+			if !ok {
+				return nil, fmt.Errorf("merge list entries must be mappings")
+			}
+			sources = append(sources, source)
+		}
+		return sources, nil
+	}
+	return nil, fmt.Errorf("merge value must be a mapping or a list of mappings")
+}
+
+// templateSet holds the templates: section, resolving each template's own
+// extends once
+type templateSet struct {
+	raw      map[string]yaml.MapSlice
+	resolved map[string]yaml.MapSlice
+}
+
+func newTemplateSet(raw interface{}) (*templateSet, error) {
+	set := &templateSet{
+		raw:      make(map[string]yaml.MapSlice),
+		resolved: make(map[string]yaml.MapSlice),
+	}
+	if raw == nil {
+		return set, nil
+	}
+	templates, ok := raw.(yaml.MapSlice)
+	if !ok {
+		return nil, fmt.Errorf("templates must be a mapping of name to template")
+	}
+	for _, item := range templates {
+		name := fmt.Sprint(item.Key)
+		template, ok := item.Value.(yaml.MapSlice)
+		if !ok {
+			return nil, fmt.Errorf("template %q must be a mapping", name)
+		}
+		set.raw[name] = template
+	}
+	return set, nil
+}
+
+// apply overlays m on the templates it extends, in order, and drops the
+// extends key. stack holds the templates being resolved, to catch cycles.
+func (t *templateSet) apply(m yaml.MapSlice, stack []string) (yaml.MapSlice, error) {
+	names, err := extendsNames(lookup(m, "extends"))
+	if err != nil {
+		return nil, err
+	}
+
+	var result yaml.MapSlice
+	for _, name := range names {
+		template, err := t.get(name, stack)
+		if err != nil {
+			return nil, err
+		}
+		result = overlay(result, template)
+	}
+
+	own := make(yaml.MapSlice, 0, len(m))
+	for _, item := range m {
+		if item.Key != "extends" {
+			own = append(own, item)
+		}
+	}
+	return overlay(result, own), nil
+}
+
+func (t *templateSet) get(name string, stack []string) (yaml.MapSlice, error) {
+	if resolved, ok := t.resolved[name]; ok {
+		return resolved, nil
+	}
+	raw, ok := t.raw[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown template %q", name)
+	}
+	for _, s := range stack {
+		if s == name {
+			return nil, fmt.Errorf("template %q extends itself through a cycle", name)
+		}
+	}
+
+	resolved, err := t.apply(raw, append(stack, name))
+	if err != nil {
+		return nil, fmt.Errorf("template %q: %w", name, err)
+	}
+	t.resolved[name] = resolved
+	return resolved, nil
+}
+
+// extendsNames reads an extends value: a template name or a list of names
+func extendsNames(v interface{}) ([]string, error) {
+	switch e := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{e}, nil
+	case []interface{}:
+		names := make([]string, 0, len(e))
+		for _, item := range e {
+			name, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("extends must list template names")
+			}
+			names = append(names, name)
+		}
+		return names, nil
+	}
+	return nil, fmt.Errorf("extends must be a template name or a list of names")
+}
+
+// overlay returns base with over applied on top. Nested mappings are merged
+// key by key; any other value in over replaces the one in base.
+func overlay(base, over yaml.MapSlice) yaml.MapSlice {
+	result := append(yaml.MapSlice(nil), base...)
+	for _, item := range over {
+		i := indexOf(result, item.Key)
+		if i < 0 {
+			result = append(result, item)
+			continue
+		}
+		baseMap, baseOK := result[i].Value.(yaml.MapSlice)
+		overMap, overOK := item.Value.(yaml.MapSlice)
+		if baseOK && overOK {
+			result[i] = yaml.MapItem{Key: item.Key, Value: overlay(baseMap, overMap)}
+			continue
+		}
+		result[i] = item
+	}
+	return result
+}
+
+func lookup(m yaml.MapSlice, key string) interface{} {
+	if i := indexOf(m, key); i >= 0 {
+		return m[i].Value
+	}
+	return nil
+}
+
+func hasKey(m yaml.MapSlice, key interface{}) bool {
+	return indexOf(m, key) >= 0
+}
+
+func indexOf(m yaml.MapSlice, key interface{}) int {
+	for i, item := range m {
+		if item.Key == key {
+			return i
+		}
+	}
+	return -1
+}
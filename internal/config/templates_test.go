@@ -0,0 +1,155 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-yaml"
+)
+
+// expandedCategories expands a categories.yml and decodes its categories
+func expandedCategories(t *testing.T, in string) []map[string]interface{} {
+	t.Helper()
+	out, err := expandCategoriesYAML([]byte(strings.TrimSpace(in)))
+	if err != nil {
+		t.Fatalf("expand: %v", err)
+	}
+	var doc map[string][]map[string]interface{}
+	if err := yaml.Unmarshal(out, &doc); err != nil {
+		t.Fatalf("decode %s: %v", out, err)
+	}
+	if len(doc) != 1 {
+		t.Errorf("top-level keys = %v, want only categories", doc)
+	}
+	return doc["categories"]
+}
+
+func TestExpandCategoriesMergeKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "single merge",
+			in: `
+categories:
+  - &cozy
+    label: Cozy
+    type: genre
+    limit: 10
+  - <<: *cozy
+    label: Cozier
+`,
+			want: "[map[label:Cozy limit:10 type:genre] map[label:Cozier limit:10 type:genre]]",
+		},
+		{
+			name: "own keys beat a merge list, earlier maps beat later ones",
+			in: `
+x-shared:
+  a: &a {type: genre, limit: 10, media_types: [movie]}
+  b: &b {type: keyword, limit: 20, language: en}
+categories:
+  - <<: [*a, *b]
+    label: Both
+    limit: 5
+  - <<: [*b, *a]
+    label: Reversed
+`,
+			want: "[map[label:Both language:en limit:5 media_types:[movie] type:genre] " +
+				"map[label:Reversed language:en limit:20 media_types:[movie] type:keyword]]",
+		},
+		{
+			name: "anchors under other top-level keys resolve and are dropped",
+			in: `
+defaults:
+  filters: &filters
+    min_rating: 7
+    min_votes: 500
+categories:
+  - label: Space
+    tmdb_filters: *filters
+`,
+			want: "[map[label:Space tmdb_filters:map[min_rating:7 min_votes:500]]]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fmt.Sprint(expandedCategories(t, tt.in)); got != tt.want {
+				t.Errorf("categories = %s\nwant         %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandCategoriesExtends(t *testing.T) {
+	categories := expandedCategories(t, `
+templates:
+  base:
+    type: genre
+    media_types: [movie, tv]
+    tmdb_filters: {min_rating: 6, min_votes: 100}
+  gentle:
+    extends: base
+    tmdb_filters: {min_rating: 7}
+    tone: gentle
+  short:
+    limit: 10
+    tone: brisk
+categories:
+  - label: Cozy
+    extends: gentle
+    media_types: [movie]
+  - label: Quick
+    extends: [gentle, short]
+    tmdb_filters: {min_votes: 1000}
+`)
+	want := "[map[label:Cozy media_types:[movie] tmdb_filters:map[min_rating:7 min_votes:100] tone:gentle type:genre] " +
+		"map[label:Quick limit:10 media_types:[movie tv] tmdb_filters:map[min_rating:7 min_votes:1000] tone:brisk type:genre]]"
+	if got := fmt.Sprint(categories); got != want {
+		t.Errorf("categories = %s\nwant         %s", got, want)
+	}
+}
+
+func TestExpandCategoriesErrors(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "cycle",
+			in: `
+templates:
+  a: {extends: b, limit: 1}
+  b: {extends: c}
+  c: {extends: a}
+categories:
+  - {label: Loop, extends: a}
+`,
+			want: "cycle",
+		},
+		{
+			name: "unknown template",
+			in:   "categories:\n  - {label: Lost, extends: missing}\n",
+			want: `unknown template "missing"`,
+		},
+		{
+			name: "unknown alias",
+			in:   "categories:\n  - {label: Lost, <<: *missing}\n",
+			want: "missing",
+		},
+		{
+			name: "merge of a scalar",
+			in:   "x: &x 1\ncategories:\n  - {label: Bad, <<: *x}\n",
+			want: "merge value must be a mapping",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := expandCategoriesYAML([]byte(tt.in))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want one mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
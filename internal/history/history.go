@@ -0,0 +1,182 @@
+package history
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dppeppel/scryarr/internal/logging"
+	"github.com/dppeppel/scryarr/internal/store"
+	"github.com/dppeppel/scryarr/internal/tautulli"
+	"github.com/rs/zerolog"
+)
+
+var log zerolog.Logger
+
+func init() {
+	log = logging.GetLogger("history")
+}
+
+// Source kinds for history.source
+const (
+	SourceTautulli = "tautulli" // Tautulli's get_history API (default)
+	SourcePlex     = "plex"     // events received on /v1/webhooks/plex
+	SourceBoth     = "both"     // both, with duplicate watches dropped
+)
+
+// dedupWindow is how far apart two sources may date the same watch. Tautulli
+// dates a session by when it stopped, Plex by when it scrobbled at 90%.
+const dedupWindow = 6 * time.Hour
+
+// Source supplies the watch history a taste profile is built from
+type Source interface {
+	GetHistory(lookbackDays int) ([]tautulli.HistoryItem, error)
+}
+
+// EventSource reads watches from webhook events stored in the database
+type EventSource struct {
+	events store.WatchEventRepository
+	source string
+}
+
+// NewEventSource reads the stored events of one webhook source
+func NewEventSource(events store.WatchEventRepository, source string) *EventSource {
+	return &EventSource{events: events, source: source}
+}
+
+// GetHistory returns the watches received in the lookback window, most
+// recent first. Rating events are not watches and are skipped, and so is a
+// Tautulli stop when the same play also sent a watched notification.
+func (s *EventSource) GetHistory(lookbackDays int) ([]tautulli.HistoryItem, error) {
+	since := time.Now().AddDate(0, 0, -lookbackDays)
+	events, err := s.events.GetWatchEventsSince(since, s.source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s watch events: %w", s.source, err)
+	}
+
+	var watched, stopped []tautulli.HistoryItem
+	for i := len(events) - 1; i >= 0; i-- {
+		item, ok := historyItem(events[i])
+		if !ok {
+			continue
+		}
+		if events[i].Event == "stop" {
+			stopped = append(stopped, item)
+		} else {
+			watched = append(watched, item)
+		}
+	}
+	items := appendNew(watched, stopped)
+	sort.SliceStable(items, func(i, j int) bool { return items[i].WatchedAt > items[j].WatchedAt })
+
+	log.Info().Str("source", s.source).Int("count", len(items)).Msg("loaded watch history from webhook events")
+	return items, nil
+}
+
+// historyItem converts a watch event to a history entry
+func historyItem(e store.WatchEvent) (tautulli.HistoryItem, bool) {
+	var watchedStatus float64
+	switch e.Event {
+	case "watched", "scrobble":
+		watchedStatus = 1
+	case "stop":
+		if e.PercentComplete > 0 {
+			watchedStatus = 0.5
+		}
+	default:
+		return tautulli.HistoryItem{}, false
+	}
+
+	return tautulli.HistoryItem{
+		Title:                e.Title,
+		Year:                 e.Year,
+		MediaType:            e.MediaType,
+		WatchedAt:            e.OccurredAt.Unix(),
+		User:                 e.User,
+		RatingKey:            e.RatingKey,
+		ParentRatingKey:      e.ParentRatingKey,
+		GrandparentRatingKey: e.GrandparentRatingKey,
+		GrandparentTitle:     e.GrandparentTitle,
+		PercentComplete:      e.PercentComplete,
+		WatchedStatus:        watchedStatus,
+		GUID:                 e.GUID,
+		TMDbID:               e.TMDbID,
+		IMDbID:               e.IMDbID,
+		TVDBID:               e.TVDBID,
+	}, true
+}
+
+// multiSource merges several sources. A failing source is logged and left
+// out; the merge fails only when every source does.
+type multiSource struct {
+	names   []string
+	sources []Source
+}
+
+// Merge combines sources, keyed by name for logging. Watches reported by an
+// earlier source win over the same watch from a later one.
+func Merge(names []string, sources []Source) Source {
+	return &multiSource{names: names, sources: sources}
+}
+
+func (m *multiSource) GetHistory(lookbackDays int) ([]tautulli.HistoryItem, error) {
+	var merged []tautulli.HistoryItem
+	var errs []string
+	for i, source := range m.sources {
+		items, err := source.GetHistory(lookbackDays)
+		if err != nil {
+			log.Warn().Err(err).Str("source", m.names[i]).Msg("history source failed")
+			errs = append(errs, fmt.Sprintf("%s: %v", m.names[i], err))
+			continue
+		}
+		merged = appendNew(merged, items)
+	}
+	if len(errs) == len(m.sources) {
+		return nil, fmt.Errorf("all history sources failed: %s", strings.Join(errs, "; "))
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].WatchedAt > merged[j].WatchedAt })
+	return merged, nil
+}
+
+// appendNew appends the items that are not already in history, nor repeated
+// within items. Two entries are the same watch when the same user played the
+// same item within dedupWindow of each other.
+func appendNew(history, items []tautulli.HistoryItem) []tautulli.HistoryItem {
+	seen := make(map[string][]int64)
+	for _, item := range history {
+		key := watchKey(item)
+		seen[key] = append(seen[key], item.WatchedAt)
+	}
+
+	window := int64(dedupWindow / time.Second)
+	for _, item := range items {
+		duplicate := false
+		for _, at := range seen[watchKey(item)] {
+			if abs(at-item.WatchedAt) <= window {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			history = append(history, item)
+			seen[watchKey(item)] = append(seen[watchKey(item)], item.WatchedAt)
+		}
+	}
+	return history
+}
+
+func watchKey(item tautulli.HistoryItem) string {
+	if item.RatingKey != "" {
+		return strings.ToLower(item.User) + "|" + item.RatingKey
+	}
+	return fmt.Sprintf("%s|%s|%d", strings.ToLower(item.User), strings.ToLower(item.Title), item.Year)
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,117 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dppeppel/scryarr/internal/store"
+	"github.com/dppeppel/scryarr/internal/tautulli"
+)
+
+func TestEventSourceDropsStopOfWatchedPlay(t *testing.T) {
+	db := store.NewMemoryStore()
+	start := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	for _, e := range []store.WatchEvent{
+		// One play of Contact sends watched at the threshold, then stop
+		{Event: "watched", RatingKey: "10", Title: "Contact", PercentComplete: 86, OccurredAt: start},
+		{Event: "stop", RatingKey: "10", Title: "Contact", PercentComplete: 97, OccurredAt: start.Add(12 * time.Minute)},
+		// Moon was stopped halfway, then played again the next day
+		{Event: "stop", RatingKey: "20", Title: "Moon", PercentComplete: 50, OccurredAt: start.Add(time.Hour)},
+		{Event: "stop", RatingKey: "20", Title: "Moon", PercentComplete: 60, OccurredAt: start.Add(25 * time.Hour)},
+		{Event: "rate", RatingKey: "20", Title: "Moon", Rating: 8, OccurredAt: start.Add(26 * time.Hour)},
+	} {
+		e.Source = store.EventSourceTautulli
+		e.User = "alice"
+		e.MediaType = "movie"
+		if _, err := db.RecordWatchEvent(&e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	history, err := NewEventSource(db, store.EventSourceTautulli).GetHistory(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, item := range history {
+		got = append(got, fmt.Sprintf("%s %d%% %g", item.Title, item.PercentComplete, item.WatchedStatus))
+	}
+	want := "[Moon 60% 0.5 Moon 50% 0.5 Contact 86% 1]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("history = %v, want %s", got, want)
+	}
+}
+
+// staticSource is a history source with fixed items or a fixed error
+type staticSource struct {
+	items []tautulli.HistoryItem
+	err   error
+}
+
+func (s staticSource) GetHistory(int) ([]tautulli.HistoryItem, error) { return s.items, s.err }
+
+func TestMerge(t *testing.T) {
+	base := time.Now().Unix()
+	item := func(user, key string, hoursAgo float64, status float64) tautulli.HistoryItem {
+		return tautulli.HistoryItem{User: user, RatingKey: key, Title: "Item " + key,
+			WatchedAt: base - int64(hoursAgo*3600), WatchedStatus: status}
+	}
+	api := staticSource{items: []tautulli.HistoryItem{item("alice", "10", 1, 1), item("alice", "20", 30, 0.5)}}
+	webhooks := staticSource{items: []tautulli.HistoryItem{
+		item("Alice", "10", 1.5, 0.5), // the API's watch, dated differently
+		item("alice", "20", 2, 1),     // a later play of the same item
+		item("bob", "10", 3, 1),       // another user
+		item("bob", "10", 3.5, 1),     // repeated within the source
+	}}
+	down := staticSource{err: errors.New("connection refused")}
+
+	tests := []struct {
+		name    string
+		sources []Source
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "earlier source wins and repeats collapse",
+			sources: []Source{api, webhooks},
+			want:    "[alice 10 1 alice 20 1 bob 10 1 alice 20 0.5]",
+		},
+		{
+			name:    "failing source is left out",
+			sources: []Source{down, api},
+			want:    "[alice 10 1 alice 20 0.5]",
+		},
+		{
+			name:    "all sources failing",
+			sources: []Source{down, down},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names := make([]string, len(tt.sources))
+			for i := range names {
+				names[i] = fmt.Sprint("source ", i)
+			}
+			history, err := Merge(names, tt.sources).GetHistory(7)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("history = %+v, want an error", history)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, item := range history {
+				got = append(got, fmt.Sprintf("%s %s %g", item.User, item.RatingKey, item.WatchedStatus))
+			}
+			if fmt.Sprint(got) != tt.want {
+				t.Errorf("history = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/dppeppel/scryarr/internal/config"
@@ -20,8 +21,9 @@ func init() {
 
 // Client handles LLM API interactions
 type Client struct {
-	client *openai.Client
-	model  string
+	client      *openai.Client
+	model       string
+	temperature float32
 }
 
 // NewClient creates a new LLM client
@@ -32,20 +34,60 @@ func NewClient(cfg *config.LLMConfig, model string) *Client {
 	}
 
 	return &Client{
-		client: openai.NewClientWithConfig(clientConfig),
-		model:  model,
+		client:      openai.NewClientWithConfig(clientConfig),
+		model:       model,
+		temperature: config.DefaultTemperature,
 	}
 }
 
+// SetTemperature sets the sampling temperature of every request
+func (c *Client) SetTemperature(temperature float64) {
+	c.temperature = float32(temperature)
+}
+
 // PromptRequest represents the structured request to the LLM
 type PromptRequest struct {
-	Task            string                 `json:"task"`
-	Category        map[string]interface{} `json:"category"`
-	Constraints     map[string]interface{} `json:"constraints"`
-	TasteProfile    map[string]interface{} `json:"taste_profile"`
-	AlreadySeen     []string               `json:"already_seen"`
-	AlreadyRecommended []string            `json:"already_recommended"`
-	OutputSchema    map[string]interface{} `json:"output_schema"`
+	Task               string                 `json:"task"`
+	Category           map[string]interface{} `json:"category"`
+	Constraints        map[string]interface{} `json:"constraints"`
+	TasteProfile       map[string]interface{} `json:"taste_profile"`
+	AlreadySeen        []string               `json:"already_seen"`
+	AlreadyRecommended []string               `json:"already_recommended"`
+	Candidates         []string               `json:"candidates,omitempty"`
+	OutputSchema       map[string]interface{} `json:"output_schema"`
+}
+
+// TasteProfile carries the viewer signals sent with every prompt. Entries
+// are "Title (Year)" strings; rated entries also carry the 0-10 rating.
+type TasteProfile struct {
+	TopTitles   []string // most engaged-with titles, best first
+	Genres      []string // TMDb genres of the top titles, heaviest first
+	Keywords    []string // TMDb keywords of the top titles, heaviest first
+	HighlyRated []string // weighted up: find more like these
+	Disliked    []string // low ratings and abandoned titles: negative examples
+	Watchlist   []string // titles the viewer wants to see
+}
+
+func (t *TasteProfile) toMap() map[string]interface{} {
+	profile := map[string]interface{}{
+		"top_titles": t.TopTitles,
+	}
+	if len(t.Genres) > 0 {
+		profile["top_genres"] = t.Genres
+	}
+	if len(t.Keywords) > 0 {
+		profile["top_keywords"] = t.Keywords
+	}
+	if len(t.HighlyRated) > 0 {
+		profile["highly_rated"] = t.HighlyRated
+	}
+	if len(t.Disliked) > 0 {
+		profile["disliked"] = t.Disliked
+	}
+	if len(t.Watchlist) > 0 {
+		profile["watchlist"] = t.Watchlist
+	}
+	return profile
 }
 
 // Recommendation represents a single recommendation from the LLM
@@ -64,94 +106,139 @@ type LLMResponse struct {
 	Recommendations []Recommendation `json:"recommendations"`
 }
 
-// GenerateRecommendations sends a prompt to the LLM and returns recommendations
-func (c *Client) GenerateRecommendations(category *config.Category, constraints map[string]interface{}, tasteProfile, alreadySeen, alreadyRecommended []string) (*LLMResponse, error) {
-	log.Info().Str("category", category.Label).Msg("generating recommendations via LLM")
-
-	// Build the prompt request
-	req := PromptRequest{
-		Task: "recommend",
-		Category: map[string]interface{}{
-			"label":       category.Label,
-			"type":        category.Type,
-			"media_types": category.MediaTypes,
-		},
-		Constraints: constraints,
-		TasteProfile: map[string]interface{}{
-			"recent_watches": tasteProfile,
-		},
+// NewRecommendRequest builds the request for new titles that fit the
+// category
+func NewRecommendRequest(category *config.Category, constraints map[string]interface{}, tasteProfile *TasteProfile, alreadySeen, alreadyRecommended []string) PromptRequest {
+	return PromptRequest{
+		Task:               TaskRecommend,
+		Category:           categoryMap(category),
+		Constraints:        constraints,
+		TasteProfile:       tasteProfile.toMap(),
 		AlreadySeen:        alreadySeen,
 		AlreadyRecommended: alreadyRecommended,
-		OutputSchema: map[string]interface{}{
-			"type": "object",
-			"properties": map[string]interface{}{
-				"category":    map[string]string{"type": "string"},
-				"generated_at": map[string]string{"type": "string"},
-				"recommendations": map[string]interface{}{
-					"type": "array",
-					"items": map[string]interface{}{
-						"type": "object",
-						"properties": map[string]interface{}{
-							"title":    map[string]string{"type": "string"},
-							"year":     map[string]string{"type": "integer"},
-							"medium":   map[string]string{"type": "string"},
-							"why":      map[string]string{"type": "string"},
-							"keywords": map[string]interface{}{
-								"type": "array",
-								"items": map[string]string{"type": "string"},
-							},
-						},
-					},
-				},
-			},
-		},
+		OutputSchema:       outputSchema(),
+	}
+}
+
+// NewRankLibraryRequest builds the request to pick and explain the
+// candidates (titles already in the library that the viewer has not
+// watched) that best fit the category. Candidates are "Title (Year) [medium]"
+// strings.
+func NewRankLibraryRequest(category *config.Category, constraints map[string]interface{}, tasteProfile *TasteProfile, candidates []string) PromptRequest {
+	return PromptRequest{
+		Task:         TaskRankLibrary,
+		Category:     categoryMap(category),
+		Constraints:  constraints,
+		TasteProfile: tasteProfile.toMap(),
+		Candidates:   candidates,
+		OutputSchema: outputSchema(),
+	}
+}
+
+// GenerateRecommendations sends a recommend request rendered with prompt and
+// returns recommendations
+func (c *Client) GenerateRecommendations(category *config.Category, prompt *Prompt, req PromptRequest) (*LLMResponse, error) {
+	log.Info().Str("category", category.Label).Str("prompt", prompt.Name).Msg("generating recommendations via LLM")
+
+	return c.complete(category, prompt, req)
+}
+
+// RankLibrary sends a rank_library request rendered with prompt and returns
+// the LLM's picks among the candidates
+func (c *Client) RankLibrary(category *config.Category, prompt *Prompt, req PromptRequest) (*LLMResponse, error) {
+	log.Info().Str("category", category.Label).Str("prompt", prompt.Name).Int("candidates", len(req.Candidates)).Msg("ranking library titles via LLM")
+
+	return c.complete(category, prompt, req)
+}
+
+// categoryMap describes the category to the LLM
+func categoryMap(category *config.Category) map[string]interface{} {
+	m := map[string]interface{}{
+		"label":       category.Label,
+		"type":        category.Type,
+		"media_types": category.MediaTypes,
 	}
 
 	// Add category-specific filters
 	if category.TMDbFilters != nil {
-		req.Category["tmdb_filters"] = map[string]interface{}{
+		m["tmdb_filters"] = map[string]interface{}{
 			"include_genres": category.TMDbFilters.IncludeGenres,
 			"exclude_genres": category.TMDbFilters.ExcludeGenres,
 		}
 	}
 	if len(category.KeywordsPrefer) > 0 {
-		req.Category["keywords_prefer"] = category.KeywordsPrefer
+		m["keywords_prefer"] = category.KeywordsPrefer
 	}
 	if len(category.KeywordsAvoid) > 0 {
-		req.Category["keywords_avoid"] = category.KeywordsAvoid
+		m["keywords_avoid"] = category.KeywordsAvoid
 	}
 	if len(category.MoodKeywords) > 0 {
-		req.Category["mood_keywords"] = category.MoodKeywords
+		m["mood_keywords"] = category.MoodKeywords
 	}
 	if category.Seed != nil {
-		req.Category["seed"] = category.Seed
+		m["seed"] = category.Seed
 	}
 	if len(category.Seeds) > 0 {
-		req.Category["seeds"] = category.Seeds
+		m["seeds"] = category.Seeds
+	}
+	if category.Instructions != "" {
+		m["instructions"] = category.Instructions
 	}
+	return m
+}
 
-	// Convert to JSON
-	reqJSON, err := json.Marshal(req)
+func outputSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"category":     map[string]string{"type": "string"},
+			"generated_at": map[string]string{"type": "string"},
+			"recommendations": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"title":  map[string]string{"type": "string"},
+						"year":   map[string]string{"type": "integer"},
+						"medium": map[string]string{"type": "string"},
+						"why":    map[string]string{"type": "string"},
+						"keywords": map[string]interface{}{
+							"type":  "array",
+							"items": map[string]string{"type": "string"},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// complete renders a prompt request and parses the LLM's JSON reply
+func (c *Client) complete(category *config.Category, prompt *Prompt, req PromptRequest) (*LLMResponse, error) {
+	messages, err := prompt.Render(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+		return nil, fmt.Errorf("failed to render prompt: %w", err)
 	}
 
 	// Create OpenAI chat completion request
-	systemMsg := "You are a recommender for a private media server. Suggest items constrained by the provided category and constraints. Return strict JSON matching the schema. Do not include already_seen or already_recommended titles. No streaming or acquisition info."
-
 	chatReq := openai.ChatCompletionRequest{
 		Model: c.model,
 		Messages: []openai.ChatCompletionMessage{
 			{
 				Role:    openai.ChatMessageRoleSystem,
-				Content: systemMsg,
+				Content: messages.System,
 			},
 			{
 				Role:    openai.ChatMessageRoleUser,
-				Content: string(reqJSON),
+				Content: messages.User,
 			},
 		},
-		Temperature: 0.7,
+		Temperature: c.temperature,
+	}
+	// go-openai omits a zero temperature, which the API reads as its default
+	// of 1; the smallest float32 above zero samples just as greedily
+	if chatReq.Temperature == 0 {
+		chatReq.Temperature = math.SmallestNonzeroFloat32
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
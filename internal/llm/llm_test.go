@@ -0,0 +1,44 @@
+package llm
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dppeppel/scryarr/internal/config"
+)
+
+func TestZeroTemperatureIsSent(t *testing.T) {
+	var sent map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&sent); err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices": [{"index": 0, "message": {"role": "assistant", "content": "{\"recommendations\": []}"}}]}`))
+	}))
+	defer srv.Close()
+
+	prompts, err := LoadPrompts("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	category := &config.Category{Label: "Space"}
+	req := NewRecommendRequest(category, nil, &TasteProfile{}, nil, nil)
+	prompt, err := prompts.For("", req.Task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewClient(&config.LLMConfig{APIKey: "key", APIBase: srv.URL}, "test-model")
+	client.SetTemperature(0)
+	if _, err := client.GenerateRecommendations(category, prompt, req); err != nil {
+		t.Fatal(err)
+	}
+
+	temperature, ok := sent["temperature"].(float64)
+	if !ok || temperature <= 0 || temperature > 1e-6 {
+		t.Errorf("temperature = %v, want a near-zero value", sent["temperature"])
+	}
+}
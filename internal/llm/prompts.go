@@ -0,0 +1,182 @@
+package llm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Prompt tasks; each has a template of the same name
+const (
+	TaskRecommend   = "recommend"
+	TaskRankLibrary = "rank_library"
+)
+
+// promptExt is the file extension of prompt templates
+const promptExt = ".tmpl"
+
+//go:embed prompts/*.tmpl
+var defaultPrompts embed.FS
+
+var promptFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"join": strings.Join,
+}
+
+// Messages are the chat messages sent for one request
+type Messages struct {
+	System string
+	User   string
+}
+
+// Prompt is a parsed prompt template. Version is a hash of the template
+// sources, so runs record exactly which prompt they used.
+type Prompt struct {
+	Name    string
+	Version string
+	tmpl    *template.Template
+}
+
+// Render renders the request into chat messages
+func (p *Prompt) Render(req PromptRequest) (*Messages, error) {
+	render := func(name string) (string, error) {
+		var buf bytes.Buffer
+		if err := p.tmpl.ExecuteTemplate(&buf, name, req); err != nil {
+			return "", fmt.Errorf("prompt %q: %w", p.Name, err)
+		}
+		return strings.TrimSpace(buf.String()), nil
+	}
+
+	system, err := render("system")
+	if err != nil {
+		return nil, err
+	}
+	user, err := render("user")
+	if err != nil {
+		return nil, err
+	}
+	return &Messages{System: system, User: user}, nil
+}
+
+// Prompts loads prompt templates: the embedded defaults, replaced by
+// same-named files in the prompts directory, and per-category templates
+// from that directory that redefine blocks of the task's template
+type Prompts struct {
+	dir     string
+	tasks   map[string]*Prompt
+	sources map[string]string // task -> template source
+	named   map[string]*Prompt
+}
+
+// LoadPrompts loads the task templates. A missing directory leaves the
+// defaults in place.
+func LoadPrompts(dir string) (*Prompts, error) {
+	p := &Prompts{
+		dir:     dir,
+		tasks:   make(map[string]*Prompt),
+		sources: make(map[string]string),
+		named:   make(map[string]*Prompt),
+	}
+
+	for _, task := range []string{TaskRecommend, TaskRankLibrary} {
+		source, err := p.read(task)
+		if errors.Is(err, fs.ErrNotExist) {
+			var data []byte
+			data, err = defaultPrompts.ReadFile("prompts/" + task + promptExt)
+			source = string(data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read prompt %q: %w", task, err)
+		}
+
+		tmpl, err := parsePrompt(task, template.New(task).Funcs(promptFuncs), source)
+		if err != nil {
+			return nil, err
+		}
+		p.tasks[task] = &Prompt{Name: task, Version: promptVersion(source), tmpl: tmpl}
+		p.sources[task] = source
+	}
+	return p, nil
+}
+
+// For returns the template for a task, or the named per-category template
+// layered over it. An empty name is the task's own template.
+func (p *Prompts) For(name, task string) (*Prompt, error) {
+	base, ok := p.tasks[task]
+	if !ok {
+		return nil, fmt.Errorf("unknown prompt task %q", task)
+	}
+	if name == "" || name == task {
+		return base, nil
+	}
+	if strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("invalid prompt name %q", name)
+	}
+
+	key := name + "|" + task
+	if prompt, ok := p.named[key]; ok {
+		return prompt, nil
+	}
+
+	source, err := p.read(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prompt %q: %w", name, err)
+	}
+	clone, err := base.tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := parsePrompt(name, clone, source)
+	if err != nil {
+		return nil, err
+	}
+
+	prompt := &Prompt{Name: name, Version: promptVersion(p.sources[task], source), tmpl: tmpl}
+	p.named[key] = prompt
+	return prompt, nil
+}
+
+// read reads a template from the prompts directory
+func (p *Prompts) read(name string) (string, error) {
+	if p.dir == "" {
+		return "", fs.ErrNotExist
+	}
+	data, err := os.ReadFile(filepath.Join(p.dir, name+promptExt))
+	return string(data), err
+}
+
+// parsePrompt parses a template source and checks it defines both messages
+func parsePrompt(name string, tmpl *template.Template, source string) (*template.Template, error) {
+	tmpl, err := tmpl.Parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse prompt %q: %w", name, err)
+	}
+	for _, block := range []string{"system", "user"} {
+		if tmpl.Lookup(block) == nil {
+			return nil, fmt.Errorf("prompt %q does not define %q", name, block)
+		}
+	}
+	return tmpl, nil
+}
+
+// promptVersion hashes the template sources a prompt was parsed from
+func promptVersion(sources ...string) string {
+	h := sha256.New()
+	for _, s := range sources {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
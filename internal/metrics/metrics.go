@@ -0,0 +1,153 @@
+// Package metrics measures what became of past recommendations: whether
+// each title was added to Plex, played and finished after it was recommended.
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dppeppel/scryarr/internal/store"
+	"github.com/dppeppel/scryarr/internal/taste"
+	"github.com/dppeppel/scryarr/internal/tautulli"
+)
+
+// UnknownModel names the model of recommendations made before category runs
+// recorded one
+const UnknownModel = "unknown"
+
+// Rates are the ratios derived from an effectiveness stat
+type Rates struct {
+	AcquisitionRate float64 `json:"acquisition_rate"` // acquired / recommended
+	WatchRate       float64 `json:"watch_rate"`       // watched / recommended
+	CompletionRate  float64 `json:"completion_rate"`  // completed / watched
+}
+
+// RatesOf returns the rates of a stat; empty denominators give zero
+func RatesOf(st store.EffectivenessStat) Rates {
+	ratio := func(n, d int) float64 {
+		if d == 0 {
+			return 0
+		}
+		return float64(n) / float64(d)
+	}
+	return Rates{
+		AcquisitionRate: ratio(st.Acquired, st.Recommended),
+		WatchRate:       ratio(st.Watched, st.Recommended),
+		CompletionRate:  ratio(st.Completed, st.Watched),
+	}
+}
+
+// outcomes holds when a title was added to Plex and played, so each
+// recommendation only counts what happened after it was made
+type outcomes struct {
+	added     []int64 // inventory addedAt
+	played    []int64 // history watchedAt
+	completed []int64 // watchedAt of completed plays
+}
+
+func after(times []int64, since int64) bool {
+	for _, t := range times {
+		if t >= since {
+			return true
+		}
+	}
+	return false
+}
+
+// Compute counts, per category, per model and per experiment variant, the
+// recommendations that were later acquired, watched and completed. Inventory
+// and history are matched by TMDb ID; episodes count towards their series.
+func Compute(records []store.RecommendationRecord, inventory []store.InventoryItem, history []tautulli.HistoryItem, now time.Time) []store.EffectivenessStat {
+	byTitle := make(map[string]*outcomes)
+	get := func(mediaType string, tmdbID int) *outcomes {
+		key := fmt.Sprintf("%s:%d", mediaType, tmdbID)
+		o, ok := byTitle[key]
+		if !ok {
+			o = &outcomes{}
+			byTitle[key] = o
+		}
+		return o
+	}
+
+	for _, item := range inventory {
+		if item.TMDbID > 0 && item.AddedAt > 0 {
+			o := get(normalizeMedium(item.MediaType), item.TMDbID)
+			o.added = append(o.added, item.AddedAt)
+		}
+	}
+	for _, item := range history {
+		if item.TMDbID <= 0 {
+			continue
+		}
+		o := get(normalizeMedium(item.MediaType), item.TMDbID)
+		o.played = append(o.played, item.WatchedAt)
+		if taste.Completed(item) {
+			o.completed = append(o.completed, item.WatchedAt)
+		}
+	}
+
+	stats := make(map[string]*store.EffectivenessStat)
+	stat := func(scope, experiment, name string) *store.EffectivenessStat {
+		key := scope + "|" + experiment + "|" + name
+		st, ok := stats[key]
+		if !ok {
+			st = &store.EffectivenessStat{Scope: scope, Experiment: experiment, Name: name, ComputedAt: now}
+			stats[key] = st
+		}
+		return st
+	}
+
+	for _, r := range records {
+		model := r.Model
+		if model == "" {
+			model = UnknownModel
+		}
+		o := byTitle[fmt.Sprintf("%s:%d", r.MediaType, r.TMDbID)]
+		since := r.FirstSeenAt.Unix()
+
+		scopes := []*store.EffectivenessStat{stat(store.StatScopeCategory, "", r.Label), stat(store.StatScopeModel, "", model)}
+		if r.Variant != "" {
+			scopes = append(scopes, stat(store.StatScopeVariant, r.Experiment, r.Variant))
+		}
+		for _, st := range scopes {
+			st.Recommended++
+			if o == nil {
+				continue
+			}
+			if after(o.added, since) {
+				st.Acquired++
+			}
+			if after(o.played, since) {
+				st.Watched++
+			}
+			if after(o.completed, since) {
+				st.Completed++
+			}
+		}
+	}
+
+	result := make([]store.EffectivenessStat, 0, len(stats))
+	for _, st := range stats {
+		result = append(result, *st)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Scope != result[j].Scope {
+			return result[i].Scope < result[j].Scope
+		}
+		if result[i].Experiment != result[j].Experiment {
+			return result[i].Experiment < result[j].Experiment
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// normalizeMedium maps Plex and Tautulli media types onto movie or tv
+func normalizeMedium(mediaType string) string {
+	switch mediaType {
+	case "show", "episode", "season":
+		return "tv"
+	}
+	return mediaType
+}
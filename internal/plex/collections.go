@@ -0,0 +1,319 @@
+package plex
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Plex metadata type codes used by the library and collection endpoints
+const (
+	TypeMovie      = 1
+	TypeShow       = 2
+	TypeCollection = 18
+)
+
+// Collection sort modes for the collectionSort pref
+const (
+	CollectionSortRelease = 0
+	CollectionSortTitle   = 1
+	CollectionSortCustom  = 2
+)
+
+// Collection is a Plex collection in a library section
+type Collection struct {
+	RatingKey  string `xml:"ratingKey,attr"`
+	Title      string `xml:"title,attr"`
+	Smart      string `xml:"smart,attr"`
+	ChildCount int    `xml:"childCount,attr"`
+}
+
+// Label is a label tag available in a library section
+type Label struct {
+	Key   string `xml:"key,attr"`
+	Title string `xml:"title,attr"`
+}
+
+// TypeForMedia maps an inventory media type (movie/tv) to a Plex type code
+func TypeForMedia(mediaType string) int {
+	if mediaType == "tv" || mediaType == "show" {
+		return TypeShow
+	}
+	return TypeMovie
+}
+
+// request performs an authenticated call against the Plex server and, when
+// out is non-nil, decodes the XML response into it
+func (c *Client) request(method, path string, params url.Values, out interface{}) error {
+	reqURL := c.baseURL + path
+	if len(params) > 0 {
+		reqURL += "?" + params.Encode()
+	}
+
+	req, err := http.NewRequest(method, reqURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Plex-Token", c.token)
+	req.Header.Set("Accept", "application/xml")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("plex %s %s failed: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("plex %s %s returned status %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// GetLibrarySections returns the library sections that pass the section filter
+func (c *Client) GetLibrarySections() ([]LibrarySection, error) {
+	sections, err := c.getLibrarySections()
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []LibrarySection
+	for _, section := range sections {
+		if c.sectionSelected(section) {
+			selected = append(selected, section)
+		}
+	}
+	return selected, nil
+}
+
+// MachineIdentifier returns the server's machine identifier, used to build
+// server:// URIs for collection and playlist membership
+func (c *Client) MachineIdentifier() (string, error) {
+	if c.machineID != "" {
+		return c.machineID, nil
+	}
+
+	var container struct {
+		MachineIdentifier string `xml:"machineIdentifier,attr"`
+	}
+	if err := c.request("GET", "/", nil, &container); err != nil {
+		return "", err
+	}
+	if container.MachineIdentifier == "" {
+		return "", fmt.Errorf("plex did not report a machine identifier")
+	}
+
+	c.machineID = container.MachineIdentifier
+	return c.machineID, nil
+}
+
+// itemsURI builds a server:// URI referencing the given library items
+func (c *Client) itemsURI(ratingKeys []string) (string, error) {
+	machineID, err := c.MachineIdentifier()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("server://%s/com.plexapp.plugins.library/library/metadata/%s",
+		machineID, strings.Join(ratingKeys, ",")), nil
+}
+
+// FindCollection looks up a collection by title in a section, returning nil if absent
+func (c *Client) FindCollection(sectionKey, title string) (*Collection, error) {
+	var container struct {
+		Directory []Collection `xml:"Directory"`
+	}
+	if err := c.request("GET", "/library/sections/"+sectionKey+"/collections", nil, &container); err != nil {
+		return nil, err
+	}
+
+	for _, col := range container.Directory {
+		if col.Title == title {
+			col := col
+			return &col, nil
+		}
+	}
+	return nil, nil
+}
+
+// CreateCollection creates a regular collection holding the given items
+func (c *Client) CreateCollection(sectionKey, title string, itemType int, ratingKeys []string) (*Collection, error) {
+	uri, err := c.itemsURI(ratingKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	params.Set("type", fmt.Sprintf("%d", itemType))
+	params.Set("title", title)
+	params.Set("smart", "0")
+	params.Set("sectionId", sectionKey)
+	params.Set("uri", uri)
+
+	return c.createCollection(params)
+}
+
+// CreateSmartCollection creates a smart collection matching items carrying a label
+func (c *Client) CreateSmartCollection(sectionKey, title string, itemType int, labelKey string) (*Collection, error) {
+	machineID, err := c.MachineIdentifier()
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	params.Set("type", fmt.Sprintf("%d", itemType))
+	params.Set("title", title)
+	params.Set("smart", "1")
+	params.Set("sectionId", sectionKey)
+	params.Set("uri", fmt.Sprintf("server://%s/com.plexapp.plugins.library/library/sections/%s/all?type=%d&label=%s",
+		machineID, sectionKey, itemType, labelKey))
+
+	return c.createCollection(params)
+}
+
+func (c *Client) createCollection(params url.Values) (*Collection, error) {
+	var container struct {
+		Directory []Collection `xml:"Directory"`
+	}
+	if err := c.request("POST", "/library/collections", params, &container); err != nil {
+		return nil, err
+	}
+	if len(container.Directory) == 0 {
+		return nil, fmt.Errorf("plex did not return the created collection")
+	}
+	return &container.Directory[0], nil
+}
+
+// DeleteCollection removes a collection (the items themselves are untouched)
+func (c *Client) DeleteCollection(collectionKey string) error {
+	return c.request("DELETE", "/library/metadata/"+collectionKey, nil, nil)
+}
+
+// GetCollectionItems returns the rating keys of a collection's items in order
+func (c *Client) GetCollectionItems(collectionKey string) ([]string, error) {
+	var container MediaContainer
+	if err := c.request("GET", "/library/collections/"+collectionKey+"/children", nil, &container); err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, v := range container.Video {
+		keys = append(keys, v.RatingKey)
+	}
+	for _, d := range container.Directory {
+		keys = append(keys, d.RatingKey)
+	}
+	return keys, nil
+}
+
+// AddCollectionItems appends items to a collection
+func (c *Client) AddCollectionItems(collectionKey string, ratingKeys []string) error {
+	uri, err := c.itemsURI(ratingKeys)
+	if err != nil {
+		return err
+	}
+
+	params := url.Values{}
+	params.Set("uri", uri)
+	return c.request("PUT", "/library/collections/"+collectionKey+"/items", params, nil)
+}
+
+// RemoveCollectionItem removes a single item from a collection
+func (c *Client) RemoveCollectionItem(collectionKey, ratingKey string) error {
+	return c.request("DELETE", "/library/collections/"+collectionKey+"/items/"+ratingKey, nil, nil)
+}
+
+// MoveCollectionItem places an item directly after another (or first when
+// after is empty). Only meaningful with custom sort.
+func (c *Client) MoveCollectionItem(collectionKey, ratingKey, after string) error {
+	params := url.Values{}
+	if after != "" {
+		params.Set("after", after)
+	}
+	return c.request("PUT", "/library/collections/"+collectionKey+"/items/"+ratingKey+"/move", params, nil)
+}
+
+// SetCollectionSort sets the collection's sort mode (CollectionSort*)
+func (c *Client) SetCollectionSort(collectionKey string, mode int) error {
+	params := url.Values{}
+	params.Set("collectionSort", fmt.Sprintf("%d", mode))
+	return c.request("PUT", "/library/metadata/"+collectionKey+"/prefs", params, nil)
+}
+
+// SetCollectionSummary sets and locks the collection's summary
+func (c *Client) SetCollectionSummary(sectionKey, collectionKey, summary string) error {
+	params := url.Values{}
+	params.Set("type", fmt.Sprintf("%d", TypeCollection))
+	params.Set("id", collectionKey)
+	params.Set("summary.value", summary)
+	params.Set("summary.locked", "1")
+	return c.request("PUT", "/library/sections/"+sectionKey+"/all", params, nil)
+}
+
+// AddLabel adds a label to a library item without touching its other labels
+func (c *Client) AddLabel(sectionKey string, itemType int, ratingKey, label string) error {
+	params := url.Values{}
+	params.Set("type", fmt.Sprintf("%d", itemType))
+	params.Set("id", ratingKey)
+	params.Set("label[0].tag.tag", label)
+	params.Set("label.locked", "1")
+	return c.request("PUT", "/library/sections/"+sectionKey+"/all", params, nil)
+}
+
+// RemoveLabel removes a label from a library item
+func (c *Client) RemoveLabel(sectionKey string, itemType int, ratingKey, label string) error {
+	params := url.Values{}
+	params.Set("type", fmt.Sprintf("%d", itemType))
+	params.Set("id", ratingKey)
+	params.Set("label[].tag.tag-", label)
+	return c.request("PUT", "/library/sections/"+sectionKey+"/all", params, nil)
+}
+
+// GetLabeledItems returns the rating keys of items in a section carrying a
+// label, identified by its tag key (see FindLabel)
+func (c *Client) GetLabeledItems(sectionKey string, itemType int, labelKey string) ([]string, error) {
+	params := url.Values{}
+	params.Set("type", fmt.Sprintf("%d", itemType))
+	params.Set("label", labelKey)
+
+	var container MediaContainer
+	if err := c.request("GET", "/library/sections/"+sectionKey+"/all", params, &container); err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, v := range container.Video {
+		keys = append(keys, v.RatingKey)
+	}
+	for _, d := range container.Directory {
+		keys = append(keys, d.RatingKey)
+	}
+	return keys, nil
+}
+
+// FindLabel looks up a label tag in a section by title, returning nil if absent
+func (c *Client) FindLabel(sectionKey, title string) (*Label, error) {
+	var container struct {
+		Directory []Label `xml:"Directory"`
+	}
+	if err := c.request("GET", "/library/sections/"+sectionKey+"/label", nil, &container); err != nil {
+		return nil, err
+	}
+
+	for _, l := range container.Directory {
+		if strings.EqualFold(l.Title, title) {
+			l := l
+			return &l, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,68 @@
+package plex
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ExternalIDs are the third-party IDs a Plex GUID can carry
+type ExternalIDs struct {
+	TMDbID int
+	IMDbID string
+	TVDBID int
+}
+
+// ParseGUID extracts external IDs from a Plex GUID. It understands the new
+// agent form ("tmdb://603", "imdb://tt0133093", "tvdb://121361") as well as
+// the legacy agents ("com.plexapp.agents.themoviedb://603?lang=en",
+// "com.plexapp.agents.thetvdb://121361/1/1?lang=en"). plex:// GUIDs carry no
+// external ID and yield a zero value. For a legacy TVDB episode GUID the
+// show's ID is returned.
+func ParseGUID(guid string) ExternalIDs {
+	var ids ExternalIDs
+
+	scheme, rest, ok := strings.Cut(guid, "://")
+	if !ok {
+		return ids
+	}
+	rest, _, _ = strings.Cut(rest, "?")
+	id, _, _ := strings.Cut(rest, "/")
+	if id == "" {
+		return ids
+	}
+
+	switch strings.TrimPrefix(scheme, "com.plexapp.agents.") {
+	case "tmdb", "themoviedb":
+		ids.TMDbID, _ = strconv.Atoi(id)
+	case "imdb":
+		if strings.HasPrefix(id, "tt") {
+			ids.IMDbID = id
+		}
+	case "tvdb", "thetvdb":
+		ids.TVDBID, _ = strconv.Atoi(id)
+	}
+	return ids
+}
+
+// Merge fills the IDs still missing from other
+func (ids *ExternalIDs) Merge(other ExternalIDs) {
+	if ids.TMDbID == 0 {
+		ids.TMDbID = other.TMDbID
+	}
+	if ids.IMDbID == "" {
+		ids.IMDbID = other.IMDbID
+	}
+	if ids.TVDBID == 0 {
+		ids.TVDBID = other.TVDBID
+	}
+}
+
+// parseTMDbID extracts the TMDb ID from a Plex GUID like "tmdb://12345"
+func parseTMDbID(guid string) int {
+	return ParseGUID(guid).TMDbID
+}
+
+// parseIMDbID extracts the IMDb ID from a Plex GUID like "imdb://tt1234567"
+func parseIMDbID(guid string) string {
+	return ParseGUID(guid).IMDbID
+}
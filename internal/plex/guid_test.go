@@ -0,0 +1,30 @@
+package plex
+
+import "testing"
+
+func TestParseGUID(t *testing.T) {
+	tests := []struct {
+		guid string
+		want ExternalIDs
+	}{
+		{"tmdb://603", ExternalIDs{TMDbID: 603}},
+		{"imdb://tt0133093", ExternalIDs{IMDbID: "tt0133093"}},
+		{"tvdb://121361", ExternalIDs{TVDBID: 121361}},
+		{"com.plexapp.agents.themoviedb://603?lang=en", ExternalIDs{TMDbID: 603}},
+		{"com.plexapp.agents.imdb://tt0133093?lang=en", ExternalIDs{IMDbID: "tt0133093"}},
+		// Legacy episode GUIDs yield the show's ID
+		{"com.plexapp.agents.thetvdb://121361/1/1?lang=en", ExternalIDs{TVDBID: 121361}},
+		{"imdb://0133093", ExternalIDs{}},
+		{"tmdb://abc", ExternalIDs{}},
+		{"plex://movie/5d776825880197001ec967c1", ExternalIDs{}},
+		{"local://42", ExternalIDs{}},
+		{"tmdb://", ExternalIDs{}},
+		{"603", ExternalIDs{}},
+		{"", ExternalIDs{}},
+	}
+	for _, tt := range tests {
+		if got := ParseGUID(tt.guid); got != tt.want {
+			t.Errorf("ParseGUID(%q) = %+v, want %+v", tt.guid, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package plex
+
+import (
+	"net/url"
+)
+
+// HubVisibility controls where a collection's hub is promoted
+type HubVisibility struct {
+	Recommended bool // the library's Recommended tab
+	Home        bool // the server owner's Home screen
+	Shared      bool // Home screens of users the library is shared with
+}
+
+// ManagedHub is a collection hub as reported by the hub management endpoint
+type ManagedHub struct {
+	Identifier            string `xml:"identifier,attr"`
+	Title                 string `xml:"title,attr"`
+	PromotedToRecommended bool   `xml:"promotedToRecommended,attr"`
+	PromotedToOwnHome     bool   `xml:"promotedToOwnHome,attr"`
+	PromotedToSharedHome  bool   `xml:"promotedToSharedHome,attr"`
+}
+
+// Visibility returns the hub's current promotion state
+func (h *ManagedHub) Visibility() HubVisibility {
+	return HubVisibility{
+		Recommended: h.PromotedToRecommended,
+		Home:        h.PromotedToOwnHome,
+		Shared:      h.PromotedToSharedHome,
+	}
+}
+
+// GetCollectionHub returns the managed hub for a collection, or nil if the
+// collection has never been promoted
+func (c *Client) GetCollectionHub(sectionKey, collectionKey string) (*ManagedHub, error) {
+	params := url.Values{}
+	params.Set("metadataItemId", collectionKey)
+
+	var container struct {
+		Hub []ManagedHub `xml:"Hub"`
+	}
+	if err := c.request("GET", "/hubs/sections/"+sectionKey+"/manage", params, &container); err != nil {
+		return nil, err
+	}
+	if len(container.Hub) == 0 {
+		return nil, nil
+	}
+	return &container.Hub[0], nil
+}
+
+// SetCollectionVisibility promotes (or demotes) a collection's hub. The hub
+// is created on first use and updated in place afterwards.
+func (c *Client) SetCollectionVisibility(sectionKey, collectionKey string, v HubVisibility) error {
+	hub, err := c.GetCollectionHub(sectionKey, collectionKey)
+	if err != nil {
+		return err
+	}
+	if hub != nil && hub.Visibility() == v {
+		return nil
+	}
+
+	params := url.Values{}
+	params.Set("promotedToRecommended", boolParam(v.Recommended))
+	params.Set("promotedToOwnHome", boolParam(v.Home))
+	params.Set("promotedToSharedHome", boolParam(v.Shared))
+
+	if hub != nil {
+		return c.request("PUT", "/hubs/sections/"+sectionKey+"/manage/"+url.PathEscape(hub.Identifier), params, nil)
+	}
+	params.Set("metadataItemId", collectionKey)
+	return c.request("POST", "/hubs/sections/"+sectionKey+"/manage", params, nil)
+}
+
+func boolParam(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
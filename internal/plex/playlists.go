@@ -0,0 +1,131 @@
+package plex
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Playlist is a video playlist owned by the client token's user
+type Playlist struct {
+	RatingKey string `xml:"ratingKey,attr"`
+	Title     string `xml:"title,attr"`
+	Smart     string `xml:"smart,attr"`
+	LeafCount int    `xml:"leafCount,attr"`
+}
+
+// PlaylistItem is one entry of a playlist. PlaylistItemID identifies the
+// entry itself and is what remove/move operate on.
+type PlaylistItem struct {
+	RatingKey            string `xml:"ratingKey,attr"`
+	PlaylistItemID       string `xml:"playlistItemID,attr"`
+	Type                 string `xml:"type,attr"`
+	GrandparentRatingKey string `xml:"grandparentRatingKey,attr"`
+}
+
+// AddedKey returns the rating key the entry was added under. Plex expands
+// a show into its episodes, so an episode maps back to its show.
+func (i PlaylistItem) AddedKey() string {
+	if i.Type == "episode" && i.GrandparentRatingKey != "" {
+		return i.GrandparentRatingKey
+	}
+	return i.RatingKey
+}
+
+// WithToken returns a client for the same server authenticated as another
+// user, sharing the HTTP client and section filter. Playlists and hub
+// visibility are per user, so they are managed through such clients.
+func (c *Client) WithToken(token string) *Client {
+	clone := *c
+	clone.token = token
+	return &clone
+}
+
+// FindPlaylist looks up a video playlist by title, returning nil if absent
+func (c *Client) FindPlaylist(title string) (*Playlist, error) {
+	params := url.Values{}
+	params.Set("playlistType", "video")
+
+	var container struct {
+		Playlist []Playlist `xml:"Playlist"`
+	}
+	if err := c.request("GET", "/playlists", params, &container); err != nil {
+		return nil, err
+	}
+
+	for _, pl := range container.Playlist {
+		if pl.Title == title {
+			pl := pl
+			return &pl, nil
+		}
+	}
+	return nil, nil
+}
+
+// CreatePlaylist creates a video playlist holding the given items in order
+func (c *Client) CreatePlaylist(title string, ratingKeys []string) (*Playlist, error) {
+	uri, err := c.itemsURI(ratingKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	params.Set("type", "video")
+	params.Set("title", title)
+	params.Set("smart", "0")
+	params.Set("uri", uri)
+
+	var container struct {
+		Playlist []Playlist `xml:"Playlist"`
+	}
+	if err := c.request("POST", "/playlists", params, &container); err != nil {
+		return nil, err
+	}
+	if len(container.Playlist) == 0 {
+		return nil, fmt.Errorf("plex did not return the created playlist")
+	}
+	return &container.Playlist[0], nil
+}
+
+// DeletePlaylist removes a playlist
+func (c *Client) DeletePlaylist(playlistKey string) error {
+	return c.request("DELETE", "/playlists/"+playlistKey, nil, nil)
+}
+
+// GetPlaylistItems returns a playlist's entries in order
+func (c *Client) GetPlaylistItems(playlistKey string) ([]PlaylistItem, error) {
+	var container struct {
+		Video     []PlaylistItem `xml:"Video"`
+		Directory []PlaylistItem `xml:"Directory"`
+	}
+	if err := c.request("GET", "/playlists/"+playlistKey+"/items", nil, &container); err != nil {
+		return nil, err
+	}
+	return append(container.Video, container.Directory...), nil
+}
+
+// AddPlaylistItems appends items to a playlist
+func (c *Client) AddPlaylistItems(playlistKey string, ratingKeys []string) error {
+	uri, err := c.itemsURI(ratingKeys)
+	if err != nil {
+		return err
+	}
+
+	params := url.Values{}
+	params.Set("uri", uri)
+	return c.request("PUT", "/playlists/"+playlistKey+"/items", params, nil)
+}
+
+// RemovePlaylistItem removes a single entry from a playlist
+func (c *Client) RemovePlaylistItem(playlistKey, playlistItemID string) error {
+	return c.request("DELETE", "/playlists/"+playlistKey+"/items/"+playlistItemID, nil, nil)
+}
+
+// MovePlaylistItem places an entry directly after another (or first when
+// after is empty). Both are playlist item IDs, not rating keys.
+func (c *Client) MovePlaylistItem(playlistKey, playlistItemID, after string) error {
+	params := url.Values{}
+	if after != "" {
+		params.Set("after", after)
+	}
+	return c.request("PUT", "/playlists/"+playlistKey+"/items/"+playlistItemID+"/move", params, nil)
+}
@@ -20,20 +20,59 @@ func init() {
 	log = logging.GetLogger("plex")
 }
 
+// DefaultPageSize is the number of items requested per library page
+const DefaultPageSize = 500
+
 // Client handles Plex API interactions
 type Client struct {
-	baseURL string
-	token   string
-	client  *http.Client
+	baseURL  string
+	token    string
+	pageSize int
+	client   *http.Client
+
+	machineID   string // cached server identity for server:// URIs
+	discoverURL string // Plex Discover base URL for watchlists
+
+	includeSections []string
+	excludeSections []string
 }
 
-// NewClient creates a new Plex client
-func NewClient(baseURL, token string) *Client {
+// NewClient creates a new Plex client. pageSize controls how many items are
+// requested per library page; zero uses DefaultPageSize.
+func NewClient(baseURL, token string, pageSize int) *Client {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
 	return &Client{
-		baseURL: baseURL,
-		token:   token,
-		client:  &http.Client{Timeout: 30 * time.Second},
+		baseURL:  baseURL,
+		token:    token,
+		pageSize: pageSize,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// SetSectionFilter limits inventory to the given library sections, matched by
+// key or (case-insensitive) title. An empty include list means all sections.
+func (c *Client) SetSectionFilter(include, exclude []string) {
+	c.includeSections = include
+	c.excludeSections = exclude
+}
+
+// sectionSelected reports whether a section passes the include/exclude filter
+func (c *Client) sectionSelected(section LibrarySection) bool {
+	if len(c.includeSections) > 0 && !matchesSection(section, c.includeSections) {
+		return false
+	}
+	return !matchesSection(section, c.excludeSections)
+}
+
+func matchesSection(section LibrarySection, names []string) bool {
+	for _, name := range names {
+		if name == section.Key || strings.EqualFold(name, section.Title) {
+			return true
+		}
 	}
+	return false
 }
 
 // MediaItem represents a movie or TV show in the Plex library
@@ -44,6 +83,14 @@ type MediaItem struct {
 	TMDbID    int
 	IMDbID    string
 	RatingKey string
+	AddedAt   int64 // unix seconds
+	UpdatedAt int64 // unix seconds
+
+	SectionKey   string
+	SectionTitle string
+
+	UserRating  float64 // 0-10 for the requesting user; 0 when unrated
+	LastRatedAt int64   // unix seconds
 }
 
 // MediaContainer is the XML response structure from Plex
@@ -60,8 +107,12 @@ type Video struct {
 	Year        int     `xml:"year,attr"`
 	Type        string  `xml:"type,attr"`
 	RatingKey   string  `xml:"ratingKey,attr"`
-	GUIDAttr    string  `xml:"guid,attr"`    // Old agent format
-	GUID        []GUID  `xml:"Guid"`         // New agent format
+	AddedAt     int64   `xml:"addedAt,attr"`
+	UpdatedAt   int64   `xml:"updatedAt,attr"`
+	UserRating  float64 `xml:"userRating,attr"`
+	LastRatedAt int64   `xml:"lastRatedAt,attr"`
+	GUIDAttr    string  `xml:"guid,attr"` // Old agent format
+	GUID        []GUID  `xml:"Guid"`      // New agent format
 	Media       []Media `xml:"Media"`
 }
 
@@ -70,8 +121,12 @@ type Directory struct {
 	Year        int     `xml:"year,attr"`
 	Type        string  `xml:"type,attr"`
 	RatingKey   string  `xml:"ratingKey,attr"`
-	GUIDAttr    string  `xml:"guid,attr"`    // Old agent format
-	GUID        []GUID  `xml:"Guid"`         // New agent format
+	AddedAt     int64   `xml:"addedAt,attr"`
+	UpdatedAt   int64   `xml:"updatedAt,attr"`
+	UserRating  float64 `xml:"userRating,attr"`
+	LastRatedAt int64   `xml:"lastRatedAt,attr"`
+	GUIDAttr    string  `xml:"guid,attr"` // Old agent format
+	GUID        []GUID  `xml:"Guid"`      // New agent format
 	Media       []Media `xml:"Media"`
 }
 
@@ -88,52 +143,77 @@ type Part struct {
 }
 
 // GetInventory fetches all movies and TV shows from Plex library
-// cachedTMDbIDs is a map of "ratingKey" -> TMDb ID from previous runs to avoid redundant API calls
-func (c *Client) GetInventory(cachedTMDbIDs map[string]int) ([]MediaItem, error) {
-	log.Info().Msg("fetching Plex library inventory")
+// cachedTMDbIDs is a map of "ratingKey" -> TMDb ID from previous runs to avoid redundant API calls.
+// When updatedSince is non-zero only items with updatedAt >= updatedSince
+// (unix seconds) are returned. A non-nil error alongside items means some
+// sections failed and the result is partial.
+func (c *Client) GetInventory(cachedTMDbIDs map[string]int, updatedSince int64) ([]MediaItem, error) {
+	log.Info().Int64("updated_since", updatedSince).Msg("fetching Plex library inventory")
 
 	var allItems []MediaItem
+	var failed []string
 
 	// Fetch movies
-	movies, err := c.getLibrarySection("movie", cachedTMDbIDs)
+	movies, err := c.getLibrarySection("movie", cachedTMDbIDs, updatedSince)
 	if err != nil {
 		log.Warn().Err(err).Msg("failed to fetch movies, continuing")
-	} else {
-		allItems = append(allItems, movies...)
+		failed = append(failed, "movie")
 	}
+	allItems = append(allItems, movies...)
 
 	// Fetch TV shows
-	shows, err := c.getLibrarySection("show", cachedTMDbIDs)
+	shows, err := c.getLibrarySection("show", cachedTMDbIDs, updatedSince)
 	if err != nil {
 		log.Warn().Err(err).Msg("failed to fetch TV shows, continuing")
-	} else {
-		allItems = append(allItems, shows...)
+		failed = append(failed, "show")
 	}
+	allItems = append(allItems, shows...)
 
 	log.Info().Int("count", len(allItems)).Msg("fetched Plex inventory")
+
+	if len(failed) > 0 {
+		return allItems, fmt.Errorf("partial inventory, failed to fetch: %s", strings.Join(failed, ", "))
+	}
 	return allItems, nil
 }
 
-func (c *Client) getLibrarySection(mediaType string, cachedTMDbIDs map[string]int) ([]MediaItem, error) {
+// getLibrarySection fetches every section of the given type. Items from
+// sections that succeeded are returned even when another section fails.
+func (c *Client) getLibrarySection(mediaType string, cachedTMDbIDs map[string]int, updatedSince int64) ([]MediaItem, error) {
 	// Get all library sections
 	sections, err := c.getLibrarySections()
 	if err != nil {
 		return nil, err
 	}
 
+	var filter string
+	if updatedSince > 0 {
+		filter = "updatedAt>=" + strconv.FormatInt(updatedSince, 10)
+	}
+
 	var items []MediaItem
+	var firstErr error
 	for _, section := range sections {
-		if section.Type == mediaType {
-			sectionItems, err := c.getLibrarySectionContents(section.Key, cachedTMDbIDs)
-			if err != nil {
-				log.Warn().Err(err).Str("section", section.Title).Msg("failed to fetch section")
-				continue
+		if section.Type != mediaType {
+			continue
+		}
+		if !c.sectionSelected(section) {
+			log.Debug().Str("section", section.Title).Str("key", section.Key).Msg("skipping excluded section")
+			continue
+		}
+
+		sectionItems, err := c.getLibrarySectionContents(section, cachedTMDbIDs, filter)
+		if err != nil {
+			log.Warn().Err(err).Str("section", section.Title).Msg("failed to fetch section")
+			if firstErr == nil {
+				firstErr = fmt.Errorf("section %s: %w", section.Title, err)
 			}
-			items = append(items, sectionItems...)
+			continue
 		}
+		items = append(items, sectionItems...)
 	}
 
-	return items, nil
+	return items, firstErr
 }
 
 type LibrarySection struct {
@@ -165,22 +245,70 @@ func (c *Client) getLibrarySections() ([]LibrarySection, error) {
 		Directory []LibrarySection `xml:"Directory"`
 	}
 
-	body, _ := io.ReadAll(resp.Body)
-	if err := xml.Unmarshal(body, &container); err != nil {
+	if err := xml.NewDecoder(resp.Body).Decode(&container); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
-
 	return container.Directory, nil
 }
 
-func (c *Client) getLibrarySectionContents(sectionKey string, cachedTMDbIDs map[string]int) ([]MediaItem, error) {
-	req, err := http.NewRequest("GET", c.baseURL+"/library/sections/"+sectionKey+"/all", nil)
+// getLibrarySectionContents pages through a library section using
+// X-Plex-Container-Start/Size so large libraries are never held in one response.
+// filter is an optional raw Plex filter expression such as "updatedAt>=123".
+func (c *Client) getLibrarySectionContents(section LibrarySection, cachedTMDbIDs map[string]int, filter string) ([]MediaItem, error) {
+	var items []MediaItem
+
+	start := 0
+	for {
+		page, err := c.getLibrarySectionPage(section.Key, start, cachedTMDbIDs, filter)
+		if err != nil {
+			return nil, err
+		}
+		for i := range page.items {
+			page.items[i].SectionKey = section.Key
+			page.items[i].SectionTitle = section.Title
+		}
+		items = append(items, page.items...)
+		start += page.size
+
+		log.Info().
+			Str("section", section.Title).
+			Int("fetched", start).
+			Int("total", page.totalSize).
+			Msg("fetched library page")
+
+		if page.size == 0 || start >= page.totalSize {
+			break
+		}
+	}
+
+	return items, nil
+}
+
+// sectionPage is a single page of a library section listing
+type sectionPage struct {
+	items     []MediaItem
+	size      int // number of elements returned in this page
+	totalSize int // total number of elements in the section
+}
+
+func (c *Client) getLibrarySectionPage(sectionKey string, start int, cachedTMDbIDs map[string]int, filter string) (*sectionPage, error) {
+	// includeGuids returns Guid children in the listing, which spares a
+	// per-item metadata call for most shows. Filters are appended raw since
+	// Plex expects operators like >= unescaped.
+	query := "includeGuids=1"
+	if filter != "" {
+		query += "&" + filter
+	}
+
+	req, err := http.NewRequest("GET", c.baseURL+"/library/sections/"+sectionKey+"/all?"+query, nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("X-Plex-Token", c.token)
 	req.Header.Set("Accept", "application/xml")
+	req.Header.Set("X-Plex-Container-Start", strconv.Itoa(start))
+	req.Header.Set("X-Plex-Container-Size", strconv.Itoa(c.pageSize))
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -192,170 +320,174 @@ func (c *Client) getLibrarySectionContents(sectionKey string, cachedTMDbIDs map[
 		return nil, fmt.Errorf("plex returned status %d", resp.StatusCode)
 	}
 
-	body, _ := io.ReadAll(resp.Body)
-	var container MediaContainer
-	if err := xml.Unmarshal(body, &container); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
-	}
-
-	var items []MediaItem
-
-	// Process videos (movies)
-	for _, v := range container.Video {
-		item := MediaItem{
-			Title:     v.Title,
-			Year:      v.Year,
-			Type:      "movie",
-			RatingKey: v.RatingKey,
+	page := &sectionPage{}
+	dec := xml.NewDecoder(resp.Body)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
 		}
-		// Parse GUIDs - check both old agent format (guid attr) and new format (Guid children)
-		guidStrings := []string{}
-
-		// Check old agent format first (guid attribute)
-		if v.GUIDAttr != "" {
-			guidStrings = append(guidStrings, v.GUIDAttr)
-			if tmdbID := parseTMDbID(v.GUIDAttr); tmdbID > 0 {
-				item.TMDbID = tmdbID
-			}
-			if imdbID := parseIMDbID(v.GUIDAttr); imdbID != "" {
-				item.IMDbID = imdbID
-			}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
 		}
 
-		// Check new agent format (Guid children)
-		for _, guid := range v.GUID {
-			guidStrings = append(guidStrings, guid.ID)
-			if tmdbID := parseTMDbID(guid.ID); tmdbID > 0 {
-				item.TMDbID = tmdbID
-			}
-			if imdbID := parseIMDbID(guid.ID); imdbID != "" {
-				item.IMDbID = imdbID
-			}
+		el, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
 		}
 
-		// If no TMDb ID found in GUIDs, try to extract from file path
-		if item.TMDbID == 0 {
-			for _, media := range v.Media {
-				for _, part := range media.Part {
-					if tmdbID := extractTMDbIDFromPath(part.File); tmdbID > 0 {
-						item.TMDbID = tmdbID
-						log.Debug().Str("title", v.Title).Int("tmdb_id", tmdbID).Str("path", part.File).Msg("extracted TMDb ID from file path")
-						break
-					}
-				}
-				if item.TMDbID > 0 {
-					break
+		switch el.Name.Local {
+		case "MediaContainer":
+			for _, attr := range el.Attr {
+				if attr.Name.Local == "totalSize" {
+					page.totalSize, _ = strconv.Atoi(attr.Value)
 				}
 			}
+		case "Video":
+			var v Video
+			if err := dec.DecodeElement(&v, &el); err != nil {
+				return nil, fmt.Errorf("failed to decode video: %w", err)
+			}
+			page.size++
+			page.items = append(page.items, c.videoToItem(v))
+		case "Directory":
+			var d Directory
+			if err := dec.DecodeElement(&d, &el); err != nil {
+				return nil, fmt.Errorf("failed to decode directory: %w", err)
+			}
+			page.size++
+			page.items = append(page.items, c.directoryToItem(d, cachedTMDbIDs))
 		}
+	}
 
-		// Add item even without TMDb ID - we can match by title later
-		if item.TMDbID > 0 || len(guidStrings) > 0 {
-			items = append(items, item)
-		}
+	// Servers that ignore paging headers return everything at once
+	if page.totalSize < start+page.size {
+		page.totalSize = start + page.size
 	}
 
-	// Process directories (TV shows)
-	for _, d := range container.Directory {
-		item := MediaItem{
-			Title:     d.Title,
-			Year:      d.Year,
-			Type:      "tv",
-			RatingKey: d.RatingKey,
+	return page, nil
+}
+
+// videoToItem converts a movie listing entry into a MediaItem
+func (c *Client) videoToItem(v Video) MediaItem {
+	item := MediaItem{
+		Title:     v.Title,
+		Year:      v.Year,
+		Type:      "movie",
+		RatingKey: v.RatingKey,
+		AddedAt:   v.AddedAt,
+		UpdatedAt: v.UpdatedAt,
+
+		UserRating:  v.UserRating,
+		LastRatedAt: v.LastRatedAt,
+	}
+
+	// Parse GUIDs - check both old agent format (guid attr) and new format (Guid children)
+	applyGUIDs(&item, v.GUIDAttr, v.GUID)
+
+	// If no TMDb ID found in GUIDs, try to extract from file path
+	if item.TMDbID == 0 {
+		if tmdbID, path := tmdbIDFromMedia(v.Media); tmdbID > 0 {
+			item.TMDbID = tmdbID
+			log.Debug().Str("title", v.Title).Int("tmdb_id", tmdbID).Str("path", path).Msg("extracted TMDb ID from file path")
 		}
-		// Parse GUIDs - check both old agent format (guid attr) and new format (Guid children)
-		guidStrings := []string{}
-
-		// Check old agent format first (guid attribute)
-		if d.GUIDAttr != "" {
-			guidStrings = append(guidStrings, d.GUIDAttr)
-			if tmdbID := parseTMDbID(d.GUIDAttr); tmdbID > 0 {
-				item.TMDbID = tmdbID
-			}
-			if imdbID := parseIMDbID(d.GUIDAttr); imdbID != "" {
-				item.IMDbID = imdbID
-			}
+	}
+
+	// Items without a TMDb ID are kept - they can still be matched by title/year
+	return item
+}
+
+// directoryToItem converts a show listing entry into a MediaItem, falling
+// back to the cache and then a per-item metadata call for the TMDb ID
+func (c *Client) directoryToItem(d Directory, cachedTMDbIDs map[string]int) MediaItem {
+	item := MediaItem{
+		Title:     d.Title,
+		Year:      d.Year,
+		Type:      "tv",
+		RatingKey: d.RatingKey,
+		AddedAt:   d.AddedAt,
+		UpdatedAt: d.UpdatedAt,
+
+		UserRating:  d.UserRating,
+		LastRatedAt: d.LastRatedAt,
+	}
+
+	// Parse GUIDs - check both old agent format (guid attr) and new format (Guid children)
+	applyGUIDs(&item, d.GUIDAttr, d.GUID)
+
+	// If no TMDb ID found in GUIDs, try to extract from file path
+	if item.TMDbID == 0 {
+		if tmdbID, path := tmdbIDFromMedia(d.Media); tmdbID > 0 {
+			item.TMDbID = tmdbID
+			log.Debug().Str("title", d.Title).Int("tmdb_id", tmdbID).Str("path", path).Msg("extracted TMDb ID from file path")
 		}
+	}
 
-		// Check new agent format (Guid children)
-		for _, guid := range d.GUID {
-			guidStrings = append(guidStrings, guid.ID)
-			if tmdbID := parseTMDbID(guid.ID); tmdbID > 0 {
-				item.TMDbID = tmdbID
-			}
-			if imdbID := parseIMDbID(guid.ID); imdbID != "" {
-				item.IMDbID = imdbID
-			}
+	// Check cache first before making API call
+	if item.TMDbID == 0 {
+		if cachedID, ok := cachedTMDbIDs[d.RatingKey]; ok {
+			item.TMDbID = cachedID
+			log.Debug().Str("title", d.Title).Int("tmdb_id", cachedID).Msg("using cached TMDb ID")
 		}
+	}
 
-		// If no TMDb ID found in GUIDs, try to extract from file path
-		if item.TMDbID == 0 {
-			for _, media := range d.Media {
-				for _, part := range media.Part {
-					if tmdbID := extractTMDbIDFromPath(part.File); tmdbID > 0 {
+	// If still no TMDb ID, fetch individual metadata (TV shows need this)
+	if item.TMDbID == 0 {
+		metadata, err := c.getItemMetadata(d.RatingKey)
+		if err != nil {
+			log.Warn().Err(err).Str("title", d.Title).Str("rating_key", d.RatingKey).Msg("failed to fetch item metadata")
+		} else {
+			// Check for Guid children in the detailed metadata
+			if len(metadata.Directory) > 0 && len(metadata.Directory[0].GUID) > 0 {
+				for _, guid := range metadata.Directory[0].GUID {
+					if tmdbID := parseTMDbID(guid.ID); tmdbID > 0 {
 						item.TMDbID = tmdbID
-						log.Debug().Str("title", d.Title).Int("tmdb_id", tmdbID).Str("path", part.File).Msg("extracted TMDb ID from file path")
+						log.Debug().Str("title", d.Title).Int("tmdb_id", tmdbID).Msg("extracted TMDb ID from detailed metadata")
 						break
 					}
 				}
-				if item.TMDbID > 0 {
-					break
-				}
 			}
 		}
+	}
 
-		// Check cache first before making API call
-		if item.TMDbID == 0 {
-			if cachedID, ok := cachedTMDbIDs[d.RatingKey]; ok {
-				item.TMDbID = cachedID
-				log.Debug().Str("title", d.Title).Int("tmdb_id", cachedID).Msg("using cached TMDb ID")
-			}
-		}
+	// Items without a TMDb ID are kept - they can still be matched by title/year
+	return item
+}
 
-		// If still no TMDb ID, fetch individual metadata (TV shows need this)
-		if item.TMDbID == 0 {
-			metadata, err := c.getItemMetadata(d.RatingKey)
-			if err != nil {
-				log.Warn().Err(err).Str("title", d.Title).Str("rating_key", d.RatingKey).Msg("failed to fetch item metadata")
-			} else {
-				// Check for Guid children in the detailed metadata
-				if len(metadata.Directory) > 0 && len(metadata.Directory[0].GUID) > 0 {
-					for _, guid := range metadata.Directory[0].GUID {
-						if tmdbID := parseTMDbID(guid.ID); tmdbID > 0 {
-							item.TMDbID = tmdbID
-							log.Debug().Str("title", d.Title).Int("tmdb_id", tmdbID).Msg("extracted TMDb ID from detailed metadata")
-							break
-						}
-					}
-				}
-			}
+// applyGUIDs fills TMDb/IMDb IDs from the legacy guid attribute and the
+// newer Guid children
+func applyGUIDs(item *MediaItem, guidAttr string, guids []GUID) {
+	// Check old agent format first (guid attribute)
+	if guidAttr != "" {
+		if tmdbID := parseTMDbID(guidAttr); tmdbID > 0 {
+			item.TMDbID = tmdbID
 		}
-
-		// Add item even without TMDb ID - we can match by title later
-		if item.TMDbID > 0 || len(guidStrings) > 0 {
-			items = append(items, item)
+		if imdbID := parseIMDbID(guidAttr); imdbID != "" {
+			item.IMDbID = imdbID
 		}
 	}
 
-	return items, nil
-}
-
-// parseTMDbID extracts TMDb ID from Plex GUID like "tmdb://12345"
-func parseTMDbID(guid string) int {
-	if strings.HasPrefix(guid, "tmdb://") {
-		var id int
-		fmt.Sscanf(guid, "tmdb://%d", &id)
-		return id
+	// Check new agent format (Guid children)
+	for _, guid := range guids {
+		if tmdbID := parseTMDbID(guid.ID); tmdbID > 0 {
+			item.TMDbID = tmdbID
+		}
+		if imdbID := parseIMDbID(guid.ID); imdbID != "" {
+			item.IMDbID = imdbID
+		}
 	}
-	return 0
 }
 
-// parseIMDbID extracts IMDb ID from Plex GUID like "imdb://tt1234567"
-func parseIMDbID(guid string) string {
-	if strings.HasPrefix(guid, "imdb://") {
-		return strings.TrimPrefix(guid, "imdb://")
+// tmdbIDFromMedia looks for a {tmdb-12345} marker in any media part path
+func tmdbIDFromMedia(media []Media) (int, string) {
+	for _, m := range media {
+		for _, part := range m.Part {
+			if tmdbID := extractTMDbIDFromPath(part.File); tmdbID > 0 {
+				return tmdbID, part.File
+			}
+		}
 	}
-	return ""
+	return 0, ""
 }
 
 // extractTMDbIDFromPath extracts TMDb ID from file paths like "/path/{tmdb-12345}/file.mkv"
@@ -390,9 +522,8 @@ func (c *Client) getItemMetadata(ratingKey string) (*MediaContainer, error) {
 		return nil, fmt.Errorf("plex returned status %d", resp.StatusCode)
 	}
 
-	body, _ := io.ReadAll(resp.Body)
 	var container MediaContainer
-	if err := xml.Unmarshal(body, &container); err != nil {
+	if err := xml.NewDecoder(resp.Body).Decode(&container); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
@@ -0,0 +1,50 @@
+package plex
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/dppeppel/scryarr/internal/plex/plextest"
+)
+
+func TestGetInventoryPages(t *testing.T) {
+	srv := plextest.NewServer()
+	defer srv.Close()
+	srv.AddSection(plextest.Section{Key: "1", Title: "Movies", Type: "movie"})
+
+	var want []string
+	for i := 1; i <= 7; i++ {
+		srv.AddItem(plextest.Item{SectionKey: "1", Title: fmt.Sprintf("Movie %d", i), Year: 2000 + i,
+			GUIDs: []string{fmt.Sprintf("tmdb://%d", 100+i)}, UpdatedAt: int64(i)})
+		want = append(want, fmt.Sprint(100+i))
+	}
+
+	// A page size that does not divide the section exercises the last,
+	// short page; a client ignoring Start would repeat the first page
+	client := NewClient(srv.URL, srv.Token, 3)
+	items, err := client.GetInventory(nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, item := range items {
+		if item.SectionKey != "1" || item.SectionTitle != "Movies" {
+			t.Errorf("item %q has section %q/%q", item.Title, item.SectionKey, item.SectionTitle)
+		}
+		got = append(got, fmt.Sprint(item.TMDbID))
+	}
+	sort.Strings(got)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("TMDb IDs = %v, want %v", got, want)
+	}
+
+	// Incremental fetches page through the filtered listing only
+	items, err = client.GetInventory(nil, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 4 {
+		t.Errorf("updated items = %d, want 4", len(items))
+	}
+}
@@ -0,0 +1,1016 @@
+// Package plextest provides an in-memory fake Plex Media Server that speaks
+// enough of the HTTP API to exercise inventory sync and collection publishing
+// without a real server.
+package plextest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Section is a library section on the fake server
+type Section struct {
+	Key   string
+	Title string
+	Type  string // movie | show
+}
+
+// Item is a movie or show in a section
+type Item struct {
+	RatingKey  string
+	SectionKey string
+	Title      string
+	Year       int
+	GUIDs      []string // e.g. tmdb://603, imdb://tt0133093
+	Labels     []string
+	AddedAt    int64
+	UpdatedAt  int64
+
+	Type    string             // movie | show; defaults to the section's type
+	Ratings map[string]float64 // user name -> 0-10 star rating
+	RatedAt int64
+
+	Episodes    int // shows: episodes a playlist expands the show into; default 1
+	episodeKeys []string
+}
+
+// Collection is a regular or smart collection in a section
+type Collection struct {
+	RatingKey  string
+	SectionKey string
+	Title      string
+	Summary    string
+	Smart      bool
+	LabelKey   string // smart collections: label tag key the collection filters on
+	Sort       int    // collectionSort pref
+	Labels     []string
+	Items      []string
+}
+
+// Playlist is a user's video playlist
+type Playlist struct {
+	RatingKey string
+	Owner     string
+	Title     string
+	Entries   []PlaylistEntry
+}
+
+// PlaylistEntry is one playlist item; ItemID is the playlistItemID.
+// Shows are added as their episodes, each with the show's ShowKey.
+type PlaylistEntry struct {
+	ItemID    string
+	RatingKey string
+	ShowKey   string
+}
+
+// Hub is the managed hub of a promoted collection
+type Hub struct {
+	Identifier    string
+	SectionKey    string
+	CollectionKey string
+	Recommended   bool
+	Home          bool
+	Shared        bool
+}
+
+// Server is a fake Plex server backed by httptest
+type Server struct {
+	*httptest.Server
+
+	Token       string
+	MachineID   string
+	AccountName string // Plex account the server is signed in to
+
+	mu          sync.Mutex
+	sections    []Section
+	items       map[string]*Item
+	collections map[string]*Collection
+	labelKeys   map[string]string // label title -> tag key
+	users       map[string]string // token -> user name
+	playlists   map[string]*Playlist
+	hubs        map[string]*Hub // collection key -> hub
+	watchlists  map[string][]*Item
+	nextKey     int
+}
+
+// OwnerName is the user name of requests made with the server token
+const OwnerName = "owner"
+
+// NewServer starts a fake Plex server. Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		Token:       "plextest-token",
+		MachineID:   "plextest-machine",
+		AccountName: OwnerName,
+		items:       make(map[string]*Item),
+		collections: make(map[string]*Collection),
+		labelKeys:   make(map[string]string),
+		users:       make(map[string]string),
+		playlists:   make(map[string]*Playlist),
+		hubs:        make(map[string]*Hub),
+		watchlists:  make(map[string][]*Item),
+		nextKey:     10000,
+	}
+	s.users[s.Token] = OwnerName
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", s.handleIdentity)
+	mux.HandleFunc("GET /myplex/account", s.handleAccount)
+	mux.HandleFunc("GET /library/sections", s.handleSections)
+	mux.HandleFunc("GET /library/sections/{section}/all", s.handleSectionAll)
+	mux.HandleFunc("PUT /library/sections/{section}/all", s.handleSectionEdit)
+	mux.HandleFunc("GET /library/sections/{section}/collections", s.handleSectionCollections)
+	mux.HandleFunc("GET /library/sections/{section}/label", s.handleSectionLabels)
+	mux.HandleFunc("GET /library/metadata/{key}", s.handleMetadata)
+	mux.HandleFunc("DELETE /library/metadata/{key}", s.handleDeleteMetadata)
+	mux.HandleFunc("PUT /library/metadata/{key}/prefs", s.handlePrefs)
+	mux.HandleFunc("POST /library/collections", s.handleCreateCollection)
+	mux.HandleFunc("GET /library/collections/{key}/children", s.handleCollectionChildren)
+	mux.HandleFunc("PUT /library/collections/{key}/items", s.handleAddCollectionItems)
+	mux.HandleFunc("DELETE /library/collections/{key}/items/{item}", s.handleRemoveCollectionItem)
+	mux.HandleFunc("PUT /library/collections/{key}/items/{item}/move", s.handleMoveCollectionItem)
+	mux.HandleFunc("GET /playlists", s.handlePlaylists)
+	mux.HandleFunc("POST /playlists", s.handleCreatePlaylist)
+	mux.HandleFunc("DELETE /playlists/{key}", s.handleDeletePlaylist)
+	mux.HandleFunc("GET /playlists/{key}/items", s.handlePlaylistItems)
+	mux.HandleFunc("PUT /playlists/{key}/items", s.handleAddPlaylistItems)
+	mux.HandleFunc("DELETE /playlists/{key}/items/{item}", s.handleRemovePlaylistItem)
+	mux.HandleFunc("PUT /playlists/{key}/items/{item}/move", s.handleMovePlaylistItem)
+	mux.HandleFunc("GET /hubs/sections/{section}/manage", s.handleGetHub)
+	mux.HandleFunc("POST /hubs/sections/{section}/manage", s.handleCreateHub)
+	mux.HandleFunc("PUT /hubs/sections/{section}/manage/{identifier}", s.handleUpdateHub)
+
+	s.Server = httptest.NewServer(s.authenticate(mux))
+	return s
+}
+
+// AddSection registers a library section
+func (s *Server) AddSection(sec Section) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sections = append(s.sections, sec)
+}
+
+// AddItem registers a library item, assigning a rating key if empty
+func (s *Server) AddItem(item Item) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if item.RatingKey == "" {
+		item.RatingKey = s.newKey()
+	}
+	for _, l := range item.Labels {
+		if _, ok := s.labelKeys[strings.ToLower(l)]; !ok {
+			s.labelKeys[strings.ToLower(l)] = s.newKey()
+		}
+	}
+	if s.isShow(&item) {
+		for i := 0; i < max(item.Episodes, 1); i++ {
+			item.episodeKeys = append(item.episodeKeys, s.newKey())
+		}
+	}
+	s.items[item.RatingKey] = &item
+	return item.RatingKey
+}
+
+// Collections returns a snapshot of the collections in a section, by title
+func (s *Server) Collections(sectionKey string) map[string]Collection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make(map[string]Collection)
+	for _, col := range s.collections {
+		if col.SectionKey == sectionKey {
+			c := *col
+			c.Items = s.collectionItems(col)
+			c.Labels = append([]string(nil), col.Labels...)
+			out[col.Title] = c
+		}
+	}
+	return out
+}
+
+// AddUser registers another account that can authenticate with token
+func (s *Server) AddUser(name, token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[token] = name
+}
+
+// AddWatchlistItem puts a title on a user's Discover watchlist, served at
+// /library/sections/watchlist/all (point the client's discover URL here)
+func (s *Server) AddWatchlistItem(user string, item Item) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watchlists[user] = append(s.watchlists[user], &item)
+}
+
+// Playlists returns a snapshot of a user's playlists, by title
+func (s *Server) Playlists(user string) map[string]Playlist {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make(map[string]Playlist)
+	for _, pl := range s.playlists {
+		if pl.Owner == user {
+			c := *pl
+			c.Entries = append([]PlaylistEntry(nil), pl.Entries...)
+			out[pl.Title] = c
+		}
+	}
+	return out
+}
+
+// Hub returns the managed hub of a collection, or nil if never promoted
+func (s *Server) Hub(collectionKey string) *Hub {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if hub, ok := s.hubs[collectionKey]; ok {
+		h := *hub
+		return &h
+	}
+	return nil
+}
+
+// Labels returns the labels carried by an item
+func (s *Server) Labels(ratingKey string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if item, ok := s.items[ratingKey]; ok {
+		return append([]string(nil), item.Labels...)
+	}
+	return nil
+}
+
+func (s *Server) newKey() string {
+	s.nextKey++
+	return strconv.Itoa(s.nextKey)
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-Plex-Token")
+		if token == "" {
+			token = r.URL.Query().Get("X-Plex-Token")
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.users[token]; !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// XML response shapes
+
+type xmlContainer struct {
+	XMLName           xml.Name       `xml:"MediaContainer"`
+	Size              int            `xml:"size,attr"`
+	TotalSize         int            `xml:"totalSize,attr,omitempty"`
+	MachineIdentifier string         `xml:"machineIdentifier,attr,omitempty"`
+	Videos            []xmlItem      `xml:"Video"`
+	Directories       []xmlDirectory `xml:"Directory"`
+	Playlists         []xmlPlaylist  `xml:"Playlist"`
+	Hubs              []xmlHub       `xml:"Hub"`
+}
+
+type xmlPlaylist struct {
+	RatingKey string `xml:"ratingKey,attr"`
+	Title     string `xml:"title,attr"`
+	Smart     string `xml:"smart,attr"`
+	LeafCount int    `xml:"leafCount,attr"`
+}
+
+type xmlHub struct {
+	Identifier            string `xml:"identifier,attr"`
+	Title                 string `xml:"title,attr"`
+	PromotedToRecommended string `xml:"promotedToRecommended,attr"`
+	PromotedToOwnHome     string `xml:"promotedToOwnHome,attr"`
+	PromotedToSharedHome  string `xml:"promotedToSharedHome,attr"`
+}
+
+type xmlItem struct {
+	RatingKey string    `xml:"ratingKey,attr"`
+	Title     string    `xml:"title,attr"`
+	Year      int       `xml:"year,attr,omitempty"`
+	Type      string    `xml:"type,attr"`
+	AddedAt   int64     `xml:"addedAt,attr,omitempty"`
+	UpdatedAt int64     `xml:"updatedAt,attr,omitempty"`
+	GUID      string    `xml:"guid,attr,omitempty"`
+	GUIDs     []xmlGUID `xml:"Guid"`
+
+	UserRating  float64  `xml:"userRating,attr,omitempty"`
+	LastRatedAt int64    `xml:"lastRatedAt,attr,omitempty"`
+	Labels      []xmlTag `xml:"Label"`
+
+	PlaylistItemID       string `xml:"playlistItemID,attr,omitempty"`
+	GrandparentRatingKey string `xml:"grandparentRatingKey,attr,omitempty"`
+}
+
+type xmlDirectory struct {
+	Key        string    `xml:"key,attr,omitempty"`
+	RatingKey  string    `xml:"ratingKey,attr,omitempty"`
+	Title      string    `xml:"title,attr"`
+	Year       int       `xml:"year,attr,omitempty"`
+	Type       string    `xml:"type,attr,omitempty"`
+	Smart      string    `xml:"smart,attr,omitempty"`
+	ChildCount int       `xml:"childCount,attr,omitempty"`
+	Summary    string    `xml:"summary,attr,omitempty"`
+	AddedAt    int64     `xml:"addedAt,attr,omitempty"`
+	UpdatedAt  int64     `xml:"updatedAt,attr,omitempty"`
+	GUID       string    `xml:"guid,attr,omitempty"`
+	GUIDs      []xmlGUID `xml:"Guid"`
+
+	UserRating  float64  `xml:"userRating,attr,omitempty"`
+	LastRatedAt int64    `xml:"lastRatedAt,attr,omitempty"`
+	Labels      []xmlTag `xml:"Label"`
+
+	PlaylistItemID string `xml:"playlistItemID,attr,omitempty"`
+}
+
+type xmlGUID struct {
+	ID string `xml:"id,attr"`
+}
+
+type xmlTag struct {
+	Tag string `xml:"tag,attr"`
+}
+
+func writeXML(w http.ResponseWriter, c xmlContainer) {
+	c.Size = len(c.Videos) + len(c.Directories)
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(c)
+}
+
+func (s *Server) section(key string) *Section {
+	for i := range s.sections {
+		if s.sections[i].Key == key {
+			return &s.sections[i]
+		}
+	}
+	return nil
+}
+
+// appendItem renders an item as a Video (movies) or Directory (shows).
+// playlistItemID is set when listing playlist entries.
+func (s *Server) appendItem(c *xmlContainer, item *Item, playlistItemID string) {
+	var guids []xmlGUID
+	for _, g := range item.GUIDs {
+		guids = append(guids, xmlGUID{ID: g})
+	}
+	var labels []xmlTag
+	for _, l := range item.Labels {
+		labels = append(labels, xmlTag{Tag: l})
+	}
+
+	if s.isShow(item) {
+		c.Directories = append(c.Directories, xmlDirectory{
+			RatingKey: item.RatingKey, Title: item.Title, Year: item.Year, Type: "show",
+			AddedAt: item.AddedAt, UpdatedAt: item.UpdatedAt, GUIDs: guids, Labels: labels,
+			PlaylistItemID: playlistItemID,
+		})
+		return
+	}
+	c.Videos = append(c.Videos, xmlItem{
+		RatingKey: item.RatingKey, Title: item.Title, Year: item.Year, Type: "movie",
+		AddedAt: item.AddedAt, UpdatedAt: item.UpdatedAt, GUIDs: guids, Labels: labels,
+		PlaylistItemID: playlistItemID,
+	})
+}
+
+// sectionItems returns a section's items ordered by rating key
+func (s *Server) sectionItems(sectionKey string) []*Item {
+	var items []*Item
+	for _, item := range s.items {
+		if item.SectionKey == sectionKey {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].RatingKey < items[j].RatingKey })
+	return items
+}
+
+// collectionItems resolves a collection's members; smart collections are
+// evaluated against item labels at request time
+func (s *Server) collectionItems(col *Collection) []string {
+	if !col.Smart {
+		return append([]string(nil), col.Items...)
+	}
+	var keys []string
+	for _, item := range s.sectionItems(col.SectionKey) {
+		if hasLabelKey(s, item, col.LabelKey) {
+			keys = append(keys, item.RatingKey)
+		}
+	}
+	return keys
+}
+
+func hasLabelKey(s *Server, item *Item, labelKey string) bool {
+	for _, l := range item.Labels {
+		if s.labelKeys[strings.ToLower(l)] == labelKey {
+			return true
+		}
+	}
+	return false
+}
+
+func hasLabel(item *Item, label string) bool {
+	return containsFold(item.Labels, label)
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// metadataKeys extracts rating keys from a server://.../library/metadata/1,2,3 URI
+func metadataKeys(uri string) []string {
+	i := strings.LastIndex(uri, "/library/metadata/")
+	if i < 0 {
+		return nil
+	}
+	var keys []string
+	for _, k := range strings.Split(uri[i+len("/library/metadata/"):], ",") {
+		if k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Handlers (called with s.mu held)
+
+func (s *Server) handleIdentity(w http.ResponseWriter, r *http.Request) {
+	writeXML(w, xmlContainer{MachineIdentifier: s.MachineID})
+}
+
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(struct {
+		XMLName  xml.Name `xml:"MyPlex"`
+		Username string   `xml:"username,attr"`
+	}{Username: s.AccountName})
+}
+
+func (s *Server) handleSections(w http.ResponseWriter, r *http.Request) {
+	var c xmlContainer
+	for _, sec := range s.sections {
+		c.Directories = append(c.Directories, xmlDirectory{Key: sec.Key, Title: sec.Title, Type: sec.Type})
+	}
+	writeXML(w, c)
+}
+
+func (s *Server) handleSectionAll(w http.ResponseWriter, r *http.Request) {
+	sectionKey := r.PathValue("section")
+	user := s.users[r.Header.Get("X-Plex-Token")]
+
+	var candidates []*Item
+	switch {
+	case sectionKey == "watchlist":
+		candidates = s.watchlists[user]
+	case s.section(sectionKey) != nil:
+		candidates = s.sectionItems(sectionKey)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	// Plex spells comparison filters as literal keys like "updatedAt>="
+	var updatedSince int64
+	var minRating float64
+	for key, values := range r.URL.Query() {
+		if len(values) == 0 {
+			continue
+		}
+		switch key {
+		case "updatedAt>":
+			updatedSince, _ = strconv.ParseInt(strings.TrimPrefix(values[0], "="), 10, 64)
+		case "userRating>":
+			minRating, _ = strconv.ParseFloat(strings.TrimPrefix(values[0], "="), 64)
+		}
+	}
+	labelKey := r.URL.Query().Get("label")
+
+	var matched []*Item
+	for _, item := range candidates {
+		if updatedSince > 0 && item.UpdatedAt < updatedSince {
+			continue
+		}
+		if minRating > 0 && item.Ratings[user] < minRating {
+			continue
+		}
+		if labelKey != "" && !hasLabelKey(s, item, labelKey) {
+			continue
+		}
+		matched = append(matched, item)
+	}
+
+	start, _ := strconv.Atoi(r.Header.Get("X-Plex-Container-Start"))
+	size, err := strconv.Atoi(r.Header.Get("X-Plex-Container-Size"))
+	if err != nil || size <= 0 {
+		size = len(matched)
+	}
+	end := start + size
+	if start > len(matched) {
+		start = len(matched)
+	}
+	if end > len(matched) {
+		end = len(matched)
+	}
+
+	c := xmlContainer{TotalSize: len(matched)}
+	for _, item := range matched[start:end] {
+		s.appendItem(&c, item, "")
+		if rating := item.Ratings[user]; rating > 0 {
+			s.setUserRating(&c, item, rating)
+		}
+	}
+	writeXML(w, c)
+}
+
+// setUserRating annotates item, which must be the last one appended, with a rating
+func (s *Server) setUserRating(c *xmlContainer, item *Item, rating float64) {
+	if s.isShow(item) {
+		c.Directories[len(c.Directories)-1].UserRating = rating
+		c.Directories[len(c.Directories)-1].LastRatedAt = item.RatedAt
+		return
+	}
+	c.Videos[len(c.Videos)-1].UserRating = rating
+	c.Videos[len(c.Videos)-1].LastRatedAt = item.RatedAt
+}
+
+// isShow reports whether an item renders as a show Directory
+func (s *Server) isShow(item *Item) bool {
+	if item.Type != "" {
+		return item.Type == "show"
+	}
+	sec := s.section(item.SectionKey)
+	return sec != nil && sec.Type == "show"
+}
+
+// handleSectionEdit applies summary edits to collections and label edits to items
+func (s *Server) handleSectionEdit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	id := q.Get("id")
+
+	if q.Get("type") == "18" {
+		col, ok := s.collections[id]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if q.Has("summary.value") {
+			col.Summary = q.Get("summary.value")
+		}
+		if add := q.Get("label[0].tag.tag"); add != "" && !containsFold(col.Labels, add) {
+			col.Labels = append(col.Labels, add)
+		}
+		return
+	}
+
+	item, ok := s.items[id]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if add := q.Get("label[0].tag.tag"); add != "" && !hasLabel(item, add) {
+		item.Labels = append(item.Labels, add)
+		if _, ok := s.labelKeys[strings.ToLower(add)]; !ok {
+			s.labelKeys[strings.ToLower(add)] = s.newKey()
+		}
+	}
+	if remove := q.Get("label[].tag.tag-"); remove != "" {
+		var kept []string
+		for _, l := range item.Labels {
+			if !strings.EqualFold(l, remove) {
+				kept = append(kept, l)
+			}
+		}
+		item.Labels = kept
+	}
+}
+
+func (s *Server) handleSectionCollections(w http.ResponseWriter, r *http.Request) {
+	sectionKey := r.PathValue("section")
+
+	var cols []*Collection
+	for _, col := range s.collections {
+		if col.SectionKey == sectionKey {
+			cols = append(cols, col)
+		}
+	}
+	sort.Slice(cols, func(i, j int) bool { return cols[i].RatingKey < cols[j].RatingKey })
+
+	var c xmlContainer
+	for _, col := range cols {
+		smart := "0"
+		if col.Smart {
+			smart = "1"
+		}
+		c.Directories = append(c.Directories, xmlDirectory{
+			RatingKey: col.RatingKey, Title: col.Title, Type: "collection", Smart: smart,
+			ChildCount: len(s.collectionItems(col)), Summary: col.Summary,
+		})
+	}
+	writeXML(w, c)
+}
+
+func (s *Server) handleSectionLabels(w http.ResponseWriter, r *http.Request) {
+	sectionKey := r.PathValue("section")
+
+	seen := make(map[string]bool)
+	var c xmlContainer
+	for _, item := range s.sectionItems(sectionKey) {
+		for _, l := range item.Labels {
+			key := s.labelKeys[strings.ToLower(l)]
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			c.Directories = append(c.Directories, xmlDirectory{Key: key, Title: l})
+		}
+	}
+	writeXML(w, c)
+}
+
+func (s *Server) handleMetadata(w http.ResponseWriter, r *http.Request) {
+	item, ok := s.items[r.PathValue("key")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	var c xmlContainer
+	s.appendItem(&c, item, "")
+	writeXML(w, c)
+}
+
+func (s *Server) handleDeleteMetadata(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if _, ok := s.collections[key]; ok {
+		delete(s.collections, key)
+		return
+	}
+	if _, ok := s.items[key]; ok {
+		delete(s.items, key)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func (s *Server) handlePrefs(w http.ResponseWriter, r *http.Request) {
+	col, ok := s.collections[r.PathValue("key")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if v := r.URL.Query().Get("collectionSort"); v != "" {
+		col.Sort, _ = strconv.Atoi(v)
+	}
+}
+
+func (s *Server) handleCreateCollection(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	sectionKey := q.Get("sectionId")
+	if s.section(sectionKey) == nil || q.Get("title") == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	uri := q.Get("uri")
+	if !strings.HasPrefix(uri, "server://"+s.MachineID+"/") {
+		http.Error(w, "unknown server in uri", http.StatusBadRequest)
+		return
+	}
+
+	col := &Collection{
+		RatingKey:  s.newKey(),
+		SectionKey: sectionKey,
+		Title:      q.Get("title"),
+		Smart:      q.Get("smart") == "1",
+	}
+	if col.Smart {
+		i := strings.Index(uri, "label=")
+		if i < 0 {
+			http.Error(w, "smart collection without label filter", http.StatusBadRequest)
+			return
+		}
+		col.LabelKey = uri[i+len("label="):]
+		if j := strings.IndexByte(col.LabelKey, '&'); j >= 0 {
+			col.LabelKey = col.LabelKey[:j]
+		}
+	} else {
+		for _, key := range metadataKeys(uri) {
+			if _, ok := s.items[key]; !ok {
+				http.Error(w, fmt.Sprintf("unknown item %s", key), http.StatusBadRequest)
+				return
+			}
+			col.Items = append(col.Items, key)
+		}
+	}
+	s.collections[col.RatingKey] = col
+
+	writeXML(w, xmlContainer{Directories: []xmlDirectory{{
+		RatingKey: col.RatingKey, Title: col.Title, Type: "collection", Smart: q.Get("smart"),
+	}}})
+}
+
+func (s *Server) handleCollectionChildren(w http.ResponseWriter, r *http.Request) {
+	col, ok := s.collections[r.PathValue("key")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	var c xmlContainer
+	for _, key := range s.collectionItems(col) {
+		if item, ok := s.items[key]; ok {
+			s.appendItem(&c, item, "")
+		}
+	}
+	writeXML(w, c)
+}
+
+func (s *Server) handleAddCollectionItems(w http.ResponseWriter, r *http.Request) {
+	col, ok := s.collections[r.PathValue("key")]
+	if !ok || col.Smart {
+		http.NotFound(w, r)
+		return
+	}
+	for _, key := range metadataKeys(r.URL.Query().Get("uri")) {
+		if indexOf(col.Items, key) < 0 {
+			col.Items = append(col.Items, key)
+		}
+	}
+}
+
+func (s *Server) handleRemoveCollectionItem(w http.ResponseWriter, r *http.Request) {
+	col, ok := s.collections[r.PathValue("key")]
+	if !ok || col.Smart {
+		http.NotFound(w, r)
+		return
+	}
+	if i := indexOf(col.Items, r.PathValue("item")); i >= 0 {
+		col.Items = append(col.Items[:i], col.Items[i+1:]...)
+	}
+}
+
+func (s *Server) handleMoveCollectionItem(w http.ResponseWriter, r *http.Request) {
+	col, ok := s.collections[r.PathValue("key")]
+	if !ok || col.Smart {
+		http.NotFound(w, r)
+		return
+	}
+	key := r.PathValue("item")
+	i := indexOf(col.Items, key)
+	if i < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	col.Items = append(col.Items[:i], col.Items[i+1:]...)
+
+	pos := 0
+	if after := r.URL.Query().Get("after"); after != "" {
+		j := indexOf(col.Items, after)
+		if j < 0 {
+			http.Error(w, "unknown after item", http.StatusBadRequest)
+			return
+		}
+		pos = j + 1
+	}
+	col.Items = append(col.Items[:pos], append([]string{key}, col.Items[pos:]...)...)
+}
+
+func (s *Server) userPlaylist(r *http.Request) *Playlist {
+	pl, ok := s.playlists[r.PathValue("key")]
+	if !ok || pl.Owner != s.users[r.Header.Get("X-Plex-Token")] {
+		return nil
+	}
+	return pl
+}
+
+func (s *Server) handlePlaylists(w http.ResponseWriter, r *http.Request) {
+	user := s.users[r.Header.Get("X-Plex-Token")]
+
+	var pls []*Playlist
+	for _, pl := range s.playlists {
+		if pl.Owner == user {
+			pls = append(pls, pl)
+		}
+	}
+	sort.Slice(pls, func(i, j int) bool { return pls[i].RatingKey < pls[j].RatingKey })
+
+	var c xmlContainer
+	for _, pl := range pls {
+		c.Playlists = append(c.Playlists, xmlPlaylist{RatingKey: pl.RatingKey, Title: pl.Title, Smart: "0", LeafCount: len(pl.Entries)})
+	}
+	writeXML(w, c)
+}
+
+func (s *Server) handleCreatePlaylist(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("title") == "" || !strings.HasPrefix(q.Get("uri"), "server://"+s.MachineID+"/") {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	pl := &Playlist{
+		RatingKey: s.newKey(),
+		Owner:     s.users[r.Header.Get("X-Plex-Token")],
+		Title:     q.Get("title"),
+	}
+	for _, key := range metadataKeys(q.Get("uri")) {
+		if _, ok := s.items[key]; !ok {
+			http.Error(w, fmt.Sprintf("unknown item %s", key), http.StatusBadRequest)
+			return
+		}
+		pl.Entries = append(pl.Entries, s.playlistEntries(key)...)
+	}
+	s.playlists[pl.RatingKey] = pl
+
+	writeXML(w, xmlContainer{Playlists: []xmlPlaylist{{RatingKey: pl.RatingKey, Title: pl.Title, Smart: "0", LeafCount: len(pl.Entries)}}})
+}
+
+func (s *Server) handleDeletePlaylist(w http.ResponseWriter, r *http.Request) {
+	pl := s.userPlaylist(r)
+	if pl == nil {
+		http.NotFound(w, r)
+		return
+	}
+	delete(s.playlists, pl.RatingKey)
+}
+
+func (s *Server) handlePlaylistItems(w http.ResponseWriter, r *http.Request) {
+	pl := s.userPlaylist(r)
+	if pl == nil {
+		http.NotFound(w, r)
+		return
+	}
+	var c xmlContainer
+	for _, entry := range pl.Entries {
+		if show, ok := s.items[entry.ShowKey]; ok {
+			c.Videos = append(c.Videos, xmlItem{
+				RatingKey:            entry.RatingKey,
+				Title:                show.Title,
+				Type:                 "episode",
+				GrandparentRatingKey: show.RatingKey,
+				PlaylistItemID:       entry.ItemID,
+			})
+		} else if item, ok := s.items[entry.RatingKey]; ok {
+			s.appendItem(&c, item, entry.ItemID)
+		}
+	}
+	writeXML(w, c)
+}
+
+func (s *Server) handleAddPlaylistItems(w http.ResponseWriter, r *http.Request) {
+	pl := s.userPlaylist(r)
+	if pl == nil {
+		http.NotFound(w, r)
+		return
+	}
+	for _, key := range metadataKeys(r.URL.Query().Get("uri")) {
+		pl.Entries = append(pl.Entries, s.playlistEntries(key)...)
+	}
+}
+
+// playlistEntries adds an item to a playlist the way Plex does: a show
+// becomes one entry per episode
+func (s *Server) playlistEntries(key string) []PlaylistEntry {
+	item, ok := s.items[key]
+	if !ok || len(item.episodeKeys) == 0 {
+		return []PlaylistEntry{{ItemID: s.newKey(), RatingKey: key}}
+	}
+	var entries []PlaylistEntry
+	for _, episode := range item.episodeKeys {
+		entries = append(entries, PlaylistEntry{ItemID: s.newKey(), RatingKey: episode, ShowKey: key})
+	}
+	return entries
+}
+
+func (s *Server) handleRemovePlaylistItem(w http.ResponseWriter, r *http.Request) {
+	pl := s.userPlaylist(r)
+	if pl == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if i := entryIndex(pl.Entries, r.PathValue("item")); i >= 0 {
+		pl.Entries = append(pl.Entries[:i], pl.Entries[i+1:]...)
+	}
+}
+
+func (s *Server) handleMovePlaylistItem(w http.ResponseWriter, r *http.Request) {
+	pl := s.userPlaylist(r)
+	if pl == nil {
+		http.NotFound(w, r)
+		return
+	}
+	i := entryIndex(pl.Entries, r.PathValue("item"))
+	if i < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	entry := pl.Entries[i]
+	pl.Entries = append(pl.Entries[:i], pl.Entries[i+1:]...)
+
+	pos := 0
+	if after := r.URL.Query().Get("after"); after != "" {
+		j := entryIndex(pl.Entries, after)
+		if j < 0 {
+			http.Error(w, "unknown after item", http.StatusBadRequest)
+			return
+		}
+		pos = j + 1
+	}
+	pl.Entries = append(pl.Entries[:pos], append([]PlaylistEntry{entry}, pl.Entries[pos:]...)...)
+}
+
+func entryIndex(entries []PlaylistEntry, itemID string) int {
+	for i, e := range entries {
+		if e.ItemID == itemID {
+			return i
+		}
+	}
+	return -1
+}
+
+func xmlBool(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func (s *Server) handleGetHub(w http.ResponseWriter, r *http.Request) {
+	var c xmlContainer
+	if hub, ok := s.hubs[r.URL.Query().Get("metadataItemId")]; ok && hub.SectionKey == r.PathValue("section") {
+		col := s.collections[hub.CollectionKey]
+		title := ""
+		if col != nil {
+			title = col.Title
+		}
+		c.Hubs = append(c.Hubs, xmlHub{
+			Identifier:            hub.Identifier,
+			Title:                 title,
+			PromotedToRecommended: xmlBool(hub.Recommended),
+			PromotedToOwnHome:     xmlBool(hub.Home),
+			PromotedToSharedHome:  xmlBool(hub.Shared),
+		})
+	}
+	writeXML(w, c)
+}
+
+func (s *Server) handleCreateHub(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	sectionKey := r.PathValue("section")
+	col, ok := s.collections[q.Get("metadataItemId")]
+	if !ok || col.SectionKey != sectionKey {
+		http.NotFound(w, r)
+		return
+	}
+	if _, exists := s.hubs[col.RatingKey]; exists {
+		http.Error(w, "hub already managed", http.StatusBadRequest)
+		return
+	}
+	s.hubs[col.RatingKey] = &Hub{
+		Identifier:    fmt.Sprintf("custom.collection.%s.%s", sectionKey, col.RatingKey),
+		SectionKey:    sectionKey,
+		CollectionKey: col.RatingKey,
+		Recommended:   q.Get("promotedToRecommended") == "1",
+		Home:          q.Get("promotedToOwnHome") == "1",
+		Shared:        q.Get("promotedToSharedHome") == "1",
+	}
+}
+
+func (s *Server) handleUpdateHub(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	for _, hub := range s.hubs {
+		if hub.Identifier == r.PathValue("identifier") && hub.SectionKey == r.PathValue("section") {
+			hub.Recommended = q.Get("promotedToRecommended") == "1"
+			hub.Home = q.Get("promotedToOwnHome") == "1"
+			hub.Shared = q.Get("promotedToSharedHome") == "1"
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+func indexOf(values []string, v string) int {
+	for i, x := range values {
+		if x == v {
+			return i
+		}
+	}
+	return -1
+}
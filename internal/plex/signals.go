@@ -0,0 +1,143 @@
+package plex
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// DefaultDiscoverURL is the Plex Discover service that holds account watchlists
+const DefaultDiscoverURL = "https://discover.provider.plex.tv"
+
+// WatchlistItem is a title on a Plex account's Discover watchlist. TMDbID is
+// zero when Discover exposes no TMDb GUID for the title.
+type WatchlistItem struct {
+	GUID    string // plex://movie/... identifier
+	Title   string
+	Year    int
+	Type    string // movie or tv
+	TMDbID  int
+	IMDbID  string
+	AddedAt int64 // unix seconds
+}
+
+// SetDiscoverURL overrides the Discover service base URL
+func (c *Client) SetDiscoverURL(discoverURL string) {
+	c.discoverURL = discoverURL
+}
+
+// AccountName returns the name of the Plex account the server is signed in
+// to, which is how Tautulli and Plex webhooks name the server owner
+func (c *Client) AccountName() (string, error) {
+	var account struct {
+		Username string `xml:"username,attr"`
+	}
+	if err := c.request("GET", "/myplex/account", nil, &account); err != nil {
+		return "", err
+	}
+	if account.Username == "" {
+		return "", fmt.Errorf("plex server is not signed in to a Plex account")
+	}
+	return account.Username, nil
+}
+
+// GetUserRatings returns every movie and show in the selected sections that
+// the client token's user has rated. cachedTMDbIDs spares metadata calls for
+// shows, as in GetInventory.
+func (c *Client) GetUserRatings(cachedTMDbIDs map[string]int) ([]MediaItem, error) {
+	sections, err := c.GetLibrarySections()
+	if err != nil {
+		return nil, err
+	}
+
+	var rated []MediaItem
+	for _, section := range sections {
+		if section.Type != "movie" && section.Type != "show" {
+			continue
+		}
+		items, err := c.getLibrarySectionContents(section, cachedTMDbIDs, "userRating>=1")
+		if err != nil {
+			return nil, fmt.Errorf("section %s: %w", section.Title, err)
+		}
+		for _, item := range items {
+			if item.UserRating > 0 {
+				rated = append(rated, item)
+			}
+		}
+	}
+
+	log.Info().Int("count", len(rated)).Msg("fetched Plex user ratings")
+	return rated, nil
+}
+
+// GetWatchlist pages through the Discover watchlist of the client token's account
+func (c *Client) GetWatchlist() ([]WatchlistItem, error) {
+	base := c.discoverURL
+	if base == "" {
+		base = DefaultDiscoverURL
+	}
+
+	var items []WatchlistItem
+	start := 0
+	for {
+		req, err := http.NewRequest("GET", base+"/library/sections/watchlist/all?includeGuids=1", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Plex-Token", c.token)
+		req.Header.Set("Accept", "application/xml")
+		req.Header.Set("X-Plex-Container-Start", strconv.Itoa(start))
+		req.Header.Set("X-Plex-Container-Size", strconv.Itoa(c.pageSize))
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch watchlist: %w", err)
+		}
+
+		var container struct {
+			TotalSize int         `xml:"totalSize,attr"`
+			Video     []Video     `xml:"Video"`
+			Directory []Directory `xml:"Directory"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("plex discover returned status %d", resp.StatusCode)
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&container)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+
+		for _, v := range container.Video {
+			items = append(items, watchlistItem("movie", v.Title, v.Year, v.AddedAt, v.GUIDAttr, v.GUID))
+		}
+		for _, d := range container.Directory {
+			items = append(items, watchlistItem("tv", d.Title, d.Year, d.AddedAt, d.GUIDAttr, d.GUID))
+		}
+
+		size := len(container.Video) + len(container.Directory)
+		start += size
+		if size == 0 || start >= container.TotalSize {
+			break
+		}
+	}
+
+	log.Info().Int("count", len(items)).Msg("fetched Plex watchlist")
+	return items, nil
+}
+
+func watchlistItem(mediaType, title string, year int, addedAt int64, guidAttr string, guids []GUID) WatchlistItem {
+	var ids MediaItem
+	applyGUIDs(&ids, guidAttr, guids)
+	return WatchlistItem{
+		GUID:    guidAttr,
+		Title:   title,
+		Year:    year,
+		Type:    mediaType,
+		TMDbID:  ids.TMDbID,
+		IMDbID:  ids.IMDbID,
+		AddedAt: addedAt,
+	}
+}
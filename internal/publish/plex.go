@@ -0,0 +1,568 @@
+package publish
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dppeppel/scryarr/internal/config"
+	"github.com/dppeppel/scryarr/internal/plex"
+	"github.com/dppeppel/scryarr/internal/resolve"
+	"github.com/dppeppel/scryarr/internal/store"
+)
+
+// Plex publish modes
+const (
+	PlexModeCollection = "collection"  // regular collection with explicit membership
+	PlexModeSmartLabel = "smart_label" // items are labelled; a smart collection filters on the label
+)
+
+// DefaultCollectionPrefix matches the naming used for PMM collections so the
+// two targets sort together in Plex
+const DefaultCollectionPrefix = "!01_Recommended — "
+
+// PlexPublisher maintains one Plex collection per category and library
+// section for recommended titles that are already in the library, optionally
+// promoting it to Home/Recommended hubs and mirroring it into per-user
+// playlists. Every publish is a sync-replace: items no longer recommended are
+// removed and a collection or playlist left empty is deleted.
+type PlexPublisher struct {
+	servers   map[string]*plex.Client // keyed by configured server name
+	inventory store.InventoryRepository
+	settings  config.PlexPublishSettings
+
+	userGroups map[string][]string // resolves category groups to usernames
+}
+
+// SetUserGroups sets the user groups categories may target
+func (p *PlexPublisher) SetUserGroups(groups map[string][]string) {
+	p.userGroups = groups
+}
+
+// PlexPublishResult summarizes the collection changes made for a category
+type PlexPublishResult struct {
+	Collections int // collections created or updated
+	Items       int // items placed in collections
+	Playlists   int // user playlists created or updated
+	Deleted     int // collections and playlists removed because they had no picks left
+}
+
+// NewPlexPublisher creates a Plex publisher, filling unset settings with defaults
+func NewPlexPublisher(servers map[string]*plex.Client, inventory store.InventoryRepository, settings config.PlexPublishSettings) *PlexPublisher {
+	if settings.Mode == "" {
+		settings.Mode = PlexModeCollection
+	}
+	if settings.CollectionPrefix == "" {
+		settings.CollectionPrefix = DefaultCollectionPrefix
+	}
+	if settings.Summary == "" {
+		settings.Summary = DefaultSummary
+	}
+	if settings.Sort == "" {
+		settings.Sort = "rank"
+	}
+	return &PlexPublisher{
+		servers:   servers,
+		inventory: inventory,
+		settings:  settings,
+	}
+}
+
+// CollectionTitle returns the Plex collection title used for a category
+func (p *PlexPublisher) CollectionTitle(categoryLabel string) string {
+	return p.settings.CollectionPrefix + categoryLabel
+}
+
+// LabelName returns the item label used for a category in smart_label mode
+func (p *PlexPublisher) LabelName(categoryLabel string) string {
+	return "scryarr-" + sanitizeFilename(categoryLabel)
+}
+
+// Publish syncs the category's collections (and playlists, when enabled) on
+// every configured server with the in-library picks from resolved, keeping
+// the LLM's ranking
+func (p *PlexPublisher) Publish(category *config.Category, resolved *resolve.ResolvedOutput) (*PlexPublishResult, error) {
+	log.Info().Str("category", category.Label).Str("mode", p.settings.Mode).Msg("publishing Plex collections")
+
+	picks, err := p.rankedInventory(category, resolved)
+	if err != nil {
+		return nil, err
+	}
+
+	// server -> section key -> rating keys in rank order
+	desired := make(map[string]map[string][]string)
+	for _, item := range picks {
+		if _, ok := p.servers[item.Server]; !ok {
+			log.Warn().Str("server", item.Server).Msg("inventory references unconfigured Plex server, skipping")
+			continue
+		}
+		if desired[item.Server] == nil {
+			desired[item.Server] = make(map[string][]string)
+		}
+		desired[item.Server][item.SectionKey] = append(desired[item.Server][item.SectionKey], item.RatingKey)
+	}
+
+	result := &PlexPublishResult{}
+	title := p.CollectionTitle(category.Label)
+	visibility := p.visibility(category)
+	audienceLabel := AudienceLabel(category)
+	var failed int
+
+	for server, client := range p.servers {
+		sections, err := client.GetLibrarySections()
+		if err != nil {
+			log.Warn().Err(err).Str("server", server).Msg("failed to list library sections")
+			failed++
+			continue
+		}
+
+		for _, section := range sections {
+			if section.Type != "movie" && section.Type != "show" {
+				continue
+			}
+			keys := desired[server][section.Key]
+
+			var col *plex.Collection
+			var syncErr error
+			if p.settings.Mode == PlexModeSmartLabel {
+				col, syncErr = p.syncLabelCollection(client, section, title, p.LabelName(category.Label), keys, result)
+			} else {
+				col, syncErr = p.syncCollection(client, section, title, keys, result)
+			}
+			if syncErr == nil && col != nil && audienceLabel != "" {
+				syncErr = client.AddLabel(section.Key, plex.TypeCollection, col.RatingKey, audienceLabel)
+			}
+			if syncErr == nil && col != nil && visibility != nil {
+				syncErr = client.SetCollectionVisibility(section.Key, col.RatingKey, *visibility)
+			}
+			if syncErr != nil {
+				log.Warn().Err(syncErr).Str("server", server).Str("section", section.Title).Msg("failed to sync collection")
+				failed++
+			}
+		}
+
+		if p.playlistsEnabled(category) {
+			failed += p.syncUserPlaylists(server, client, category, title, picks, result)
+		}
+	}
+
+	log.Info().
+		Str("category", category.Label).
+		Int("collections", result.Collections).
+		Int("items", result.Items).
+		Int("playlists", result.Playlists).
+		Int("deleted", result.Deleted).
+		Msg("Plex publish complete")
+
+	if failed > 0 {
+		return result, fmt.Errorf("%d Plex collection/playlist sync(s) failed", failed)
+	}
+	return result, nil
+}
+
+// rankedInventory maps the in-library picks to inventory rows in rank order,
+// dropping duplicates
+func (p *PlexPublisher) rankedInventory(category *config.Category, resolved *resolve.ResolvedOutput) ([]store.InventoryItem, error) {
+	scope := resolve.InventoryScope(category)
+	seen := make(map[string]bool)
+	var picks []store.InventoryItem
+
+	for _, item := range resolved.InLibrary() {
+		matches, err := p.inventory.FindPlexInventory(item.TMDbID, item.Medium, scope)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up inventory for %s: %w", item.Title, err)
+		}
+		for _, m := range matches {
+			key := m.Server + "/" + m.RatingKey
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			picks = append(picks, m)
+		}
+	}
+	return picks, nil
+}
+
+// visibility returns the hub promotion for a category, or nil to leave it alone
+func (p *PlexPublisher) visibility(category *config.Category) *plex.HubVisibility {
+	v := p.settings.Visibility
+	if category.Plex != nil && category.Plex.Visibility != nil {
+		v = category.Plex.Visibility
+	}
+	if v == nil {
+		return nil
+	}
+	return &plex.HubVisibility{Recommended: v.Recommended, Home: v.Home, Shared: v.Shared}
+}
+
+func (p *PlexPublisher) playlistsEnabled(category *config.Category) bool {
+	if category.Plex != nil && category.Plex.Playlists != nil {
+		return *category.Plex.Playlists
+	}
+	return p.settings.Playlists.Enabled
+}
+
+// syncCollection makes a regular collection hold exactly keys, in order
+func (p *PlexPublisher) syncCollection(client *plex.Client, section plex.LibrarySection, title string, keys []string, result *PlexPublishResult) (*plex.Collection, error) {
+	existing, err := client.FindCollection(section.Key, title)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		if existing == nil {
+			return nil, nil
+		}
+		if err := client.DeleteCollection(existing.RatingKey); err != nil {
+			return nil, err
+		}
+		result.Deleted++
+		return nil, nil
+	}
+
+	var current []string
+	if existing == nil {
+		existing, err = client.CreateCollection(section.Key, title, plex.TypeForMedia(section.Type), keys)
+		if err != nil {
+			return nil, err
+		}
+		current = keys
+		log.Info().Str("section", section.Title).Str("collection", title).Int("items", len(keys)).Msg("created Plex collection")
+	} else {
+		if current, err = client.GetCollectionItems(existing.RatingKey); err != nil {
+			return nil, err
+		}
+
+		want := toSet(keys)
+		var kept []string
+		for _, key := range current {
+			if want[key] {
+				kept = append(kept, key)
+				continue
+			}
+			if err := client.RemoveCollectionItem(existing.RatingKey, key); err != nil {
+				return nil, err
+			}
+		}
+
+		have := toSet(kept)
+		var missing []string
+		for _, key := range keys {
+			if !have[key] {
+				missing = append(missing, key)
+			}
+		}
+		if len(missing) > 0 {
+			if err := client.AddCollectionItems(existing.RatingKey, missing); err != nil {
+				return nil, err
+			}
+		}
+		current = append(kept, missing...)
+	}
+
+	if err := client.SetCollectionSummary(section.Key, existing.RatingKey, p.settings.Summary); err != nil {
+		return nil, err
+	}
+	if err := p.applySort(client, existing.RatingKey, current, keys); err != nil {
+		return nil, err
+	}
+
+	result.Collections++
+	result.Items += len(keys)
+	return existing, nil
+}
+
+// applySort sets the collection sort mode and, for rank order, moves items
+// into position when the current order differs
+func (p *PlexPublisher) applySort(client *plex.Client, collectionKey string, current, keys []string) error {
+	switch p.settings.Sort {
+	case "title":
+		return client.SetCollectionSort(collectionKey, plex.CollectionSortTitle)
+	case "release":
+		return client.SetCollectionSort(collectionKey, plex.CollectionSortRelease)
+	}
+
+	if err := client.SetCollectionSort(collectionKey, plex.CollectionSortCustom); err != nil {
+		return err
+	}
+	if equalOrder(current, keys) {
+		return nil
+	}
+	for i, key := range keys {
+		after := ""
+		if i > 0 {
+			after = keys[i-1]
+		}
+		if err := client.MoveCollectionItem(collectionKey, key, after); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncLabelCollection labels exactly keys in the section and ensures a smart
+// collection filtering on that label exists. Smart collections cannot use a
+// custom order, so rank sorting falls back to title.
+func (p *PlexPublisher) syncLabelCollection(client *plex.Client, section plex.LibrarySection, title, label string, keys []string, result *PlexPublishResult) (*plex.Collection, error) {
+	itemType := plex.TypeForMedia(section.Type)
+
+	labelTag, err := client.FindLabel(section.Key, label)
+	if err != nil {
+		return nil, err
+	}
+
+	var current []string
+	if labelTag != nil {
+		if current, err = client.GetLabeledItems(section.Key, itemType, labelTag.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	want := toSet(keys)
+	for _, key := range current {
+		if !want[key] {
+			if err := client.RemoveLabel(section.Key, itemType, key, label); err != nil {
+				return nil, err
+			}
+		}
+	}
+	have := toSet(current)
+	for _, key := range keys {
+		if !have[key] {
+			if err := client.AddLabel(section.Key, itemType, key, label); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	existing, err := client.FindCollection(section.Key, title)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		if existing == nil {
+			return nil, nil
+		}
+		if err := client.DeleteCollection(existing.RatingKey); err != nil {
+			return nil, err
+		}
+		result.Deleted++
+		return nil, nil
+	}
+
+	if existing == nil {
+		// The label tag only exists once an item carries it
+		if labelTag == nil {
+			if labelTag, err = client.FindLabel(section.Key, label); err != nil {
+				return nil, err
+			}
+			if labelTag == nil {
+				return nil, fmt.Errorf("label %q not found after tagging items", label)
+			}
+		}
+		if existing, err = client.CreateSmartCollection(section.Key, title, itemType, labelTag.Key); err != nil {
+			return nil, err
+		}
+		log.Info().Str("section", section.Title).Str("collection", title).Str("label", label).Msg("created Plex smart collection")
+	}
+
+	if err := client.SetCollectionSummary(section.Key, existing.RatingKey, p.settings.Summary); err != nil {
+		return nil, err
+	}
+	mode := plex.CollectionSortTitle
+	if p.settings.Sort == "release" {
+		mode = plex.CollectionSortRelease
+	}
+	if err := client.SetCollectionSort(existing.RatingKey, mode); err != nil {
+		return nil, err
+	}
+
+	result.Collections++
+	result.Items += len(keys)
+	return existing, nil
+}
+
+// syncUserPlaylists mirrors a server's picks into a playlist for each
+// configured user and returns the number of playlists that failed to sync
+func (p *PlexPublisher) syncUserPlaylists(server string, client *plex.Client, category *config.Category, title string, picks []store.InventoryItem, result *PlexPublishResult) int {
+	mediaTypes := p.settings.Playlists.MediaTypes
+	if len(mediaTypes) == 0 {
+		mediaTypes = []string{"movie"}
+	}
+
+	var keys []string
+	for _, item := range picks {
+		if item.Server == server && containsString(mediaTypes, item.MediaType) {
+			keys = append(keys, item.RatingKey)
+		}
+	}
+
+	users := p.settings.Playlists.Users
+	if len(users) == 0 {
+		users = []config.PlexUser{{Name: "owner"}}
+	}
+
+	// Categories for a user or group only reach that audience's playlists
+	audience, err := category.AudienceUsers(p.userGroups)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to resolve category audience")
+		return 1
+	}
+
+	failed := 0
+	for _, user := range users {
+		if len(user.Servers) > 0 && !containsString(user.Servers, server) {
+			continue
+		}
+		if len(audience) > 0 && !containsFold(audience, user.Name) {
+			continue
+		}
+
+		userClient := client
+		if user.TokenEnv != "" {
+			if user.Token == "" {
+				log.Warn().Str("user", user.Name).Str("token_env", user.TokenEnv).Msg("no token for playlist user, skipping")
+				failed++
+				continue
+			}
+			userClient = client.WithToken(user.Token)
+		}
+
+		if err := p.syncPlaylist(userClient, title, keys, result); err != nil {
+			log.Warn().Err(err).Str("server", server).Str("user", user.Name).Msg("failed to sync playlist")
+			failed++
+		}
+	}
+	return failed
+}
+
+// syncPlaylist makes the client user's playlist hold exactly keys, in order
+func (p *PlexPublisher) syncPlaylist(client *plex.Client, title string, keys []string, result *PlexPublishResult) error {
+	existing, err := client.FindPlaylist(title)
+	if err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		if existing == nil {
+			return nil
+		}
+		if err := client.DeletePlaylist(existing.RatingKey); err != nil {
+			return err
+		}
+		result.Deleted++
+		return nil
+	}
+
+	if existing == nil {
+		if _, err := client.CreatePlaylist(title, keys); err != nil {
+			return err
+		}
+		result.Playlists++
+		return nil
+	}
+
+	items, err := client.GetPlaylistItems(existing.RatingKey)
+	if err != nil {
+		return err
+	}
+
+	// Drop entries no longer wanted (and duplicates), then append the rest.
+	// A show is listed as its episodes, so entries are matched on the key
+	// they were added under.
+	want := toSet(keys)
+	have := make(map[string]bool)
+	seen := make(map[string]bool)
+	var kept []plex.PlaylistItem
+	for _, item := range items {
+		if !want[item.AddedKey()] || seen[item.RatingKey] {
+			if err := client.RemovePlaylistItem(existing.RatingKey, item.PlaylistItemID); err != nil {
+				return err
+			}
+			continue
+		}
+		seen[item.RatingKey] = true
+		have[item.AddedKey()] = true
+		kept = append(kept, item)
+	}
+
+	var missing []string
+	for _, key := range keys {
+		if !have[key] {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		if err := client.AddPlaylistItems(existing.RatingKey, missing); err != nil {
+			return err
+		}
+		if kept, err = client.GetPlaylistItems(existing.RatingKey); err != nil {
+			return err
+		}
+	}
+
+	// Order entries by pick, keeping a show's episodes together
+	var current []string
+	byKey := make(map[string][]string)
+	for _, item := range kept {
+		current = append(current, item.PlaylistItemID)
+		byKey[item.AddedKey()] = append(byKey[item.AddedKey()], item.PlaylistItemID)
+	}
+	var ordered []string
+	for _, key := range keys {
+		ordered = append(ordered, byKey[key]...)
+	}
+	if !equalOrder(current, ordered) {
+		for i, id := range ordered {
+			after := ""
+			if i > 0 {
+				after = ordered[i-1]
+			}
+			if err := client.MovePlaylistItem(existing.RatingKey, id, after); err != nil {
+				return err
+			}
+		}
+	}
+
+	result.Playlists++
+	return nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func toSet(keys []string) map[string]bool {
+	set := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		set[k] = true
+	}
+	return set
+}
+
+func equalOrder(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
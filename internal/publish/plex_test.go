@@ -0,0 +1,241 @@
+package publish
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dppeppel/scryarr/internal/config"
+	"github.com/dppeppel/scryarr/internal/plex"
+	"github.com/dppeppel/scryarr/internal/plex/plextest"
+	"github.com/dppeppel/scryarr/internal/resolve"
+	"github.com/dppeppel/scryarr/internal/store"
+)
+
+// library is a fake Plex server with one movie section, mirrored into an
+// inventory store
+type library struct {
+	srv  *plextest.Server
+	db   *store.MemoryStore
+	keys map[int]string // TMDb ID -> rating key
+}
+
+func newLibrary(t *testing.T) *library {
+	t.Helper()
+	lib := &library{srv: plextest.NewServer(), db: store.NewMemoryStore(), keys: make(map[int]string)}
+	t.Cleanup(lib.srv.Close)
+
+	lib.srv.AddSection(plextest.Section{Key: "1", Title: "Movies", Type: "movie"})
+	var items []store.InventoryItem
+	for _, m := range []struct {
+		title  string
+		year   int
+		tmdbID int
+	}{{"Alien", 1979, 348}, {"Contact", 1997, 686}, {"Moon", 2009, 17431}} {
+		key := lib.srv.AddItem(plextest.Item{SectionKey: "1", Title: m.title, Year: m.year, GUIDs: []string{fmt.Sprintf("tmdb://%d", m.tmdbID)}})
+		lib.keys[m.tmdbID] = key
+		items = append(items, store.InventoryItem{Server: "home", SectionKey: "1", SectionTitle: "Movies",
+			RatingKey: key, TMDbID: m.tmdbID, MediaType: "movie", Title: m.title, Year: m.year})
+	}
+	if err := lib.db.ReplacePlexInventory("home", items); err != nil {
+		t.Fatal(err)
+	}
+	return lib
+}
+
+func (lib *library) publisher(mode string) *PlexPublisher {
+	client := plex.NewClient(lib.srv.URL, lib.srv.Token, 50)
+	return NewPlexPublisher(map[string]*plex.Client{"home": client}, lib.db, config.PlexPublishSettings{Mode: mode})
+}
+
+// ratingKeys maps TMDb IDs to the library's rating keys
+func (lib *library) ratingKeys(tmdbIDs ...int) []string {
+	var keys []string
+	for _, id := range tmdbIDs {
+		keys = append(keys, lib.keys[id])
+	}
+	return keys
+}
+
+// picks builds a resolved output of in-library picks in rank order
+func picks(tmdbIDs ...int) *resolve.ResolvedOutput {
+	out := &resolve.ResolvedOutput{Category: "Space"}
+	for _, id := range tmdbIDs {
+		out.Items = append(out.Items, resolve.ResolvedItem{TMDbID: id, Medium: "movie", InLibrary: true})
+	}
+	// Picks to acquire never reach Plex
+	out.Items = append(out.Items, resolve.ResolvedItem{TMDbID: 329865, Medium: "movie"})
+	return out
+}
+
+func TestPlexPublishCollectionSyncReplace(t *testing.T) {
+	lib := newLibrary(t)
+	p := lib.publisher(PlexModeCollection)
+	category := &config.Category{Label: "Space"}
+	title := p.CollectionTitle(category.Label)
+
+	result, err := p.Publish(category, picks(686, 348))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Collections != 1 || result.Items != 2 {
+		t.Errorf("result = %+v", result)
+	}
+	col, ok := lib.srv.Collections("1")[title]
+	if !ok || col.Smart {
+		t.Fatalf("collections = %+v", lib.srv.Collections("1"))
+	}
+	if fmt.Sprint(col.Items) != fmt.Sprint(lib.ratingKeys(686, 348)) {
+		t.Errorf("items = %v, want Contact, Alien", col.Items)
+	}
+
+	// A later run drops Alien, adds Moon and keeps the new ranking
+	if _, err := p.Publish(category, picks(17431, 686)); err != nil {
+		t.Fatal(err)
+	}
+	col = lib.srv.Collections("1")[title]
+	if fmt.Sprint(col.Items) != fmt.Sprint(lib.ratingKeys(17431, 686)) {
+		t.Errorf("items = %v, want Moon, Contact", col.Items)
+	}
+	if col.Summary != DefaultSummary {
+		t.Errorf("summary = %q", col.Summary)
+	}
+
+	// No picks left deletes the collection
+	result, err = p.Publish(category, picks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Deleted != 1 {
+		t.Errorf("deleted = %d, want 1", result.Deleted)
+	}
+	if _, ok := lib.srv.Collections("1")[title]; ok {
+		t.Errorf("empty collection was not deleted")
+	}
+}
+
+func TestPlexPublishSmartLabelSyncReplace(t *testing.T) {
+	lib := newLibrary(t)
+	p := lib.publisher(PlexModeSmartLabel)
+	category := &config.Category{Label: "Space"}
+	title := p.CollectionTitle(category.Label)
+	label := p.LabelName(category.Label)
+
+	if _, err := p.Publish(category, picks(686, 348)); err != nil {
+		t.Fatal(err)
+	}
+	col, ok := lib.srv.Collections("1")[title]
+	if !ok || !col.Smart {
+		t.Fatalf("collections = %+v", lib.srv.Collections("1"))
+	}
+	if len(col.Items) != 2 {
+		t.Errorf("smart collection items = %v, want Contact and Alien", col.Items)
+	}
+	for _, id := range []int{686, 348} {
+		if fmt.Sprint(lib.srv.Labels(lib.keys[id])) != fmt.Sprint([]string{label}) {
+			t.Errorf("labels of %d = %v", id, lib.srv.Labels(lib.keys[id]))
+		}
+	}
+
+	// Labels follow the picks; the smart collection follows the labels
+	if _, err := p.Publish(category, picks(17431)); err != nil {
+		t.Fatal(err)
+	}
+	if labels := lib.srv.Labels(lib.keys[686]); len(labels) != 0 {
+		t.Errorf("Contact still labelled %v", labels)
+	}
+	col = lib.srv.Collections("1")[title]
+	if fmt.Sprint(col.Items) != fmt.Sprint(lib.ratingKeys(17431)) {
+		t.Errorf("items = %v, want Moon", col.Items)
+	}
+
+	result, err := p.Publish(category, picks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Deleted != 1 {
+		t.Errorf("deleted = %d, want 1", result.Deleted)
+	}
+	if _, ok := lib.srv.Collections("1")[title]; ok {
+		t.Errorf("empty smart collection was not deleted")
+	}
+	if labels := lib.srv.Labels(lib.keys[17431]); len(labels) != 0 {
+		t.Errorf("Moon still labelled %v", labels)
+	}
+}
+
+func TestPlexPublishPlaylistExpandsShowPicks(t *testing.T) {
+	lib := newLibrary(t)
+	lib.srv.AddSection(plextest.Section{Key: "2", Title: "TV Shows", Type: "show"})
+	show := lib.srv.AddItem(plextest.Item{SectionKey: "2", Title: "The Expanse", Year: 2015, GUIDs: []string{"tmdb://63639"}, Episodes: 3})
+	err := lib.db.UpsertPlexInventory([]store.InventoryItem{{Server: "home", SectionKey: "2", SectionTitle: "TV Shows",
+		RatingKey: show, TMDbID: 63639, MediaType: "tv", Title: "The Expanse", Year: 2015}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := plex.NewClient(lib.srv.URL, lib.srv.Token, 50)
+	p := NewPlexPublisher(map[string]*plex.Client{"home": client}, lib.db, config.PlexPublishSettings{
+		Playlists: config.PlexPlaylistSettings{Enabled: true, MediaTypes: []string{"movie", "tv"}},
+	})
+	category := &config.Category{Label: "Space"}
+	title := p.CollectionTitle(category.Label)
+
+	// ranked builds in-library picks in rank order; The Expanse is a tv pick
+	ranked := func(ids ...int) *resolve.ResolvedOutput {
+		out := &resolve.ResolvedOutput{Category: "Space"}
+		for _, id := range ids {
+			medium := "movie"
+			if id == 63639 {
+				medium = "tv"
+			}
+			out.Items = append(out.Items, resolve.ResolvedItem{TMDbID: id, Medium: medium, InLibrary: true})
+		}
+		return out
+	}
+	// entries lists the playlist as added keys: a show once per episode
+	entries := func() []string {
+		var keys []string
+		for _, e := range lib.srv.Playlists(plextest.OwnerName)[title].Entries {
+			if e.ShowKey != "" {
+				keys = append(keys, e.ShowKey)
+			} else {
+				keys = append(keys, e.RatingKey)
+			}
+		}
+		return keys
+	}
+
+	if _, err := p.Publish(category, ranked(686, 63639)); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{lib.keys[686], show, show, show}
+	if fmt.Sprint(entries()) != fmt.Sprint(want) {
+		t.Errorf("entries = %v, want Contact then three episodes", entries())
+	}
+
+	// Re-ranking moves the episodes together and keeps them in place
+	if _, err := p.Publish(category, ranked(63639, 348, 686)); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{show, show, show, lib.keys[348], lib.keys[686]}
+	if fmt.Sprint(entries()) != fmt.Sprint(want) {
+		t.Errorf("entries = %v, want three episodes, Alien, Contact", entries())
+	}
+
+	// An unchanged ranking leaves the playlist alone
+	before := lib.srv.Playlists(plextest.OwnerName)[title].Entries
+	if _, err := p.Publish(category, ranked(63639, 348, 686)); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(lib.srv.Playlists(plextest.OwnerName)[title].Entries) != fmt.Sprint(before) {
+		t.Errorf("entries = %v, want unchanged %v", lib.srv.Playlists(plextest.OwnerName)[title].Entries, before)
+	}
+
+	// Dropping the show removes all of its episodes
+	if _, err := p.Publish(category, ranked(348)); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(entries()) != fmt.Sprint([]string{lib.keys[348]}) {
+		t.Errorf("entries = %v, want Alien", entries())
+	}
+}
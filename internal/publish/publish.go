@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/dppeppel/scryarr/internal/config"
 	"github.com/dppeppel/scryarr/internal/llm"
 	"github.com/dppeppel/scryarr/internal/logging"
 	"github.com/dppeppel/scryarr/internal/resolve"
@@ -20,10 +21,29 @@ func init() {
 	log = logging.GetLogger("publish")
 }
 
+// AudienceLabelPrefix starts the label put on collections made for one user
+// or group. Excluding the other audiences' labels in each Plex share's
+// restrictions keeps such collections private.
+const AudienceLabelPrefix = "scryarr-for-"
+
+// AudienceLabel returns the collection label for a category's user or group,
+// or "" for categories meant for everyone
+func AudienceLabel(category *config.Category) string {
+	name := category.AudienceName()
+	if name == "" {
+		return ""
+	}
+	return AudienceLabelPrefix + sanitizeFilename(name)
+}
+
+// DefaultSummary is the collection summary used when none is configured
+const DefaultSummary = "AI-curated picks based on Plex history & category prefs."
+
 // Publisher handles output of JSON files and PMM YAML files
 type Publisher struct {
 	jsonOutDir string
 	pmmOutDir  string
+	pmmEnabled bool
 }
 
 // NewPublisher creates a new publisher
@@ -31,19 +51,33 @@ func NewPublisher(jsonOutDir, pmmOutDir string) *Publisher {
 	return &Publisher{
 		jsonOutDir: jsonOutDir,
 		pmmOutDir:  pmmOutDir,
+		pmmEnabled: true,
 	}
 }
 
+// SetPMMEnabled turns PMM YAML generation on or off (JSON is always written)
+func (p *Publisher) SetPMMEnabled(enabled bool) {
+	p.pmmEnabled = enabled
+}
+
 // PublishResult contains the paths to published files
 type PublishResult struct {
 	RawJSONPath      string
 	ResolvedJSONPath string
 	PMMMovieYAMLPath string
 	PMMTVYAMLPath    string
+
+	// Collections of picks already in the library (empty when not written)
+	PMMMovieInLibraryYAMLPath string
+	PMMTVInLibraryYAMLPath    string
 }
 
-// Publish writes both raw LLM output and resolved recommendations, then generates PMM YAMLs
-func (p *Publisher) Publish(categoryLabel string, llmResp *llm.LLMResponse, resolved *resolve.ResolvedOutput) (*PublishResult, error) {
+// Publish writes both raw LLM output and resolved recommendations, then generates PMM YAMLs.
+// Picks to acquire and picks already in the library get separate collections;
+// the in-library ones are skipped when pmmInLibrary is false (e.g. because
+// they are published straight to Plex instead).
+func (p *Publisher) Publish(category *config.Category, llmResp *llm.LLMResponse, resolved *resolve.ResolvedOutput, pmmInLibrary bool) (*PublishResult, error) {
+	categoryLabel := category.Label
 	log.Info().Str("category", categoryLabel).Msg("publishing outputs")
 
 	result := &PublishResult{}
@@ -63,12 +97,23 @@ func (p *Publisher) Publish(categoryLabel string, llmResp *llm.LLMResponse, reso
 	result.ResolvedJSONPath = resolvedPath
 
 	// Generate PMM YAMLs (separate for movies and TV)
-	moviePath, tvPath, err := p.generatePMMYAMLs(categoryLabel, resolved)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate PMM YAMLs: %w", err)
+	if p.pmmEnabled {
+		moviePath, tvPath, err := p.generatePMMYAMLs(categoryLabel, AudienceLabel(category), resolved.ToAcquire(), false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate PMM YAMLs: %w", err)
+		}
+		result.PMMMovieYAMLPath = moviePath
+		result.PMMTVYAMLPath = tvPath
+
+		if pmmInLibrary {
+			moviePath, tvPath, err := p.generatePMMYAMLs(categoryLabel, AudienceLabel(category), resolved.InLibrary(), true)
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate in-library PMM YAMLs: %w", err)
+			}
+			result.PMMMovieInLibraryYAMLPath = moviePath
+			result.PMMTVInLibraryYAMLPath = tvPath
+		}
 	}
-	result.PMMMovieYAMLPath = moviePath
-	result.PMMTVYAMLPath = tvPath
 
 	log.Info().Str("category", categoryLabel).Msg("publish complete")
 
@@ -109,11 +154,11 @@ func (p *Publisher) writeResolvedJSON(categoryLabel string, resolved *resolve.Re
 	return path, nil
 }
 
-func (p *Publisher) generatePMMYAMLs(categoryLabel string, resolved *resolve.ResolvedOutput) (string, string, error) {
+func (p *Publisher) generatePMMYAMLs(categoryLabel, audienceLabel string, items []resolve.ResolvedItem, inLibrary bool) (string, string, error) {
 	var movies []int
 	var tvShows []int
 
-	for _, item := range resolved.Items {
+	for _, item := range items {
 		if item.Medium == "movie" {
 			movies = append(movies, item.TMDbID)
 		} else if item.Medium == "tv" {
@@ -125,7 +170,7 @@ func (p *Publisher) generatePMMYAMLs(categoryLabel string, resolved *resolve.Res
 
 	// Generate movie collection YAML
 	if len(movies) > 0 {
-		path, err := p.writePMMYAML(categoryLabel, "Movies", movies, "tmdb_movie")
+		path, err := p.writePMMYAML(categoryLabel, "Movies", movies, "tmdb_movie", audienceLabel, inLibrary)
 		if err != nil {
 			return "", "", err
 		}
@@ -134,7 +179,7 @@ func (p *Publisher) generatePMMYAMLs(categoryLabel string, resolved *resolve.Res
 
 	// Generate TV collection YAML
 	if len(tvShows) > 0 {
-		path, err := p.writePMMYAML(categoryLabel, "Series", tvShows, "tmdb_show")
+		path, err := p.writePMMYAML(categoryLabel, "Series", tvShows, "tmdb_show", audienceLabel, inLibrary)
 		if err != nil {
 			return "", "", err
 		}
@@ -144,13 +189,21 @@ func (p *Publisher) generatePMMYAMLs(categoryLabel string, resolved *resolve.Res
 	return moviePath, tvPath, nil
 }
 
-func (p *Publisher) writePMMYAML(categoryLabel, mediaType string, ids []int, tmdbKey string) (string, error) {
+func (p *Publisher) writePMMYAML(categoryLabel, mediaType string, ids []int, tmdbKey, audienceLabel string, inLibrary bool) (string, error) {
 	collectionName := fmt.Sprintf("!01_Recommended — %s (%s)", categoryLabel, mediaType)
+	prefix := "recommended"
+	if inLibrary {
+		collectionName = fmt.Sprintf("!01_Recommended — %s (%s in Library)", categoryLabel, mediaType)
+		prefix = "in_library"
+	}
 
 	collection := map[string]interface{}{
 		tmdbKey:     ids,
 		"sync_mode": "replace",
-		"summary":   "AI-curated picks based on Plex history & category prefs.",
+		"summary":   DefaultSummary,
+	}
+	if audienceLabel != "" {
+		collection["label"] = audienceLabel
 	}
 
 	yamlData := map[string]interface{}{
@@ -164,7 +217,8 @@ func (p *Publisher) writePMMYAML(categoryLabel, mediaType string, ids []int, tmd
 		return "", err
 	}
 
-	filename := fmt.Sprintf("recommended__%s__%s.yml",
+	filename := fmt.Sprintf("%s__%s__%s.yml",
+		prefix,
 		strings.ToLower(mediaType),
 		sanitizeFilename(categoryLabel))
 	path := filepath.Join(p.pmmOutDir, filename)
@@ -177,6 +231,21 @@ func (p *Publisher) writePMMYAML(categoryLabel, mediaType string, ids []int, tmd
 	return path, nil
 }
 
+// Retire removes a category's PMM YAML files so PMM stops maintaining its
+// collections. The JSON outputs are kept for the run history.
+func (p *Publisher) Retire(categoryLabel string) error {
+	for _, prefix := range []string{"recommended", "in_library"} {
+		for _, mediaType := range []string{"movies", "series"} {
+			path := filepath.Join(p.pmmOutDir, fmt.Sprintf("%s__%s__%s.yml", prefix, mediaType, sanitizeFilename(categoryLabel)))
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	log.Info().Str("category", categoryLabel).Msg("retired PMM collections")
+	return nil
+}
+
 func sanitizeFilename(s string) string {
 	s = strings.ToLower(s)
 	s = strings.ReplaceAll(s, " ", "_")
@@ -0,0 +1,283 @@
+// Package replay records HTTP exchanges into fixture files and serves them
+// back, so a run against Plex, Tautulli, TMDb and the LLM can be repeated
+// offline. Secrets are scrubbed before anything is written.
+package replay
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/dppeppel/scryarr/internal/logging"
+	"github.com/rs/zerolog"
+)
+
+var log zerolog.Logger
+
+func init() {
+	log = logging.GetLogger("replay")
+}
+
+// Exchange is one recorded request and its response. URLs and bodies are
+// scrubbed; request headers are not kept.
+type Exchange struct {
+	Method       string      `json:"method"`
+	URL          string      `json:"url"`
+	RequestBody  string      `json:"request_body,omitempty"`
+	Status       int         `json:"status"`
+	Header       http.Header `json:"header,omitempty"`
+	Body         string      `json:"body"`
+	BodyEncoding string      `json:"body_encoding,omitempty"` // base64 for non-UTF-8 bodies
+}
+
+// Cassette is the fixture file of a recorded run: its exchanges in the order
+// they happened
+type Cassette struct {
+	Exchanges []Exchange `json:"exchanges"`
+}
+
+// LoadCassette reads a cassette file
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette: %w", err)
+	}
+	return &c, nil
+}
+
+// Save writes the cassette as indented JSON
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Recorder is an http.RoundTripper that passes requests to the underlying
+// transport and records each exchange
+type Recorder struct {
+	transport http.RoundTripper
+	scrub     *Scrubber
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewRecorder creates a recorder around transport. The secrets are redacted
+// wherever they appear in the recording.
+func NewRecorder(transport http.RoundTripper, secrets ...string) *Recorder {
+	return &Recorder{transport: transport, scrub: NewScrubber(secrets...)}
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	exchange := Exchange{
+		Method:      req.Method,
+		URL:         r.scrub.URL(req.URL),
+		RequestBody: r.scrub.String(string(reqBody)),
+		Status:      resp.StatusCode,
+		Header:      r.scrub.Header(resp.Header),
+	}
+	exchange.Header.Del("Content-Length")
+	if utf8.Valid(body) {
+		exchange.Body = r.scrub.String(string(body))
+	} else {
+		exchange.Body = base64.StdEncoding.EncodeToString(body)
+		exchange.BodyEncoding = "base64"
+	}
+
+	r.mu.Lock()
+	r.cassette.Exchanges = append(r.cassette.Exchanges, exchange)
+	r.mu.Unlock()
+	return resp, nil
+}
+
+// Exchanges returns the exchanges recorded so far, in order
+func (r *Recorder) Exchanges() []Exchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Exchange(nil), r.cassette.Exchanges...)
+}
+
+// Save writes the exchanges recorded so far
+func (r *Recorder) Save(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cassette.Save(path)
+}
+
+// Replayer is an http.RoundTripper that answers requests from a cassette
+// without touching the network. Requests rarely repeat exactly (query
+// parameters carry dates, prompts carry the history), so each request gets
+// the closest recorded exchange with the same method and path: unused ones
+// first, then those with the same host, body and query parameters. Ties go
+// to the earliest, which keeps repeated requests in recorded order.
+type Replayer struct {
+	scrub *Scrubber
+
+	mu        sync.Mutex
+	exchanges []Exchange
+	used      []bool
+	calls     []Exchange
+	misses    []string
+}
+
+// NewReplayer creates a replayer serving the cassette's exchanges
+func NewReplayer(c *Cassette) *Replayer {
+	return &Replayer{
+		scrub:     NewScrubber(),
+		exchanges: c.Exchanges,
+		used:      make([]bool, len(c.Exchanges)),
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	call := Exchange{
+		Method:      req.Method,
+		URL:         r.scrub.URL(req.URL),
+		RequestBody: r.scrub.String(string(reqBody)),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.match(call)
+	if i < 0 {
+		r.misses = append(r.misses, call.Method+" "+call.URL)
+		r.calls = append(r.calls, call)
+		log.Warn().Str("method", call.Method).Str("url", call.URL).Msg("no recorded response")
+		return nil, fmt.Errorf("replay: no recorded response for %s %s", call.Method, call.URL)
+	}
+	r.used[i] = true
+	exchange := r.exchanges[i]
+	call.Status = exchange.Status
+	r.calls = append(r.calls, call)
+
+	body := []byte(exchange.Body)
+	if exchange.BodyEncoding == "base64" {
+		if body, err = base64.StdEncoding.DecodeString(exchange.Body); err != nil {
+			return nil, fmt.Errorf("replay: invalid recorded body for %s %s: %w", call.Method, call.URL, err)
+		}
+	}
+	header := exchange.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", exchange.Status, http.StatusText(exchange.Status)),
+		StatusCode:    exchange.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// match returns the index of the closest recorded exchange, or -1
+func (r *Replayer) match(call Exchange) int {
+	callURL, err := url.Parse(call.URL)
+	if err != nil {
+		return -1
+	}
+	callQuery := callURL.Query()
+
+	best, bestScore := -1, 0
+	for i, exchange := range r.exchanges {
+		if exchange.Method != call.Method {
+			continue
+		}
+		u, err := url.Parse(exchange.URL)
+		if err != nil || u.Path != callURL.Path {
+			continue
+		}
+
+		score := 0
+		if !r.used[i] {
+			score += 10000
+		}
+		if u.Host == callURL.Host {
+			score += 1000
+		}
+		if exchange.RequestBody == call.RequestBody {
+			score += 1000
+		}
+		for name, values := range u.Query() {
+			if strings.Join(callQuery[name], ",") == strings.Join(values, ",") {
+				score++
+			} else {
+				score--
+			}
+		}
+
+		if best < 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// Calls returns the requests served so far, in order, with the status of
+// the recorded response (0 for misses)
+func (r *Replayer) Calls() []Exchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Exchange(nil), r.calls...)
+}
+
+// Misses returns the requests that had no recorded response
+func (r *Replayer) Misses() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.misses...)
+}
+
+// readRequestBody reads the request body and puts it back for the transport
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
@@ -0,0 +1,94 @@
+package replay
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Redacted replaces every scrubbed secret
+const Redacted = "REDACTED"
+
+// secretParams are query parameters that always carry a secret (compared
+// case-insensitively)
+var secretParams = map[string]bool{
+	"apikey":        true, // Tautulli
+	"api_key":       true, // TMDb
+	"x-plex-token":  true,
+	"token":         true, // webhooks
+	"access_token":  true,
+	"client_secret": true,
+}
+
+// secretHeaders are headers that are dropped from recorded responses
+var secretHeaders = []string{"Authorization", "Proxy-Authorization", "X-Plex-Token", "X-Api-Key", "Cookie", "Set-Cookie"}
+
+// secretFields matches token attributes in JSON and XML bodies, such as
+// the accessToken of servers listed by plex.tv
+var secretFields = regexp.MustCompile(`(?i)("?(?:accessToken|authToken|authenticationToken|api_?key|token)"?\s*[:=]\s*")[^"]*(")`)
+
+// Scrubber removes secrets from URLs, headers and bodies before they are
+// written to a fixture
+type Scrubber struct {
+	secrets []string
+}
+
+// NewScrubber creates a scrubber that also replaces the given secret values
+// wherever they appear
+func NewScrubber(secrets ...string) *Scrubber {
+	s := &Scrubber{}
+	for _, secret := range secrets {
+		// Very short values would redact unrelated text
+		if len(secret) >= 4 {
+			s.secrets = append(s.secrets, secret)
+		}
+	}
+	// Longest first, so a secret containing another is replaced whole
+	sort.Slice(s.secrets, func(i, j int) bool { return len(s.secrets[i]) > len(s.secrets[j]) })
+	return s
+}
+
+// String replaces the known secret values and token fields in text
+func (s *Scrubber) String(text string) string {
+	for _, secret := range s.secrets {
+		text = strings.ReplaceAll(text, secret, Redacted)
+		if escaped := url.QueryEscape(secret); escaped != secret {
+			text = strings.ReplaceAll(text, escaped, Redacted)
+		}
+	}
+	return secretFields.ReplaceAllString(text, "${1}"+Redacted+"${2}")
+}
+
+// URL returns the URL with secret query parameters and credentials redacted.
+// Query parameters are sorted, so equal requests give equal URLs.
+func (s *Scrubber) URL(u *url.URL) string {
+	scrubbed := *u
+	if scrubbed.User != nil {
+		scrubbed.User = url.User(Redacted)
+	}
+	query := scrubbed.Query()
+	for name := range query {
+		if secretParams[strings.ToLower(name)] {
+			query.Set(name, Redacted)
+		}
+	}
+	scrubbed.RawQuery = query.Encode()
+	return s.String(scrubbed.String())
+}
+
+// Header returns a copy of the header without secret headers, with secret
+// values redacted from the rest
+func (s *Scrubber) Header(h http.Header) http.Header {
+	scrubbed := make(http.Header, len(h))
+	for name, values := range h {
+		for _, value := range values {
+			scrubbed.Add(name, s.String(value))
+		}
+	}
+	for _, name := range secretHeaders {
+		scrubbed.Del(name)
+	}
+	return scrubbed
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"strings"
 	"time"
+	"unicode"
 
+	"github.com/dppeppel/scryarr/internal/config"
 	"github.com/dppeppel/scryarr/internal/llm"
 	"github.com/dppeppel/scryarr/internal/logging"
 	"github.com/dppeppel/scryarr/internal/store"
@@ -18,7 +20,9 @@ func init() {
 	log = logging.GetLogger("resolve")
 }
 
-// ResolvedItem represents a fully resolved recommendation with TMDb metadata
+// ResolvedItem represents a fully resolved recommendation with TMDb metadata.
+// InLibrary marks picks already in the category's Plex library scope;
+// RatingKey is then the first matching inventory item.
 type ResolvedItem struct {
 	Title      string   `json:"title"`
 	Year       int      `json:"year"`
@@ -31,49 +35,195 @@ type ResolvedItem struct {
 	Why        string   `json:"why"`
 	Keywords   []string `json:"keywords"`
 	Genres     []string `json:"genres,omitempty"`
+	InLibrary  bool     `json:"in_library"`
+	RatingKey  string   `json:"rating_key,omitempty"`
+	Demoted    bool     `json:"demoted,omitempty"` // close TMDb neighbour of a disliked title
+	Pinned     bool     `json:"pinned,omitempty"`  // from the category's pins
 }
 
-// ResolvedOutput represents the final resolved recommendations for a category
+// ResolvedOutput represents the final resolved recommendations for a category.
+// Items holds every pick in rank order, owned or not; only picks to acquire
+// are recorded in history.
 type ResolvedOutput struct {
 	Category   string         `json:"category"`
 	ResolvedAt string         `json:"resolved_at"`
 	Items      []ResolvedItem `json:"items"`
 }
 
+// InLibrary returns the picks already in the library, in rank order
+func (o *ResolvedOutput) InLibrary() []ResolvedItem {
+	return o.filter(true)
+}
+
+// ToAcquire returns the picks not yet in the library, in rank order
+func (o *ResolvedOutput) ToAcquire() []ResolvedItem {
+	return o.filter(false)
+}
+
+// Split returns a copy of the output holding only the in-library (or only
+// the to-acquire) picks
+func (o *ResolvedOutput) Split(inLibrary bool) *ResolvedOutput {
+	return &ResolvedOutput{
+		Category:   o.Category,
+		ResolvedAt: o.ResolvedAt,
+		Items:      o.filter(inLibrary),
+	}
+}
+
+func (o *ResolvedOutput) filter(inLibrary bool) []ResolvedItem {
+	items := []ResolvedItem{}
+	for _, item := range o.Items {
+		if item.InLibrary == inLibrary {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// TitleSearcher resolves a title/year/medium to TMDb metadata
+type TitleSearcher interface {
+	SearchAndResolve(title string, year int, mediaType string) (*tmdb.TitleResult, error)
+}
+
+// NeighbourSource lists a title's closest TMDb neighbours. When the title
+// searcher also implements it, picks near disliked titles are demoted.
+type NeighbourSource interface {
+	GetNeighbours(tmdbID int, mediaType string) ([]int, error)
+}
+
+// DetailsSource looks a title up by TMDb ID. When the title searcher also
+// implements it, pins given by TMDb ID resolve without a search.
+type DetailsSource interface {
+	GetDetails(tmdbID int, mediaType string) (*tmdb.TitleResult, error)
+}
+
+// Dedup decides which earlier recommendations a category skips. Titles the
+// category itself recommended in the last Days are always skipped. With
+// Yields set, so are titles recommended in that window by any other label it
+// yields to.
+type Dedup struct {
+	Days   int
+	Yields func(label string) bool // nil disables cross-category dedup
+}
+
+// Dislike is a title the audience rated low or abandoned. Excluded titles,
+// such as those marked seen elsewhere, are only dropped: their neighbours
+// are not demoted.
+type Dislike struct {
+	TMDbID    int
+	MediaType string // movie or tv
+	Exclude   bool
+}
+
 // Resolver handles resolution of LLM recommendations to TMDb metadata
 type Resolver struct {
-	tmdbClient *tmdb.Client
-	store      *store.Store
+	tmdbClient TitleSearcher
+	history    store.HistoryRepository
+	inventory  store.InventoryRepository
+
+	// picks holds the labels that picked each title through this resolver,
+	// in-library picks included, for cross-category dedup within a run
+	picks map[int][]string
 }
 
 // NewResolver creates a new resolver
-func NewResolver(tmdbClient *tmdb.Client, store *store.Store) *Resolver {
+func NewResolver(tmdbClient TitleSearcher, history store.HistoryRepository, inventory store.InventoryRepository) *Resolver {
 	return &Resolver{
 		tmdbClient: tmdbClient,
-		store:      store,
+		history:    history,
+		inventory:  inventory,
+		picks:      make(map[int][]string),
 	}
 }
 
-// Resolve takes LLM recommendations and resolves them to TMDb IDs with full metadata
-func (r *Resolver) Resolve(llmResp *llm.LLMResponse, categoryLabel string) (*ResolvedOutput, error) {
-	log.Info().Str("category", categoryLabel).Int("count", len(llmResp.Recommendations)).Msg("resolving recommendations")
+// Resolve takes LLM recommendations and resolves them to TMDb IDs with full metadata.
+// The category's pins come first and are exempt from dedup. Disliked titles
+// are dropped and their close neighbours moved to the end. Picks to acquire
+// are recorded in history under the category run catRunID.
+func (r *Resolver) Resolve(llmResp *llm.LLMResponse, category *config.Category, catRunID int64, dislikes []Dislike, dedup Dedup) (*ResolvedOutput, error) {
+	categoryLabel := category.Label
+	scope := InventoryScope(category)
+	pen := r.penalties(dislikes)
+
+	log.Info().Str("category", categoryLabel).Int("count", len(llmResp.Recommendations)).Int("pins", len(category.Pins)).Msg("resolving recommendations")
 
 	var resolved []ResolvedItem
+	var inLibrary int
 
-	// Get already recommended items for deduplication (last 60 days)
-	since := time.Now().AddDate(0, 0, -60)
-	alreadyRecommended, err := r.store.GetRecommendationsSince(categoryLabel, since)
+	// Get already recommended items for deduplication
+	since := time.Now().AddDate(0, 0, -dedup.Days)
+	alreadyRecommended, err := r.history.GetRecommendationsSince(categoryLabel, since)
 	if err != nil {
 		log.Warn().Err(err).Msg("failed to get recommendation history")
 		alreadyRecommended = make(map[int]bool)
 	}
+	claimed := r.claimedElsewhere(categoryLabel, since, dedup.Yields)
+
+	add := func(result *tmdb.TitleResult, mediaType, why string, keywords []string, pinned bool) {
+		item := ResolvedItem{
+			Title:      result.Title,
+			Year:       result.Year,
+			Medium:     mediaType,
+			TMDbID:     result.TMDbID,
+			IMDbID:     result.IMDbID,
+			RuntimeMin: result.RuntimeMin,
+			VoteCount:  result.VoteCount,
+			VoteAvg:    result.VoteAvg,
+			Why:        why,
+			Keywords:   keywords,
+			Genres:     result.Genres,
+			Pinned:     pinned,
+		}
+
+		// Mark as seen to prevent duplicates in this batch
+		alreadyRecommended[result.TMDbID] = true
+		r.picks[result.TMDbID] = append(r.picks[result.TMDbID], categoryLabel)
+
+		// Check if in Plex inventory by TMDb ID (limited to the category's library scope)
+		owned, err := r.inventory.FindPlexInventory(result.TMDbID, mediaType, scope)
+		if err != nil {
+			log.Warn().Err(err).Msg("failed to check Plex inventory")
+		}
+		if len(owned) > 0 {
+			log.Debug().Str("title", result.Title).Int("tmdb_id", result.TMDbID).Msg("item already in Plex")
+			item.InLibrary = true
+			item.RatingKey = owned[0].RatingKey
+			resolved = append(resolved, item)
+			inLibrary++
+			return
+		}
+
+		resolved = append(resolved, item)
+
+		// Record in history
+		if err := r.history.RecordRecommendation(categoryLabel, result.TMDbID, mediaType, catRunID); err != nil {
+			log.Warn().Err(err).Msg("failed to record recommendation")
+		}
+	}
+
+	pinned := make(map[int]bool)
+	for _, pin := range category.Pins {
+		mediaType := normalizeMedium(pin.Medium)
+		result, err := r.resolvePin(pin, mediaType)
+		if err != nil {
+			log.Warn().Err(err).Str("title", pin.Title).Int("tmdb_id", pin.TMDbID).Msg("failed to resolve pin")
+			continue
+		}
+		if pinned[result.TMDbID] {
+			continue
+		}
+		pinned[result.TMDbID] = true
+
+		why := pin.Why
+		if why == "" {
+			why = "Pinned to this category"
+		}
+		add(result, mediaType, why, nil, true)
+	}
 
 	for _, rec := range llmResp.Recommendations {
 		// Normalize media type (handle various formats from LLM)
-		mediaType := strings.ToLower(rec.Medium)
-		if mediaType == "show" || mediaType == "series" {
-			mediaType = "tv"
-		}
+		mediaType := normalizeMedium(rec.Medium)
 
 		// Search TMDb
 		result, err := r.tmdbClient.SearchAndResolve(rec.Title, rec.Year, mediaType)
@@ -87,41 +237,16 @@ func (r *Resolver) Resolve(llmResp *llm.LLMResponse, categoryLabel string) (*Res
 			log.Debug().Str("title", result.Title).Int("tmdb_id", result.TMDbID).Msg("skipping duplicate")
 			continue
 		}
-
-		// Check if in Plex inventory by TMDb ID
-		inPlex, err := r.store.IsInPlexInventory(result.TMDbID, mediaType)
-		if err != nil {
-			log.Warn().Err(err).Msg("failed to check Plex inventory")
-		}
-		if inPlex {
-			log.Debug().Str("title", result.Title).Int("tmdb_id", result.TMDbID).Msg("skipping item already in Plex")
+		if claimed[result.TMDbID] {
+			log.Debug().Str("title", result.Title).Int("tmdb_id", result.TMDbID).Msg("skipping title claimed by another category")
 			continue
 		}
-
-		// Add to resolved list
-		item := ResolvedItem{
-			Title:      result.Title,
-			Year:       result.Year,
-			Medium:     mediaType,
-			TMDbID:     result.TMDbID,
-			IMDbID:     result.IMDbID,
-			RuntimeMin: result.RuntimeMin,
-			VoteCount:  result.VoteCount,
-			VoteAvg:    result.VoteAvg,
-			Why:        rec.Why,
-			Keywords:   rec.Keywords,
-			Genres:     result.Genres,
-		}
-
-		resolved = append(resolved, item)
-
-		// Record in history
-		if err := r.store.RecordRecommendation(categoryLabel, result.TMDbID, mediaType); err != nil {
-			log.Warn().Err(err).Msg("failed to record recommendation")
+		if pen.disliked[dislikeKey(mediaType, result.TMDbID)] {
+			log.Debug().Str("title", result.Title).Int("tmdb_id", result.TMDbID).Msg("skipping disliked title")
+			continue
 		}
 
-		// Mark as seen to prevent duplicates in this batch
-		alreadyRecommended[result.TMDbID] = true
+		add(result, mediaType, rec.Why, rec.Keywords, false)
 	}
 
 	if len(resolved) == 0 {
@@ -131,10 +256,214 @@ func (r *Resolver) Resolve(llmResp *llm.LLMResponse, categoryLabel string) (*Res
 	output := &ResolvedOutput{
 		Category:   categoryLabel,
 		ResolvedAt: time.Now().UTC().Format(time.RFC3339),
-		Items:      resolved,
+		Items:      pen.demote(resolved),
 	}
 
-	log.Info().Str("category", categoryLabel).Int("resolved", len(resolved)).Msg("resolution complete")
+	log.Info().Str("category", categoryLabel).Int("resolved", len(resolved)).Int("in_library", inLibrary).Int("pinned", len(pinned)).Msg("resolution complete")
 
 	return output, nil
 }
+
+// resolvePin looks a pin up by TMDb ID when it has one and the searcher
+// supports it, and by title otherwise
+func (r *Resolver) resolvePin(pin config.Pin, mediaType string) (*tmdb.TitleResult, error) {
+	if pin.TMDbID > 0 {
+		if source, ok := r.tmdbClient.(DetailsSource); ok {
+			return source.GetDetails(pin.TMDbID, mediaType)
+		}
+	}
+	if pin.Title == "" {
+		return nil, fmt.Errorf("pin has no title")
+	}
+	return r.tmdbClient.SearchAndResolve(pin.Title, pin.Year, mediaType)
+}
+
+// claimedElsewhere returns the titles another label that label yields to
+// picked earlier in this run or recommended since the given date
+func (r *Resolver) claimedElsewhere(label string, since time.Time, yields func(string) bool) map[int]bool {
+	claimed := make(map[int]bool)
+	if yields == nil {
+		return claimed
+	}
+
+	claim := func(byTitle map[int][]string) {
+		for id, labels := range byTitle {
+			for _, other := range labels {
+				if other != label && yields(other) {
+					claimed[id] = true
+					break
+				}
+			}
+		}
+	}
+
+	claim(r.picks)
+	recommended, err := r.history.GetRecommendationLabelsSince(since)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to get other categories' recommendations")
+	}
+	claim(recommended)
+	return claimed
+}
+
+// ResolveLibrary maps the LLM's picks for a library-scope category back onto
+// the candidate inventory items they were chosen from. Picks that match no
+// candidate are dropped, so every returned item is in the library. Nothing is
+// recorded in history: the collection is rebuilt from scratch on every run.
+func (r *Resolver) ResolveLibrary(llmResp *llm.LLMResponse, category *config.Category, candidates []store.InventoryItem, dislikes []Dislike) (*ResolvedOutput, error) {
+	log.Info().Str("category", category.Label).Int("count", len(llmResp.Recommendations)).Msg("resolving library picks")
+	pen := r.penalties(dislikes)
+
+	byTitle := make(map[string][]store.InventoryItem)
+	for _, item := range candidates {
+		key := NormalizeTitle(item.Title)
+		byTitle[key] = append(byTitle[key], item)
+	}
+
+	var inLibrary []ResolvedItem
+	seen := make(map[int]bool)
+	for _, rec := range llmResp.Recommendations {
+		mediaType := normalizeMedium(rec.Medium)
+
+		match, ok := matchCandidate(byTitle[NormalizeTitle(rec.Title)], rec.Year, mediaType)
+		if !ok {
+			log.Warn().Str("title", rec.Title).Int("year", rec.Year).Msg("pick is not one of the library candidates")
+			continue
+		}
+		if seen[match.TMDbID] || pen.disliked[dislikeKey(match.MediaType, match.TMDbID)] {
+			continue
+		}
+		seen[match.TMDbID] = true
+
+		inLibrary = append(inLibrary, ResolvedItem{
+			Title:     match.Title,
+			Year:      match.Year,
+			Medium:    match.MediaType,
+			TMDbID:    match.TMDbID,
+			Why:       rec.Why,
+			Keywords:  rec.Keywords,
+			InLibrary: true,
+			RatingKey: match.RatingKey,
+		})
+	}
+
+	if len(inLibrary) == 0 {
+		return nil, fmt.Errorf("no library picks matched the candidates")
+	}
+
+	log.Info().Str("category", category.Label).Int("in_library", len(inLibrary)).Msg("library resolution complete")
+
+	return &ResolvedOutput{
+		Category:   category.Label,
+		ResolvedAt: time.Now().UTC().Format(time.RFC3339),
+		Items:      pen.demote(inLibrary),
+	}, nil
+}
+
+// penalties holds the disliked titles and their TMDb neighbours, keyed by
+// dislikeKey
+type penalties struct {
+	disliked   map[string]bool
+	neighbours map[string]bool
+}
+
+// penalties looks up the neighbours of each disliked title. Lookup failures
+// only lose that title's neighbours.
+func (r *Resolver) penalties(dislikes []Dislike) *penalties {
+	pen := &penalties{
+		disliked:   make(map[string]bool),
+		neighbours: make(map[string]bool),
+	}
+	source, _ := r.tmdbClient.(NeighbourSource)
+
+	for _, d := range dislikes {
+		pen.disliked[dislikeKey(d.MediaType, d.TMDbID)] = true
+		if source == nil || d.Exclude {
+			continue
+		}
+		ids, err := source.GetNeighbours(d.TMDbID, d.MediaType)
+		if err != nil {
+			log.Warn().Err(err).Int("tmdb_id", d.TMDbID).Msg("failed to get neighbours of disliked title")
+			continue
+		}
+		for _, id := range ids {
+			pen.neighbours[dislikeKey(d.MediaType, id)] = true
+		}
+	}
+	return pen
+}
+
+// demote moves neighbours of disliked titles behind every other pick,
+// keeping the order within each group
+func (p *penalties) demote(items []ResolvedItem) []ResolvedItem {
+	var kept, demoted []ResolvedItem
+	for _, item := range items {
+		if !item.Pinned && p.neighbours[dislikeKey(item.Medium, item.TMDbID)] {
+			item.Demoted = true
+			demoted = append(demoted, item)
+			continue
+		}
+		kept = append(kept, item)
+	}
+	if len(demoted) > 0 {
+		log.Debug().Int("demoted", len(demoted)).Msg("demoted neighbours of disliked titles")
+	}
+	return append(kept, demoted...)
+}
+
+// normalizeMedium maps the media type spellings LLMs use onto movie or tv
+func normalizeMedium(medium string) string {
+	mediaType := strings.ToLower(medium)
+	if mediaType == "show" || mediaType == "series" {
+		mediaType = "tv"
+	}
+	return mediaType
+}
+
+func dislikeKey(mediaType string, tmdbID int) string {
+	return fmt.Sprintf("%s:%d", mediaType, tmdbID)
+}
+
+// matchCandidate picks the candidate for a title match, preferring the same
+// medium and tolerating the off-by-one years that LLMs and Plex often disagree on
+func matchCandidate(items []store.InventoryItem, year int, mediaType string) (store.InventoryItem, bool) {
+	for _, item := range items {
+		if mediaType != "" && item.MediaType != mediaType {
+			continue
+		}
+		if year == 0 || item.Year == 0 || absInt(item.Year-year) <= 1 {
+			return item, true
+		}
+	}
+	return store.InventoryItem{}, false
+}
+
+// NormalizeTitle folds case, punctuation and spacing for title comparison
+func NormalizeTitle(title string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// InventoryScope converts a category's library selection into a store scope
+func InventoryScope(category *config.Category) *store.InventoryScope {
+	if category.Library == nil {
+		return nil
+	}
+	return &store.InventoryScope{
+		Servers:         category.Library.Servers,
+		IncludeSections: category.Library.IncludeSections,
+		ExcludeSections: category.Library.ExcludeSections,
+	}
+}
@@ -0,0 +1,235 @@
+package resolve
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dppeppel/scryarr/internal/config"
+	"github.com/dppeppel/scryarr/internal/llm"
+	"github.com/dppeppel/scryarr/internal/store"
+	"github.com/dppeppel/scryarr/internal/tmdb"
+)
+
+// fakeTMDb resolves titles from a fixed table and reports fixed neighbours
+type fakeTMDb struct {
+	ids        map[string]int // title -> TMDb ID
+	neighbours map[int][]int
+}
+
+func (f *fakeTMDb) SearchAndResolve(title string, year int, mediaType string) (*tmdb.TitleResult, error) {
+	id, ok := f.ids[title]
+	if !ok {
+		return nil, fmt.Errorf("no match for %q", title)
+	}
+	return &tmdb.TitleResult{TMDbID: id, Title: title, Year: year, MediaType: mediaType}, nil
+}
+
+func (f *fakeTMDb) GetDetails(tmdbID int, mediaType string) (*tmdb.TitleResult, error) {
+	for title, id := range f.ids {
+		if id == tmdbID {
+			return &tmdb.TitleResult{TMDbID: id, Title: title, MediaType: mediaType}, nil
+		}
+	}
+	return nil, fmt.Errorf("no title %d", tmdbID)
+}
+
+func (f *fakeTMDb) GetNeighbours(tmdbID int, mediaType string) ([]int, error) {
+	return f.neighbours[tmdbID], nil
+}
+
+func newFakeTMDb() *fakeTMDb {
+	return &fakeTMDb{
+		ids: map[string]int{
+			"Alien":     348,
+			"Aliens":    679,
+			"The Thing": 1091,
+			"Arrival":   329865,
+			"Contact":   686,
+			"Solaris":   593,
+			"Moon":      17431,
+			"Sunshine":  1272,
+		},
+		neighbours: map[int][]int{348: {679}},
+	}
+}
+
+func recommend(titles ...string) *llm.LLMResponse {
+	resp := &llm.LLMResponse{}
+	for _, title := range titles {
+		resp.Recommendations = append(resp.Recommendations, llm.Recommendation{Title: title, Year: 2000, Medium: "movie"})
+	}
+	return resp
+}
+
+func ids(out *ResolvedOutput) []int {
+	var result []int
+	for _, item := range out.Items {
+		result = append(result, item.TMDbID)
+	}
+	return result
+}
+
+func assertIDs(t *testing.T, out *ResolvedOutput, want ...int) {
+	t.Helper()
+	got := ids(out)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("items = %v, want %v", got, want)
+	}
+}
+
+func TestResolveSkipsOwnRecentRecommendations(t *testing.T) {
+	db := store.NewMemoryStore()
+	r := NewResolver(newFakeTMDb(), db, db)
+	category := &config.Category{Label: "Space"}
+
+	out, err := r.Resolve(recommend("Arrival", "Contact", "Arrival"), category, 1, nil, Dedup{Days: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, out, 329865, 686)
+
+	// A later run skips what was recommended, but keeps new titles
+	r = NewResolver(newFakeTMDb(), db, db)
+	out, err = r.Resolve(recommend("Arrival", "Moon"), category, 2, nil, Dedup{Days: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, out, 17431)
+}
+
+func TestResolveInLibraryPicksAreNotRecorded(t *testing.T) {
+	db := store.NewMemoryStore()
+	db.ReplacePlexInventory("home", []store.InventoryItem{
+		{Server: "home", SectionKey: "1", RatingKey: "10", TMDbID: 686, MediaType: "movie", Title: "Contact"},
+	})
+	r := NewResolver(newFakeTMDb(), db, db)
+
+	out, err := r.Resolve(recommend("Contact", "Moon"), &config.Category{Label: "Space"}, 1, nil, Dedup{Days: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !out.Items[0].InLibrary || out.Items[0].RatingKey != "10" {
+		t.Errorf("Contact = %+v, want in library with rating key 10", out.Items[0])
+	}
+	if out.Items[1].InLibrary {
+		t.Errorf("Moon is marked in library")
+	}
+
+	recommended, _ := db.GetRecommendationsSince("Space", time.Now().AddDate(0, 0, -1))
+	if recommended[686] || !recommended[17431] {
+		t.Errorf("history = %v, want only Moon (17431)", recommended)
+	}
+}
+
+func TestResolveCrossCategoryDedupYieldsToHigherPriority(t *testing.T) {
+	db := store.NewMemoryStore()
+	priorities := map[string]int{"Favourites": 10, "Space": 0}
+	yields := func(category string) func(string) bool {
+		return func(label string) bool {
+			priority, ok := priorities[label]
+			return ok && priority >= priorities[category]
+		}
+	}
+
+	r := NewResolver(newFakeTMDb(), db, db)
+	if _, err := r.Resolve(recommend("Arrival"), &config.Category{Label: "Favourites"}, 1, nil, Dedup{Days: 60, Yields: yields("Favourites")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Space yields to Favourites, within the run and through history
+	out, err := r.Resolve(recommend("Arrival", "Moon"), &config.Category{Label: "Space"}, 2, nil, Dedup{Days: 60, Yields: yields("Space")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, out, 17431)
+
+	r = NewResolver(newFakeTMDb(), db, db)
+	out, err = r.Resolve(recommend("Arrival", "Solaris"), &config.Category{Label: "Space"}, 3, nil, Dedup{Days: 60, Yields: yields("Space")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, out, 593)
+
+	// Favourites does not yield to Space's picks
+	out, err = r.Resolve(recommend("Solaris"), &config.Category{Label: "Favourites"}, 4, nil, Dedup{Days: 60, Yields: yields("Favourites")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, out, 593)
+
+	// Without Yields, nothing is claimed
+	out, err = r.Resolve(recommend("Arrival"), &config.Category{Label: "Other"}, 5, nil, Dedup{Days: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, out, 329865)
+}
+
+func TestResolvePinsComeFirstAndSkipDedup(t *testing.T) {
+	db := store.NewMemoryStore()
+	db.RecordRecommendation("Space", 686, "movie", 1)
+	r := NewResolver(newFakeTMDb(), db, db)
+	category := &config.Category{
+		Label: "Space",
+		Pins: []config.Pin{
+			{TMDbID: 686, Medium: "movie"},
+			{Title: "Moon", Year: 2009, Medium: "movie", Why: "House favourite"},
+		},
+	}
+
+	out, err := r.Resolve(recommend("Arrival", "Moon", "Contact"), category, 2, nil, Dedup{Days: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, out, 686, 17431, 329865)
+	if !out.Items[0].Pinned || out.Items[0].Why != "Pinned to this category" {
+		t.Errorf("first pin = %+v", out.Items[0])
+	}
+	if out.Items[1].Why != "House favourite" {
+		t.Errorf("second pin why = %q", out.Items[1].Why)
+	}
+	if out.Items[2].Pinned {
+		t.Errorf("LLM pick is marked pinned")
+	}
+}
+
+func TestResolveDislikes(t *testing.T) {
+	db := store.NewMemoryStore()
+	r := NewResolver(newFakeTMDb(), db, db)
+	dislikes := []Dislike{
+		{TMDbID: 348, MediaType: "movie"},                 // Alien: dropped, Aliens demoted
+		{TMDbID: 1091, MediaType: "movie", Exclude: true}, // The Thing: dropped only
+	}
+
+	out, err := r.Resolve(recommend("Aliens", "Alien", "The Thing", "Moon"), &config.Category{Label: "Space"}, 1, dislikes, Dedup{Days: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, out, 17431, 679)
+	if !out.Items[1].Demoted {
+		t.Errorf("Aliens is not marked demoted")
+	}
+}
+
+func TestResolveLibraryMatchesCandidates(t *testing.T) {
+	r := NewResolver(newFakeTMDb(), store.NewMemoryStore(), store.NewMemoryStore())
+	candidates := []store.InventoryItem{
+		{RatingKey: "1", TMDbID: 348, MediaType: "movie", Title: "Alien", Year: 1979},
+		{RatingKey: "2", TMDbID: 686, MediaType: "movie", Title: "Contact", Year: 1997},
+	}
+	resp := &llm.LLMResponse{Recommendations: []llm.Recommendation{
+		{Title: "contact", Year: 1997, Medium: "movie"},
+		{Title: "Moon", Year: 2009, Medium: "movie"},
+		{Title: "Alien", Year: 1979, Medium: "movie"},
+	}}
+
+	out, err := r.ResolveLibrary(resp, &config.Category{Label: "Owned"}, candidates, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, out, 686, 348)
+	if out.Items[0].RatingKey != "2" || !out.Items[0].InLibrary {
+		t.Errorf("Contact = %+v", out.Items[0])
+	}
+}
@@ -0,0 +1,63 @@
+package store
+
+import (
+	"strconv"
+	"strings"
+)
+
+// dialect identifies the SQL flavour spoken by the underlying database
+type dialect string
+
+const (
+	dialectSQLite   dialect = "sqlite"
+	dialectPostgres dialect = "postgres"
+)
+
+// driverName returns the database/sql driver registered for the dialect
+func (d dialect) driverName() string {
+	if d == dialectPostgres {
+		return "postgres"
+	}
+	return "sqlite3"
+}
+
+// rebind rewrites '?' placeholders into the dialect's native form.
+// Queries are always written with '?' and converted here for Postgres ($1, $2, ...).
+func (d dialect) rebind(query string) string {
+	if d != dialectPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	inQuote := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inQuote = !inQuote
+			b.WriteRune(r)
+		case r == '?' && !inQuote:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// parseDBURL determines the dialect and driver DSN from a db_url value.
+// postgres:// and postgresql:// URLs select Postgres; sqlite:// URLs and bare
+// paths select SQLite.
+func parseDBURL(dbURL string) (dialect, string) {
+	switch {
+	case strings.HasPrefix(dbURL, "postgres://"), strings.HasPrefix(dbURL, "postgresql://"):
+		return dialectPostgres, dbURL
+	case strings.HasPrefix(dbURL, "sqlite://"):
+		return dialectSQLite, strings.TrimPrefix(dbURL, "sqlite://")
+	default:
+		return dialectSQLite, dbURL
+	}
+}
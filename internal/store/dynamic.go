@@ -0,0 +1,98 @@
+package store
+
+import "time"
+
+// DynamicCategory is a category generated at run time from a dynamic
+// category definition, such as a recent_seeds seed
+type DynamicCategory struct {
+	ParentLabel string
+	Label       string
+	SeedTitle   string
+	SeedYear    int
+	SeedMedium  string
+	FirstSeenAt time.Time
+	LastSeenAt  time.Time
+	RetiredAt   *time.Time
+}
+
+// SyncDynamicCategories records the categories a dynamic parent generated
+// this run and retires the ones it generated before but no longer does. It
+// returns the newly retired categories.
+func (s *Store) SyncDynamicCategories(parentLabel string, active []DynamicCategory) ([]DynamicCategory, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(s.dialect.rebind(
+		`SELECT label, seed_title, seed_year, seed_medium, first_seen_at, last_seen_at
+		FROM dynamic_category WHERE parent_label = ? AND retired_at IS NULL`), parentLabel)
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[string]DynamicCategory)
+	for rows.Next() {
+		c := DynamicCategory{ParentLabel: parentLabel}
+		var firstSeen, lastSeen string
+		if err := rows.Scan(&c.Label, &c.SeedTitle, &c.SeedYear, &c.SeedMedium, &firstSeen, &lastSeen); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		c.FirstSeenAt, _ = time.Parse(time.RFC3339, firstSeen)
+		c.LastSeenAt, _ = time.Parse(time.RFC3339, lastSeen)
+		current[c.Label] = c
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	upsert, err := tx.Prepare(s.dialect.rebind(
+		`INSERT INTO dynamic_category
+		(parent_label, label, seed_title, seed_year, seed_medium, first_seen_at, last_seen_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(parent_label, label) DO UPDATE SET
+			seed_title = excluded.seed_title,
+			seed_year = excluded.seed_year,
+			seed_medium = excluded.seed_medium,
+			last_seen_at = excluded.last_seen_at,
+			retired_at = NULL`))
+	if err != nil {
+		return nil, err
+	}
+	defer upsert.Close()
+
+	for _, c := range active {
+		if _, err := upsert.Exec(parentLabel, c.Label, c.SeedTitle, c.SeedYear, c.SeedMedium, now, now); err != nil {
+			return nil, err
+		}
+		delete(current, c.Label)
+	}
+
+	var retired []DynamicCategory
+	if len(current) > 0 {
+		retire, err := tx.Prepare(s.dialect.rebind(
+			"UPDATE dynamic_category SET retired_at = ? WHERE parent_label = ? AND label = ?"))
+		if err != nil {
+			return nil, err
+		}
+		defer retire.Close()
+
+		retiredAt, _ := time.Parse(time.RFC3339, now)
+		for label, c := range current {
+			if _, err := retire.Exec(now, parentLabel, label); err != nil {
+				return nil, err
+			}
+			c.RetiredAt = &retiredAt
+			retired = append(retired, c)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return retired, nil
+}
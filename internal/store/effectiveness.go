@@ -0,0 +1,143 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RecommendationRecord is a recommendation history row with the model of the
+// category run that first made it. CategoryRunID is zero for rows recorded
+// before runs were tracked.
+type RecommendationRecord struct {
+	Label         string
+	TMDbID        int
+	MediaType     string
+	FirstSeenAt   time.Time
+	CategoryRunID int64
+	Model         string
+	Experiment    string
+	Variant       string
+}
+
+// Effectiveness stat scopes
+const (
+	StatScopeCategory = "category"
+	StatScopeModel    = "model"
+	StatScopeVariant  = "variant"
+)
+
+// EffectivenessStat counts what became of the recommendations of one
+// category, model or experiment variant, as computed at the end of a job
+type EffectivenessStat struct {
+	JobID       int64     `json:"job_id"`
+	Scope       string    `json:"scope"`                // category, model, variant
+	Experiment  string    `json:"experiment,omitempty"` // for variant stats
+	Name        string    `json:"name"`                 // category label, model or variant
+	Recommended int       `json:"recommended"`
+	Acquired    int       `json:"acquired"`  // added to Plex after the recommendation
+	Watched     int       `json:"watched"`   // played after the recommendation
+	Completed   int       `json:"completed"` // finished after the recommendation
+	ComputedAt  time.Time `json:"computed_at"`
+}
+
+// ListRecommendationsSince retrieves the recommendations first made at or
+// after since, oldest first
+func (s *Store) ListRecommendationsSince(since time.Time) ([]RecommendationRecord, error) {
+	rows, err := s.query(
+		`SELECT h.label, h.tmdb_id, h.media_type, h.first_seen_at, COALESCE(h.category_run_id, 0), COALESCE(c.model, ''),
+			h.experiment, h.variant
+		FROM recommendation_history h
+		LEFT JOIN category_run c ON c.id = h.category_run_id
+		WHERE h.first_seen_at >= ?
+		ORDER BY h.first_seen_at, h.id`,
+		since.UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []RecommendationRecord
+	for rows.Next() {
+		var r RecommendationRecord
+		var mediaType sql.NullString
+		var firstSeenAt string
+		if err := rows.Scan(&r.Label, &r.TMDbID, &mediaType, &firstSeenAt, &r.CategoryRunID, &r.Model,
+			&r.Experiment, &r.Variant); err != nil {
+			return nil, err
+		}
+		r.MediaType = mediaType.String
+		r.FirstSeenAt, _ = time.Parse(time.RFC3339, firstSeenAt)
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// SaveEffectiveness replaces the stats computed for a job
+func (s *Store) SaveEffectiveness(jobID int64, stats []EffectivenessStat) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(s.dialect.rebind("DELETE FROM effectiveness_stat WHERE job_id = ?"), jobID); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(s.dialect.rebind(
+		`INSERT INTO effectiveness_stat
+		(job_id, scope, experiment, name, recommended, acquired, watched, completed, computed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, st := range stats {
+		if _, err := stmt.Exec(jobID, st.Scope, st.Experiment, st.Name, st.Recommended, st.Acquired, st.Watched,
+			st.Completed, st.ComputedAt.UTC().Format(time.RFC3339)); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetEffectiveness retrieves the stats computed for a job, or for the latest
+// job that computed any when jobID is zero
+func (s *Store) GetEffectiveness(jobID int64) ([]EffectivenessStat, error) {
+	if jobID == 0 {
+		var latest sql.NullInt64
+		if err := s.queryRow("SELECT MAX(job_id) FROM effectiveness_stat").Scan(&latest); err != nil {
+			return nil, err
+		}
+		if !latest.Valid {
+			return nil, nil
+		}
+		jobID = latest.Int64
+	}
+
+	rows, err := s.query(
+		`SELECT job_id, scope, experiment, name, recommended, acquired, watched, completed, computed_at
+		FROM effectiveness_stat WHERE job_id = ? ORDER BY scope, experiment, name`,
+		jobID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []EffectivenessStat
+	for rows.Next() {
+		var st EffectivenessStat
+		var computedAt string
+		if err := rows.Scan(&st.JobID, &st.Scope, &st.Experiment, &st.Name, &st.Recommended, &st.Acquired, &st.Watched,
+			&st.Completed, &computedAt); err != nil {
+			return nil, err
+		}
+		st.ComputedAt, _ = time.Parse(time.RFC3339, computedAt)
+		stats = append(stats, st)
+	}
+	return stats, rows.Err()
+}
@@ -0,0 +1,85 @@
+package store
+
+import "time"
+
+// Watch event sources
+const (
+	EventSourceTautulli = "tautulli"
+	EventSourcePlex     = "plex"
+)
+
+// WatchEvent is a playback event pushed by a webhook, kept so the taste
+// profile can see watches made since the last history pull
+type WatchEvent struct {
+	ID                   int64
+	Source               string // tautulli, plex
+	Event                string // watched, stop (Tautulli); scrobble, rate (Plex)
+	User                 string
+	RatingKey            string
+	ParentRatingKey      string
+	GrandparentRatingKey string
+	MediaType            string // movie, episode; show for Plex ratings
+	Title                string
+	GrandparentTitle     string
+	Year                 int
+	GUID                 string
+	TMDbID               int
+	IMDbID               string
+	TVDBID               int
+	PercentComplete      int
+	Rating               float64 // 0-10, zero when unrated
+	OccurredAt           time.Time
+}
+
+// RecordWatchEvent stores a webhook event and returns its id
+func (s *Store) RecordWatchEvent(e *WatchEvent) (int64, error) {
+	id, err := s.insertID(
+		`INSERT INTO watch_event
+		(source, event, user_name, rating_key, parent_rating_key, grandparent_rating_key, media_type,
+		title, grandparent_title, year, guid, tmdb_id, imdb_id, tvdb_id, percent_complete, rating, occurred_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.Source, e.Event, e.User, e.RatingKey, e.ParentRatingKey, e.GrandparentRatingKey, e.MediaType,
+		e.Title, e.GrandparentTitle, e.Year, e.GUID, e.TMDbID, e.IMDbID, e.TVDBID, e.PercentComplete,
+		e.Rating, e.OccurredAt.UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return 0, err
+	}
+	e.ID = id
+	return id, nil
+}
+
+// GetWatchEventsSince retrieves events at or after since, oldest first. An
+// empty source matches every source.
+func (s *Store) GetWatchEventsSince(since time.Time, source string) ([]WatchEvent, error) {
+	query := `SELECT id, source, event, user_name, rating_key, parent_rating_key, grandparent_rating_key,
+		media_type, title, grandparent_title, year, guid, tmdb_id, imdb_id, tvdb_id, percent_complete,
+		rating, occurred_at
+		FROM watch_event WHERE occurred_at >= ?`
+	args := []interface{}{since.UTC().Format(time.RFC3339)}
+	if source != "" {
+		query += " AND source = ?"
+		args = append(args, source)
+	}
+	query += " ORDER BY occurred_at, id"
+
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []WatchEvent
+	for rows.Next() {
+		var e WatchEvent
+		var occurredAt string
+		if err := rows.Scan(&e.ID, &e.Source, &e.Event, &e.User, &e.RatingKey, &e.ParentRatingKey,
+			&e.GrandparentRatingKey, &e.MediaType, &e.Title, &e.GrandparentTitle, &e.Year, &e.GUID,
+			&e.TMDbID, &e.IMDbID, &e.TVDBID, &e.PercentComplete, &e.Rating, &occurredAt); err != nil {
+			return nil, err
+		}
+		e.OccurredAt, _ = time.Parse(time.RFC3339, occurredAt)
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
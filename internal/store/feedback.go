@@ -0,0 +1,95 @@
+package store
+
+import (
+	"strings"
+	"time"
+)
+
+// Feedback kinds. Up and down are taste signals; seen and not interested
+// exclude the title from future recommendations.
+const (
+	FeedbackUp            = "up"
+	FeedbackDown          = "down"
+	FeedbackSeen          = "seen"
+	FeedbackNotInterested = "not_interested"
+)
+
+// FeedbackKinds lists the accepted feedback kinds
+var FeedbackKinds = []string{FeedbackUp, FeedbackDown, FeedbackSeen, FeedbackNotInterested}
+
+// Feedback is a user's verdict on a recommended title. An empty User is
+// feedback from the whole household.
+type Feedback struct {
+	ID        int64     `json:"id"`
+	Label     string    `json:"label"`
+	TMDbID    int       `json:"tmdb_id"`
+	MediaType string    `json:"media_type"` // movie or tv
+	Title     string    `json:"title,omitempty"`
+	Year      int       `json:"year,omitempty"`
+	User      string    `json:"user,omitempty"`
+	Feedback  string    `json:"feedback"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// IsExclusion reports whether the feedback removes the title from future
+// recommendations
+func (f *Feedback) IsExclusion() bool {
+	return f.Feedback == FeedbackSeen || f.Feedback == FeedbackNotInterested
+}
+
+// RecordFeedback stores feedback and returns its id
+func (s *Store) RecordFeedback(f *Feedback) (int64, error) {
+	id, err := s.insertID(
+		`INSERT INTO recommendation_feedback
+		(label, tmdb_id, media_type, title, year, user_name, feedback, note, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		f.Label, f.TMDbID, f.MediaType, f.Title, f.Year, f.User, f.Feedback, f.Note,
+		f.CreatedAt.UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return 0, err
+	}
+	f.ID = id
+	return id, nil
+}
+
+// ListFeedback retrieves feedback, newest first. Empty label or user match
+// every label or user.
+func (s *Store) ListFeedback(label, user string) ([]Feedback, error) {
+	query := `SELECT id, label, tmdb_id, media_type, title, year, user_name, feedback, note, created_at
+		FROM recommendation_feedback`
+	var where []string
+	var args []interface{}
+	if label != "" {
+		where = append(where, "label = ?")
+		args = append(args, label)
+	}
+	if user != "" {
+		where = append(where, "LOWER(user_name) = ?")
+		args = append(args, strings.ToLower(user))
+	}
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY created_at DESC, id DESC"
+
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var feedback []Feedback
+	for rows.Next() {
+		var f Feedback
+		var createdAt string
+		if err := rows.Scan(&f.ID, &f.Label, &f.TMDbID, &f.MediaType, &f.Title, &f.Year, &f.User,
+			&f.Feedback, &f.Note, &createdAt); err != nil {
+			return nil, err
+		}
+		f.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+		feedback = append(feedback, f)
+	}
+	return feedback, rows.Err()
+}
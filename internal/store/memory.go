@@ -0,0 +1,708 @@
+package store
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-memory Repository for tests and dry runs.
+// It mirrors the semantics of the SQLite store without touching disk.
+type MemoryStore struct {
+	mu sync.Mutex
+
+	jobRuns      []JobRun
+	categoryRuns []CategoryRun
+	history      map[historyKey]*historyEntry
+	resolutions  []TitleResolution
+	inventory    map[inventoryKey]InventoryItem
+	syncStates   map[string]PlexSyncState
+	ratings      []UserRating
+	watchlists   map[string][]WatchlistEntry
+	events       []WatchEvent
+	feedback     []Feedback
+	stats        map[int64][]EffectivenessStat
+	dynamic      map[string]map[string]DynamicCategory // parent -> label -> category
+}
+
+type historyKey struct {
+	label     string
+	tmdbID    int
+	mediaType string
+}
+
+type inventoryKey struct {
+	server    string
+	ratingKey string
+}
+
+type historyEntry struct {
+	firstSeenAt time.Time
+	lastSeenAt  time.Time
+	catRunID    int64
+	experiment  string
+	variant     string
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		history:    make(map[historyKey]*historyEntry),
+		stats:      make(map[int64][]EffectivenessStat),
+		inventory:  make(map[inventoryKey]InventoryItem),
+		syncStates: make(map[string]PlexSyncState),
+		watchlists: make(map[string][]WatchlistEntry),
+		dynamic:    make(map[string]map[string]DynamicCategory),
+	}
+}
+
+// Close is a no-op for the in-memory store
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// CreateJobRun creates a new job run record
+func (m *MemoryStore) CreateJobRun(mode string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := int64(len(m.jobRuns) + 1)
+	m.jobRuns = append(m.jobRuns, JobRun{
+		ID:        id,
+		StartedAt: time.Now().UTC().Truncate(time.Second),
+		Mode:      mode,
+		Status:    "running",
+	})
+	return id, nil
+}
+
+// UpdateJobRun updates a job run record
+func (m *MemoryStore) UpdateJobRun(id int64, status string, errorMsg *string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.jobRuns {
+		if m.jobRuns[i].ID == id {
+			now := time.Now().UTC().Truncate(time.Second)
+			m.jobRuns[i].FinishedAt = &now
+			m.jobRuns[i].Status = status
+			m.jobRuns[i].ErrorMsg = copyStr(errorMsg)
+		}
+	}
+	return nil
+}
+
+// GetLatestJobRun retrieves the most recent job run
+func (m *MemoryStore) GetLatestJobRun() (*JobRun, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.jobRuns) == 0 {
+		return nil, nil
+	}
+	jr := m.jobRuns[len(m.jobRuns)-1]
+	return &jr, nil
+}
+
+// CreateCategoryRun creates a new category run record
+func (m *MemoryStore) CreateCategoryRun(jobID int64, label, catType string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := int64(len(m.categoryRuns) + 1)
+	m.categoryRuns = append(m.categoryRuns, CategoryRun{
+		ID:     id,
+		JobID:  jobID,
+		Label:  label,
+		Type:   catType,
+		Status: "running",
+	})
+	return id, nil
+}
+
+// UpdateCategoryRun updates a category run record
+func (m *MemoryStore) UpdateCategoryRun(id int64, status string, paths map[string]*string, errorMsg *string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.categoryRuns {
+		cr := &m.categoryRuns[i]
+		if cr.ID != id {
+			continue
+		}
+		cr.Status = status
+		cr.RawJSONPath = copyStr(paths["raw_json"])
+		cr.ResolvedJSONPath = copyStr(paths["resolved_json"])
+		cr.PMMMovieYAMLPath = copyStr(paths["pmm_movie"])
+		cr.PMMTVYAMLPath = copyStr(paths["pmm_tv"])
+		cr.ErrorMsg = copyStr(errorMsg)
+	}
+	return nil
+}
+
+// RecordCategoryRunSettings records the recommender a category run used
+func (m *MemoryStore) RecordCategoryRunSettings(id int64, model, provider, settingsJSON string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.categoryRuns {
+		cr := &m.categoryRuns[i]
+		if cr.ID == id {
+			cr.Model = model
+			cr.Provider = provider
+			cr.SettingsJSON = copyStr(&settingsJSON)
+		}
+	}
+	return nil
+}
+
+// RecordCategoryRunVariant tags a category run with its experiment variant
+func (m *MemoryStore) RecordCategoryRunVariant(id int64, experiment, variant string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.categoryRuns {
+		cr := &m.categoryRuns[i]
+		if cr.ID == id {
+			cr.Experiment = experiment
+			cr.Variant = variant
+			return nil
+		}
+	}
+	return nil
+}
+
+// CountExperimentRuns counts a category's runs tagged with an experiment
+func (m *MemoryStore) CountExperimentRuns(label, experiment string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, cr := range m.categoryRuns {
+		if cr.Label == label && cr.Experiment == experiment {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// RecordCategoryRunPrompt stores the prompt template a category run used
+func (m *MemoryStore) RecordCategoryRunPrompt(id int64, template, version string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.categoryRuns {
+		cr := &m.categoryRuns[i]
+		if cr.ID == id {
+			cr.PromptTemplate = template
+			cr.PromptVersion = version
+			return nil
+		}
+	}
+	return nil
+}
+
+// GetCategoryRunsByJobID retrieves all category runs for a job
+func (m *MemoryStore) GetCategoryRunsByJobID(jobID int64) ([]CategoryRun, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var runs []CategoryRun
+	for _, cr := range m.categoryRuns {
+		if cr.JobID == jobID {
+			runs = append(runs, cr)
+		}
+	}
+	return runs, nil
+}
+
+// GetLatestCategoryRun retrieves the most recent category run for a label
+func (m *MemoryStore) GetLatestCategoryRun(label string) (*CategoryRun, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.categoryRuns) - 1; i >= 0; i-- {
+		if m.categoryRuns[i].Label == label {
+			cr := m.categoryRuns[i]
+			return &cr, nil
+		}
+	}
+	return nil, nil
+}
+
+// RecordRecommendation records or updates a recommendation in history
+func (m *MemoryStore) RecordRecommendation(label string, tmdbID int, mediaType string, catRunID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	key := historyKey{label: label, tmdbID: tmdbID, mediaType: mediaType}
+	if entry, ok := m.history[key]; ok {
+		entry.lastSeenAt = now
+		return nil
+	}
+	entry := &historyEntry{firstSeenAt: now, lastSeenAt: now, catRunID: catRunID}
+	for _, cr := range m.categoryRuns {
+		if cr.ID == catRunID {
+			entry.experiment = cr.Experiment
+			entry.variant = cr.Variant
+		}
+	}
+	m.history[key] = entry
+	return nil
+}
+
+// GetRecommendationsSince retrieves recommendations seen since a given date
+func (m *MemoryStore) GetRecommendationsSince(label string, since time.Time) (map[int]bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make(map[int]bool)
+	for key, entry := range m.history {
+		if key.label == label && !entry.lastSeenAt.Before(since) {
+			result[key.tmdbID] = true
+		}
+	}
+	return result, nil
+}
+
+// GetRecommendationLabelsSince returns the labels that recommended each
+// title since a given date
+func (m *MemoryStore) GetRecommendationLabelsSince(since time.Time) (map[int][]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make(map[int][]string)
+	for key, entry := range m.history {
+		if !entry.lastSeenAt.Before(since) {
+			result[key.tmdbID] = append(result[key.tmdbID], key.label)
+		}
+	}
+	return result, nil
+}
+
+// CacheTitleResolution stores a title resolution in cache
+func (m *MemoryStore) CacheTitleResolution(tr *TitleResolution) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.resolutions = append(m.resolutions, *tr)
+	return nil
+}
+
+// GetTitleResolution retrieves a cached title resolution
+func (m *MemoryStore) GetTitleResolution(title string, year int, mediaType string) (*TitleResolution, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.resolutions) - 1; i >= 0; i-- {
+		tr := m.resolutions[i]
+		if tr.Title == title && tr.Year == year && tr.MediaType == mediaType {
+			return &tr, nil
+		}
+	}
+	return nil, nil
+}
+
+// ReplacePlexInventory replaces a server's Plex inventory snapshot
+func (m *MemoryStore) ReplacePlexInventory(server string, items []InventoryItem) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key := range m.inventory {
+		if key.server == server {
+			delete(m.inventory, key)
+		}
+	}
+	for _, item := range items {
+		m.inventory[inventoryKey{item.Server, item.RatingKey}] = item
+	}
+	return nil
+}
+
+// UpsertPlexInventory inserts or updates inventory items by rating key
+func (m *MemoryStore) UpsertPlexInventory(items []InventoryItem) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, item := range items {
+		m.inventory[inventoryKey{item.Server, item.RatingKey}] = item
+	}
+	return nil
+}
+
+// IsInPlexInventory checks if a TMDb ID is in Plex inventory, optionally
+// limited to a set of servers/sections
+func (m *MemoryStore) IsInPlexInventory(tmdbID int, mediaType string, scope *InventoryScope) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, item := range m.inventory {
+		if item.TMDbID == tmdbID && item.MediaType == mediaType && scope.Matches(item) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// FindPlexInventory returns the library items matching a TMDb ID, optionally
+// limited to a set of servers/sections
+func (m *MemoryStore) FindPlexInventory(tmdbID int, mediaType string, scope *InventoryScope) ([]InventoryItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var items []InventoryItem
+	for _, item := range m.inventory {
+		if item.TMDbID == tmdbID && item.MediaType == mediaType && scope.Matches(item) {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if a.Server != b.Server {
+			return a.Server < b.Server
+		}
+		if a.SectionKey != b.SectionKey {
+			return a.SectionKey < b.SectionKey
+		}
+		return a.RatingKey < b.RatingKey
+	})
+	return items, nil
+}
+
+// ListPlexInventory returns every inventory item inside scope, most recently
+// added first
+func (m *MemoryStore) ListPlexInventory(scope *InventoryScope) ([]InventoryItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var items []InventoryItem
+	for _, item := range m.inventory {
+		if scope.Matches(item) {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if a.AddedAt != b.AddedAt {
+			return a.AddedAt > b.AddedAt
+		}
+		if a.Server != b.Server {
+			return a.Server < b.Server
+		}
+		return a.RatingKey < b.RatingKey
+	})
+	return items, nil
+}
+
+// GetPlexInventoryCache retrieves TMDb IDs by rating key for a server
+func (m *MemoryStore) GetPlexInventoryCache(server string) (map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cache := make(map[string]int)
+	for _, item := range m.inventory {
+		if item.Server == server && item.RatingKey != "" && item.TMDbID > 0 {
+			cache[item.RatingKey] = item.TMDbID
+		}
+	}
+	return cache, nil
+}
+
+// GetPlexSyncState retrieves the sync state for a server, or nil if never synced
+func (m *MemoryStore) GetPlexSyncState(server string) (*PlexSyncState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st, ok := m.syncStates[server]
+	if !ok {
+		return nil, nil
+	}
+	return &st, nil
+}
+
+// SavePlexSyncState records the sync state for a server
+func (m *MemoryStore) SavePlexSyncState(st *PlexSyncState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.syncStates[st.Server] = *st
+	return nil
+}
+
+// PrunePlexServers removes the inventory, sync state and ratings of every
+// server not in servers
+func (m *MemoryStore) PrunePlexServers(servers []string) (int64, error) {
+	if len(servers) == 0 {
+		return 0, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keep := make(map[string]bool, len(servers))
+	for _, server := range servers {
+		keep[server] = true
+	}
+	var removed int64
+	for key := range m.inventory {
+		if !keep[key.server] {
+			delete(m.inventory, key)
+			removed++
+		}
+	}
+	for server := range m.syncStates {
+		if !keep[server] {
+			delete(m.syncStates, server)
+		}
+	}
+	var ratings []UserRating
+	for _, r := range m.ratings {
+		if keep[r.Server] {
+			ratings = append(ratings, r)
+		}
+	}
+	m.ratings = ratings
+	return removed, nil
+}
+
+// ReplaceUserRatings replaces a user's ratings on one server
+func (m *MemoryStore) ReplaceUserRatings(server, user string, ratings []UserRating) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var kept []UserRating
+	for _, r := range m.ratings {
+		if r.Server != server || r.User != user {
+			kept = append(kept, r)
+		}
+	}
+	for _, r := range ratings {
+		r.Server = server
+		r.User = user
+		r.RatedAt = r.RatedAt.UTC().Truncate(time.Second)
+		kept = append(kept, r)
+	}
+	m.ratings = kept
+	return nil
+}
+
+// GetUserRatings retrieves a user's ratings across servers, most recent first
+func (m *MemoryStore) GetUserRatings(user string) ([]UserRating, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ratings []UserRating
+	for _, r := range m.ratings {
+		if r.User == user {
+			ratings = append(ratings, r)
+		}
+	}
+	sort.SliceStable(ratings, func(i, j int) bool { return ratings[i].RatedAt.After(ratings[j].RatedAt) })
+	return ratings, nil
+}
+
+// ReplaceWatchlist replaces a user's watchlist snapshot
+func (m *MemoryStore) ReplaceWatchlist(user string, items []WatchlistEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := make([]WatchlistEntry, len(items))
+	for i, w := range items {
+		w.User = user
+		w.AddedAt = w.AddedAt.UTC().Truncate(time.Second)
+		entries[i] = w
+	}
+	m.watchlists[user] = entries
+	return nil
+}
+
+// GetWatchlist retrieves a user's watchlist, most recently added first
+func (m *MemoryStore) GetWatchlist(user string) ([]WatchlistEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := append([]WatchlistEntry(nil), m.watchlists[user]...)
+	sort.SliceStable(items, func(i, j int) bool { return items[i].AddedAt.After(items[j].AddedAt) })
+	return items, nil
+}
+
+// RecordWatchEvent stores a webhook event and returns its id
+func (m *MemoryStore) RecordWatchEvent(e *WatchEvent) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e.ID = int64(len(m.events) + 1)
+	stored := *e
+	stored.OccurredAt = e.OccurredAt.UTC().Truncate(time.Second)
+	m.events = append(m.events, stored)
+	return e.ID, nil
+}
+
+// GetWatchEventsSince retrieves events at or after since, oldest first. An
+// empty source matches every source.
+func (m *MemoryStore) GetWatchEventsSince(since time.Time, source string) ([]WatchEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	since = since.UTC().Truncate(time.Second)
+	var events []WatchEvent
+	for _, e := range m.events {
+		if e.OccurredAt.Before(since) || (source != "" && e.Source != source) {
+			continue
+		}
+		events = append(events, e)
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].OccurredAt.Before(events[j].OccurredAt) })
+	return events, nil
+}
+
+// RecordFeedback stores feedback and returns its id
+func (m *MemoryStore) RecordFeedback(f *Feedback) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f.ID = int64(len(m.feedback) + 1)
+	stored := *f
+	stored.CreatedAt = f.CreatedAt.UTC().Truncate(time.Second)
+	m.feedback = append(m.feedback, stored)
+	return f.ID, nil
+}
+
+// ListFeedback retrieves feedback, newest first. Empty label or user match
+// every label or user.
+func (m *MemoryStore) ListFeedback(label, user string) ([]Feedback, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var feedback []Feedback
+	for i := len(m.feedback) - 1; i >= 0; i-- {
+		f := m.feedback[i]
+		if (label != "" && f.Label != label) || (user != "" && !strings.EqualFold(f.User, user)) {
+			continue
+		}
+		feedback = append(feedback, f)
+	}
+	sort.SliceStable(feedback, func(i, j int) bool { return feedback[i].CreatedAt.After(feedback[j].CreatedAt) })
+	return feedback, nil
+}
+
+// ListRecommendationsSince retrieves the recommendations first made at or
+// after since, oldest first
+func (m *MemoryStore) ListRecommendationsSince(since time.Time) ([]RecommendationRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	models := make(map[int64]string, len(m.categoryRuns))
+	for _, cr := range m.categoryRuns {
+		models[cr.ID] = cr.Model
+	}
+
+	var records []RecommendationRecord
+	for key, entry := range m.history {
+		if entry.firstSeenAt.Before(since) {
+			continue
+		}
+		records = append(records, RecommendationRecord{
+			Label:         key.label,
+			TMDbID:        key.tmdbID,
+			MediaType:     key.mediaType,
+			FirstSeenAt:   entry.firstSeenAt,
+			CategoryRunID: entry.catRunID,
+			Model:         models[entry.catRunID],
+			Experiment:    entry.experiment,
+			Variant:       entry.variant,
+		})
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].FirstSeenAt.Before(records[j].FirstSeenAt) })
+	return records, nil
+}
+
+// SaveEffectiveness replaces the stats computed for a job
+func (m *MemoryStore) SaveEffectiveness(jobID int64, stats []EffectivenessStat) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved := make([]EffectivenessStat, len(stats))
+	for i, st := range stats {
+		st.JobID = jobID
+		st.ComputedAt = st.ComputedAt.UTC().Truncate(time.Second)
+		saved[i] = st
+	}
+	sort.SliceStable(saved, func(i, j int) bool {
+		if saved[i].Scope != saved[j].Scope {
+			return saved[i].Scope < saved[j].Scope
+		}
+		if saved[i].Experiment != saved[j].Experiment {
+			return saved[i].Experiment < saved[j].Experiment
+		}
+		return saved[i].Name < saved[j].Name
+	})
+	m.stats[jobID] = saved
+	return nil
+}
+
+// GetEffectiveness retrieves the stats computed for a job, or for the latest
+// job that computed any when jobID is zero
+func (m *MemoryStore) GetEffectiveness(jobID int64) ([]EffectivenessStat, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if jobID == 0 {
+		for id := range m.stats {
+			if id > jobID {
+				jobID = id
+			}
+		}
+	}
+	return append([]EffectivenessStat(nil), m.stats[jobID]...), nil
+}
+
+// SyncDynamicCategories records the categories a dynamic parent generated
+// this run and retires the ones it generated before but no longer does. It
+// returns the newly retired categories.
+func (m *MemoryStore) SyncDynamicCategories(parentLabel string, active []DynamicCategory) ([]DynamicCategory, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	categories := m.dynamic[parentLabel]
+	if categories == nil {
+		categories = make(map[string]DynamicCategory)
+		m.dynamic[parentLabel] = categories
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range active {
+		existing, ok := categories[c.Label]
+		c.ParentLabel = parentLabel
+		c.FirstSeenAt = now
+		if ok {
+			c.FirstSeenAt = existing.FirstSeenAt
+		}
+		c.LastSeenAt = now
+		c.RetiredAt = nil
+		categories[c.Label] = c
+		seen[c.Label] = true
+	}
+
+	var retired []DynamicCategory
+	for label, c := range categories {
+		if seen[label] || c.RetiredAt != nil {
+			continue
+		}
+		retiredAt := now
+		c.RetiredAt = &retiredAt
+		categories[label] = c
+		retired = append(retired, c)
+	}
+	return retired, nil
+}
+
+func copyStr(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	v := *s
+	return &v
+}
@@ -0,0 +1,547 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migration is a versioned schema change with per-dialect statements.
+// before, when set, runs first in the same transaction, for upgrades the
+// statements alone can't express.
+type migration struct {
+	version  int
+	name     string
+	sqlite   string
+	postgres string
+	before   func(tx *sql.Tx, d dialect) error
+}
+
+// migrations lists every schema change in order. Append new entries; never
+// edit one that has shipped.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "baseline",
+		before:  addLegacyRatingKey,
+		sqlite: `
+		CREATE TABLE IF NOT EXISTS job_run (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			started_at TEXT NOT NULL,
+			finished_at TEXT,
+			mode TEXT NOT NULL,
+			status TEXT NOT NULL,
+			error_msg TEXT
+		);
+
+		CREATE TABLE IF NOT EXISTS category_run (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			job_id INTEGER NOT NULL REFERENCES job_run(id),
+			label TEXT NOT NULL,
+			type TEXT NOT NULL,
+			raw_json_path TEXT,
+			resolved_json_path TEXT,
+			pmm_movie_yaml_path TEXT,
+			pmm_tv_yaml_path TEXT,
+			status TEXT NOT NULL,
+			error_msg TEXT
+		);
+
+		CREATE TABLE IF NOT EXISTS recommendation_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			label TEXT NOT NULL,
+			tmdb_id INTEGER NOT NULL,
+			media_type TEXT CHECK (media_type IN ('movie','tv')),
+			first_seen_at TEXT NOT NULL,
+			last_seen_at TEXT NOT NULL
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS ix_history_label_tmdb ON recommendation_history(label, tmdb_id, media_type);
+
+		CREATE TABLE IF NOT EXISTS title_resolution_cache (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			title TEXT NOT NULL,
+			year INTEGER,
+			media_type TEXT CHECK (media_type IN ('movie','tv')),
+			tmdb_id INTEGER,
+			imdb_id TEXT,
+			country TEXT,
+			runtime_min INTEGER,
+			resolved_at TEXT NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS plex_inventory (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			rating_key TEXT NOT NULL,
+			tmdb_id INTEGER NOT NULL,
+			media_type TEXT CHECK (media_type IN ('movie','tv')),
+			present_at TEXT NOT NULL
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS ix_inventory_tmdb ON plex_inventory(tmdb_id, media_type);
+		CREATE UNIQUE INDEX IF NOT EXISTS ix_inventory_ratingkey ON plex_inventory(rating_key);
+		`,
+		postgres: `
+		CREATE TABLE IF NOT EXISTS job_run (
+			id BIGSERIAL PRIMARY KEY,
+			started_at TEXT NOT NULL,
+			finished_at TEXT,
+			mode TEXT NOT NULL,
+			status TEXT NOT NULL,
+			error_msg TEXT
+		);
+
+		CREATE TABLE IF NOT EXISTS category_run (
+			id BIGSERIAL PRIMARY KEY,
+			job_id BIGINT NOT NULL REFERENCES job_run(id),
+			label TEXT NOT NULL,
+			type TEXT NOT NULL,
+			raw_json_path TEXT,
+			resolved_json_path TEXT,
+			pmm_movie_yaml_path TEXT,
+			pmm_tv_yaml_path TEXT,
+			status TEXT NOT NULL,
+			error_msg TEXT
+		);
+
+		CREATE TABLE IF NOT EXISTS recommendation_history (
+			id BIGSERIAL PRIMARY KEY,
+			label TEXT NOT NULL,
+			tmdb_id INTEGER NOT NULL,
+			media_type TEXT CHECK (media_type IN ('movie','tv')),
+			first_seen_at TEXT NOT NULL,
+			last_seen_at TEXT NOT NULL
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS ix_history_label_tmdb ON recommendation_history(label, tmdb_id, media_type);
+
+		CREATE TABLE IF NOT EXISTS title_resolution_cache (
+			id BIGSERIAL PRIMARY KEY,
+			title TEXT NOT NULL,
+			year INTEGER,
+			media_type TEXT CHECK (media_type IN ('movie','tv')),
+			tmdb_id INTEGER,
+			imdb_id TEXT,
+			country TEXT,
+			runtime_min INTEGER,
+			resolved_at TEXT NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS plex_inventory (
+			id BIGSERIAL PRIMARY KEY,
+			rating_key TEXT NOT NULL,
+			tmdb_id INTEGER NOT NULL,
+			media_type TEXT CHECK (media_type IN ('movie','tv')),
+			present_at TEXT NOT NULL
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS ix_inventory_tmdb ON plex_inventory(tmdb_id, media_type);
+		CREATE UNIQUE INDEX IF NOT EXISTS ix_inventory_ratingkey ON plex_inventory(rating_key);
+		`,
+	},
+	{
+		version: 2,
+		name:    "incremental_inventory",
+		sqlite: `
+		ALTER TABLE plex_inventory ADD COLUMN title TEXT NOT NULL DEFAULT '';
+		ALTER TABLE plex_inventory ADD COLUMN year INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE plex_inventory ADD COLUMN added_at INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE plex_inventory ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0;
+		DROP INDEX IF EXISTS ix_inventory_tmdb;
+		CREATE INDEX IF NOT EXISTS ix_inventory_tmdb ON plex_inventory(tmdb_id, media_type);
+
+		CREATE TABLE IF NOT EXISTS plex_sync_state (
+			server TEXT PRIMARY KEY,
+			last_sync_at TEXT NOT NULL,
+			last_full_sync_at TEXT
+		);
+		`,
+		postgres: `
+		ALTER TABLE plex_inventory ADD COLUMN title TEXT NOT NULL DEFAULT '';
+		ALTER TABLE plex_inventory ADD COLUMN year INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE plex_inventory ADD COLUMN added_at BIGINT NOT NULL DEFAULT 0;
+		ALTER TABLE plex_inventory ADD COLUMN updated_at BIGINT NOT NULL DEFAULT 0;
+		DROP INDEX IF EXISTS ix_inventory_tmdb;
+		CREATE INDEX IF NOT EXISTS ix_inventory_tmdb ON plex_inventory(tmdb_id, media_type);
+
+		CREATE TABLE IF NOT EXISTS plex_sync_state (
+			server TEXT PRIMARY KEY,
+			last_sync_at TEXT NOT NULL,
+			last_full_sync_at TEXT
+		);
+		`,
+	},
+	{
+		version: 3,
+		name:    "multi_server_inventory",
+		sqlite: `
+		ALTER TABLE plex_inventory ADD COLUMN server TEXT NOT NULL DEFAULT 'default';
+		ALTER TABLE plex_inventory ADD COLUMN section_key TEXT NOT NULL DEFAULT '';
+		ALTER TABLE plex_inventory ADD COLUMN section_title TEXT NOT NULL DEFAULT '';
+		DROP INDEX IF EXISTS ix_inventory_ratingkey;
+		CREATE UNIQUE INDEX IF NOT EXISTS ix_inventory_server_ratingkey ON plex_inventory(server, rating_key);
+		`,
+		postgres: `
+		ALTER TABLE plex_inventory ADD COLUMN server TEXT NOT NULL DEFAULT 'default';
+		ALTER TABLE plex_inventory ADD COLUMN section_key TEXT NOT NULL DEFAULT '';
+		ALTER TABLE plex_inventory ADD COLUMN section_title TEXT NOT NULL DEFAULT '';
+		DROP INDEX IF EXISTS ix_inventory_ratingkey;
+		CREATE UNIQUE INDEX IF NOT EXISTS ix_inventory_server_ratingkey ON plex_inventory(server, rating_key);
+		`,
+	},
+	{
+		version: 4,
+		name:    "plex_taste_signals",
+		sqlite: `
+		CREATE TABLE IF NOT EXISTS plex_user_rating (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			server TEXT NOT NULL,
+			user_name TEXT NOT NULL,
+			rating_key TEXT NOT NULL,
+			tmdb_id INTEGER NOT NULL DEFAULT 0,
+			media_type TEXT CHECK (media_type IN ('movie','tv')),
+			title TEXT NOT NULL,
+			year INTEGER NOT NULL DEFAULT 0,
+			rating REAL NOT NULL,
+			rated_at TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS ix_user_rating_user ON plex_user_rating(user_name, server);
+
+		CREATE TABLE IF NOT EXISTS plex_watchlist (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_name TEXT NOT NULL,
+			guid TEXT NOT NULL,
+			tmdb_id INTEGER NOT NULL DEFAULT 0,
+			media_type TEXT CHECK (media_type IN ('movie','tv')),
+			title TEXT NOT NULL,
+			year INTEGER NOT NULL DEFAULT 0,
+			added_at TEXT
+		);
+		CREATE INDEX IF NOT EXISTS ix_watchlist_user ON plex_watchlist(user_name);
+		`,
+		postgres: `
+		CREATE TABLE IF NOT EXISTS plex_user_rating (
+			id BIGSERIAL PRIMARY KEY,
+			server TEXT NOT NULL,
+			user_name TEXT NOT NULL,
+			rating_key TEXT NOT NULL,
+			tmdb_id INTEGER NOT NULL DEFAULT 0,
+			media_type TEXT CHECK (media_type IN ('movie','tv')),
+			title TEXT NOT NULL,
+			year INTEGER NOT NULL DEFAULT 0,
+			rating DOUBLE PRECISION NOT NULL,
+			rated_at TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS ix_user_rating_user ON plex_user_rating(user_name, server);
+
+		CREATE TABLE IF NOT EXISTS plex_watchlist (
+			id BIGSERIAL PRIMARY KEY,
+			user_name TEXT NOT NULL,
+			guid TEXT NOT NULL,
+			tmdb_id INTEGER NOT NULL DEFAULT 0,
+			media_type TEXT CHECK (media_type IN ('movie','tv')),
+			title TEXT NOT NULL,
+			year INTEGER NOT NULL DEFAULT 0,
+			added_at TEXT
+		);
+		CREATE INDEX IF NOT EXISTS ix_watchlist_user ON plex_watchlist(user_name);
+		`,
+	},
+	{
+		version: 5,
+		name:    "watch_events",
+		sqlite: `
+		CREATE TABLE IF NOT EXISTS watch_event (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			source TEXT NOT NULL,
+			event TEXT NOT NULL,
+			user_name TEXT NOT NULL,
+			rating_key TEXT NOT NULL DEFAULT '',
+			parent_rating_key TEXT NOT NULL DEFAULT '',
+			grandparent_rating_key TEXT NOT NULL DEFAULT '',
+			media_type TEXT NOT NULL DEFAULT '',
+			title TEXT NOT NULL DEFAULT '',
+			grandparent_title TEXT NOT NULL DEFAULT '',
+			year INTEGER NOT NULL DEFAULT 0,
+			guid TEXT NOT NULL DEFAULT '',
+			tmdb_id INTEGER NOT NULL DEFAULT 0,
+			imdb_id TEXT NOT NULL DEFAULT '',
+			tvdb_id INTEGER NOT NULL DEFAULT 0,
+			percent_complete INTEGER NOT NULL DEFAULT 0,
+			rating REAL NOT NULL DEFAULT 0,
+			occurred_at TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS ix_watch_event_occurred ON watch_event(occurred_at);
+		CREATE INDEX IF NOT EXISTS ix_watch_event_user ON watch_event(user_name, occurred_at);
+		`,
+		postgres: `
+		CREATE TABLE IF NOT EXISTS watch_event (
+			id BIGSERIAL PRIMARY KEY,
+			source TEXT NOT NULL,
+			event TEXT NOT NULL,
+			user_name TEXT NOT NULL,
+			rating_key TEXT NOT NULL DEFAULT '',
+			parent_rating_key TEXT NOT NULL DEFAULT '',
+			grandparent_rating_key TEXT NOT NULL DEFAULT '',
+			media_type TEXT NOT NULL DEFAULT '',
+			title TEXT NOT NULL DEFAULT '',
+			grandparent_title TEXT NOT NULL DEFAULT '',
+			year INTEGER NOT NULL DEFAULT 0,
+			guid TEXT NOT NULL DEFAULT '',
+			tmdb_id INTEGER NOT NULL DEFAULT 0,
+			imdb_id TEXT NOT NULL DEFAULT '',
+			tvdb_id INTEGER NOT NULL DEFAULT 0,
+			percent_complete INTEGER NOT NULL DEFAULT 0,
+			rating DOUBLE PRECISION NOT NULL DEFAULT 0,
+			occurred_at TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS ix_watch_event_occurred ON watch_event(occurred_at);
+		CREATE INDEX IF NOT EXISTS ix_watch_event_user ON watch_event(user_name, occurred_at);
+		`,
+	},
+	{
+		version: 6,
+		name:    "dynamic_categories",
+		sqlite: `
+		CREATE TABLE IF NOT EXISTS dynamic_category (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			parent_label TEXT NOT NULL,
+			label TEXT NOT NULL,
+			seed_title TEXT NOT NULL,
+			seed_year INTEGER NOT NULL DEFAULT 0,
+			seed_medium TEXT NOT NULL DEFAULT '',
+			first_seen_at TEXT NOT NULL,
+			last_seen_at TEXT NOT NULL,
+			retired_at TEXT,
+			UNIQUE(parent_label, label)
+		);
+		`,
+		postgres: `
+		CREATE TABLE IF NOT EXISTS dynamic_category (
+			id BIGSERIAL PRIMARY KEY,
+			parent_label TEXT NOT NULL,
+			label TEXT NOT NULL,
+			seed_title TEXT NOT NULL,
+			seed_year INTEGER NOT NULL DEFAULT 0,
+			seed_medium TEXT NOT NULL DEFAULT '',
+			first_seen_at TEXT NOT NULL,
+			last_seen_at TEXT NOT NULL,
+			retired_at TEXT,
+			UNIQUE(parent_label, label)
+		);
+		`,
+	},
+	{
+		version: 7,
+		name:    "category_run_recommender",
+		sqlite: `
+		ALTER TABLE category_run ADD COLUMN model TEXT NOT NULL DEFAULT '';
+		ALTER TABLE category_run ADD COLUMN provider TEXT NOT NULL DEFAULT '';
+		ALTER TABLE category_run ADD COLUMN settings_json TEXT;
+		`,
+		postgres: `
+		ALTER TABLE category_run ADD COLUMN IF NOT EXISTS model TEXT NOT NULL DEFAULT '';
+		ALTER TABLE category_run ADD COLUMN IF NOT EXISTS provider TEXT NOT NULL DEFAULT '';
+		ALTER TABLE category_run ADD COLUMN IF NOT EXISTS settings_json TEXT;
+		`,
+	},
+	{
+		version: 8,
+		name:    "recommendation_feedback",
+		sqlite: `
+		CREATE TABLE IF NOT EXISTS recommendation_feedback (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			label TEXT NOT NULL,
+			tmdb_id INTEGER NOT NULL,
+			media_type TEXT NOT NULL,
+			title TEXT NOT NULL DEFAULT '',
+			year INTEGER NOT NULL DEFAULT 0,
+			user_name TEXT NOT NULL DEFAULT '',
+			feedback TEXT NOT NULL,
+			note TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS ix_recommendation_feedback_created ON recommendation_feedback(created_at);
+		CREATE INDEX IF NOT EXISTS ix_recommendation_feedback_label ON recommendation_feedback(label, created_at);
+		`,
+		postgres: `
+		CREATE TABLE IF NOT EXISTS recommendation_feedback (
+			id BIGSERIAL PRIMARY KEY,
+			label TEXT NOT NULL,
+			tmdb_id INTEGER NOT NULL,
+			media_type TEXT NOT NULL,
+			title TEXT NOT NULL DEFAULT '',
+			year INTEGER NOT NULL DEFAULT 0,
+			user_name TEXT NOT NULL DEFAULT '',
+			feedback TEXT NOT NULL,
+			note TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS ix_recommendation_feedback_created ON recommendation_feedback(created_at);
+		CREATE INDEX IF NOT EXISTS ix_recommendation_feedback_label ON recommendation_feedback(label, created_at);
+		`,
+	},
+	{
+		version: 9,
+		name:    "recommendation_effectiveness",
+		sqlite: `
+		ALTER TABLE recommendation_history ADD COLUMN category_run_id INTEGER;
+		CREATE TABLE IF NOT EXISTS effectiveness_stat (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			job_id INTEGER NOT NULL REFERENCES job_run(id),
+			scope TEXT NOT NULL,
+			name TEXT NOT NULL,
+			recommended INTEGER NOT NULL,
+			acquired INTEGER NOT NULL,
+			watched INTEGER NOT NULL,
+			completed INTEGER NOT NULL,
+			computed_at TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS ix_effectiveness_stat_job ON effectiveness_stat(job_id);
+		`,
+		postgres: `
+		ALTER TABLE recommendation_history ADD COLUMN IF NOT EXISTS category_run_id BIGINT;
+		CREATE TABLE IF NOT EXISTS effectiveness_stat (
+			id BIGSERIAL PRIMARY KEY,
+			job_id BIGINT NOT NULL REFERENCES job_run(id),
+			scope TEXT NOT NULL,
+			name TEXT NOT NULL,
+			recommended INTEGER NOT NULL,
+			acquired INTEGER NOT NULL,
+			watched INTEGER NOT NULL,
+			completed INTEGER NOT NULL,
+			computed_at TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS ix_effectiveness_stat_job ON effectiveness_stat(job_id);
+		`,
+	},
+	{
+		version: 10,
+		name:    "experiments",
+		sqlite: `
+		ALTER TABLE category_run ADD COLUMN experiment TEXT NOT NULL DEFAULT '';
+		ALTER TABLE category_run ADD COLUMN variant TEXT NOT NULL DEFAULT '';
+		ALTER TABLE recommendation_history ADD COLUMN experiment TEXT NOT NULL DEFAULT '';
+		ALTER TABLE recommendation_history ADD COLUMN variant TEXT NOT NULL DEFAULT '';
+		ALTER TABLE effectiveness_stat ADD COLUMN experiment TEXT NOT NULL DEFAULT '';
+		`,
+		postgres: `
+		ALTER TABLE category_run ADD COLUMN IF NOT EXISTS experiment TEXT NOT NULL DEFAULT '';
+		ALTER TABLE category_run ADD COLUMN IF NOT EXISTS variant TEXT NOT NULL DEFAULT '';
+		ALTER TABLE recommendation_history ADD COLUMN IF NOT EXISTS experiment TEXT NOT NULL DEFAULT '';
+		ALTER TABLE recommendation_history ADD COLUMN IF NOT EXISTS variant TEXT NOT NULL DEFAULT '';
+		ALTER TABLE effectiveness_stat ADD COLUMN IF NOT EXISTS experiment TEXT NOT NULL DEFAULT '';
+		`,
+	},
+	{
+		version: 11,
+		name:    "category_run_prompt",
+		sqlite: `
+		ALTER TABLE category_run ADD COLUMN prompt_template TEXT NOT NULL DEFAULT '';
+		ALTER TABLE category_run ADD COLUMN prompt_version TEXT NOT NULL DEFAULT '';
+		`,
+		postgres: `
+		ALTER TABLE category_run ADD COLUMN IF NOT EXISTS prompt_template TEXT NOT NULL DEFAULT '';
+		ALTER TABLE category_run ADD COLUMN IF NOT EXISTS prompt_version TEXT NOT NULL DEFAULT '';
+		`,
+	},
+}
+
+// migrate applies any migrations newer than the recorded schema version
+func (s *Store) migrate() error {
+	if _, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TEXT NOT NULL
+		)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	var current int
+	if err := s.queryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		stmt := m.sqlite
+		if s.dialect == dialectPostgres {
+			stmt = m.postgres
+		}
+
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if m.before != nil {
+			if err := m.before(tx, s.dialect); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+			}
+		}
+		if stmt != "" {
+			if _, err := tx.Exec(stmt); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+			}
+		}
+		if _, err := tx.Exec(
+			s.dialect.rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"),
+			m.version, m.name, time.Now().UTC().Format(time.RFC3339),
+		); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		log.Info().Int("version", m.version).Str("name", m.name).Str("dialect", string(s.dialect)).Msg("applied migration")
+	}
+
+	return nil
+}
+
+// addLegacyRatingKey upgrades SQLite databases whose plex_inventory predates
+// rating keys by adding the column. Their rows can't be addressed in Plex and
+// would collide on the rating key index, so they are dropped; the next sync
+// refills the inventory.
+func addLegacyRatingKey(tx *sql.Tx, d dialect) error {
+	if d != dialectSQLite {
+		return nil
+	}
+
+	rows, err := tx.Query("PRAGMA table_info(plex_inventory)")
+	if err != nil {
+		return err
+	}
+	var columns int
+	hasRatingKey := false
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		columns++
+		if name == "rating_key" {
+			hasRatingKey = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// No table yet, or already upgraded
+	if columns == 0 || hasRatingKey {
+		return nil
+	}
+	_, err = tx.Exec(`
+		ALTER TABLE plex_inventory ADD COLUMN rating_key TEXT NOT NULL DEFAULT '';
+		DELETE FROM plex_inventory;
+	`)
+	return err
+}
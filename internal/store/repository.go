@@ -0,0 +1,185 @@
+package store
+
+import (
+	"strings"
+	"time"
+)
+
+// RunRepository tracks job runs and their per-category runs
+type RunRepository interface {
+	CreateJobRun(mode string) (int64, error)
+	UpdateJobRun(id int64, status string, errorMsg *string) error
+	GetLatestJobRun() (*JobRun, error)
+	CreateCategoryRun(jobID int64, label, catType string) (int64, error)
+	UpdateCategoryRun(id int64, status string, paths map[string]*string, errorMsg *string) error
+	RecordCategoryRunSettings(id int64, model, provider, settingsJSON string) error
+	RecordCategoryRunVariant(id int64, experiment, variant string) error
+	CountExperimentRuns(label, experiment string) (int, error)
+	RecordCategoryRunPrompt(id int64, template, version string) error
+	GetCategoryRunsByJobID(jobID int64) ([]CategoryRun, error)
+	GetLatestCategoryRun(label string) (*CategoryRun, error)
+}
+
+// HistoryRepository tracks which titles have been recommended and when
+type HistoryRepository interface {
+	RecordRecommendation(label string, tmdbID int, mediaType string, catRunID int64) error
+	GetRecommendationsSince(label string, since time.Time) (map[int]bool, error)
+	GetRecommendationLabelsSince(since time.Time) (map[int][]string, error)
+}
+
+// InventoryRepository tracks the Plex library snapshot
+type InventoryRepository interface {
+	ReplacePlexInventory(server string, items []InventoryItem) error
+	UpsertPlexInventory(items []InventoryItem) error
+	IsInPlexInventory(tmdbID int, mediaType string, scope *InventoryScope) (bool, error)
+	FindPlexInventory(tmdbID int, mediaType string, scope *InventoryScope) ([]InventoryItem, error)
+	ListPlexInventory(scope *InventoryScope) ([]InventoryItem, error)
+	GetPlexInventoryCache(server string) (map[string]int, error)
+	GetPlexSyncState(server string) (*PlexSyncState, error)
+	SavePlexSyncState(st *PlexSyncState) error
+	PrunePlexServers(servers []string) (int64, error)
+}
+
+// SignalRepository stores explicit taste signals read from Plex
+type SignalRepository interface {
+	ReplaceUserRatings(server, user string, ratings []UserRating) error
+	GetUserRatings(user string) ([]UserRating, error)
+	ReplaceWatchlist(user string, items []WatchlistEntry) error
+	GetWatchlist(user string) ([]WatchlistEntry, error)
+}
+
+// WatchEventRepository stores playback events pushed by webhooks
+type WatchEventRepository interface {
+	RecordWatchEvent(e *WatchEvent) (int64, error)
+	GetWatchEventsSince(since time.Time, source string) ([]WatchEvent, error)
+}
+
+// FeedbackRepository stores user feedback on recommendations
+type FeedbackRepository interface {
+	RecordFeedback(f *Feedback) (int64, error)
+	ListFeedback(label, user string) ([]Feedback, error)
+}
+
+// EffectivenessRepository stores what became of past recommendations
+type EffectivenessRepository interface {
+	ListRecommendationsSince(since time.Time) ([]RecommendationRecord, error)
+	SaveEffectiveness(jobID int64, stats []EffectivenessStat) error
+	GetEffectiveness(jobID int64) ([]EffectivenessStat, error)
+}
+
+// DynamicCategoryRepository tracks the categories generated by dynamic
+// category types so aged-out ones can be retired
+type DynamicCategoryRepository interface {
+	SyncDynamicCategories(parentLabel string, active []DynamicCategory) ([]DynamicCategory, error)
+}
+
+// ResolutionCache caches title → TMDb resolutions
+type ResolutionCache interface {
+	CacheTitleResolution(tr *TitleResolution) error
+	GetTitleResolution(title string, year int, mediaType string) (*TitleResolution, error)
+}
+
+// Repository is the full set of persistence operations used by the worker
+type Repository interface {
+	RunRepository
+	HistoryRepository
+	InventoryRepository
+	SignalRepository
+	WatchEventRepository
+	FeedbackRepository
+	EffectivenessRepository
+	DynamicCategoryRepository
+	ResolutionCache
+	Close() error
+}
+
+// InventoryItem represents a single Plex library item stored in plex_inventory.
+// TMDbID is zero for items whose GUIDs carry no TMDb reference.
+type InventoryItem struct {
+	Server       string // configured Plex server name
+	SectionKey   string
+	SectionTitle string
+	RatingKey    string
+	TMDbID       int
+	MediaType    string
+	Title        string
+	Year         int
+	AddedAt      int64 // Plex addedAt (unix seconds)
+	UpdatedAt    int64 // Plex updatedAt (unix seconds)
+}
+
+// InventoryScope restricts inventory lookups to certain servers and sections.
+// Sections match by key or title. A nil or empty scope matches everything.
+type InventoryScope struct {
+	Servers         []string
+	IncludeSections []string
+	ExcludeSections []string
+}
+
+// Matches reports whether an inventory item falls inside the scope
+func (sc *InventoryScope) Matches(item InventoryItem) bool {
+	if sc == nil {
+		return true
+	}
+	if len(sc.Servers) > 0 && !containsFold(sc.Servers, item.Server) {
+		return false
+	}
+	inSection := func(names []string) bool {
+		return containsFold(names, item.SectionKey) || containsFold(names, item.SectionTitle)
+	}
+	if len(sc.IncludeSections) > 0 && !inSection(sc.IncludeSections) {
+		return false
+	}
+	return !inSection(sc.ExcludeSections)
+}
+
+// sqlClause renders the scope as an AND-able WHERE fragment with '?' args
+func (sc *InventoryScope) sqlClause() (string, []interface{}) {
+	if sc == nil {
+		return "", nil
+	}
+
+	var clauses []string
+	var args []interface{}
+	in := func(column string, values []string) string {
+		marks := make([]string, len(values))
+		for i, v := range values {
+			marks[i] = "?"
+			args = append(args, strings.ToLower(v))
+		}
+		return "LOWER(" + column + ") IN (" + strings.Join(marks, ", ") + ")"
+	}
+
+	if len(sc.Servers) > 0 {
+		clauses = append(clauses, in("server", sc.Servers))
+	}
+	if len(sc.IncludeSections) > 0 {
+		keys := in("section_key", sc.IncludeSections)
+		titles := in("section_title", sc.IncludeSections)
+		clauses = append(clauses, "("+keys+" OR "+titles+")")
+	}
+	if len(sc.ExcludeSections) > 0 {
+		keys := in("section_key", sc.ExcludeSections)
+		titles := in("section_title", sc.ExcludeSections)
+		clauses = append(clauses, "NOT ("+keys+" OR "+titles+")")
+	}
+
+	if len(clauses) == 0 {
+		return "", nil
+	}
+	return " AND " + strings.Join(clauses, " AND "), args
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	_ Repository = (*Store)(nil)
+	_ Repository = (*MemoryStore)(nil)
+)
@@ -0,0 +1,147 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// UserRating is a Plex star rating given by a user, on Plex's 0-10 scale
+type UserRating struct {
+	Server    string
+	User      string
+	RatingKey string
+	TMDbID    int
+	MediaType string
+	Title     string
+	Year      int
+	Rating    float64
+	RatedAt   time.Time
+}
+
+// WatchlistEntry is a title on a user's Plex Discover watchlist
+type WatchlistEntry struct {
+	User      string
+	GUID      string
+	TMDbID    int
+	MediaType string
+	Title     string
+	Year      int
+	AddedAt   time.Time
+}
+
+// ReplaceUserRatings replaces a user's ratings on one server
+func (s *Store) ReplaceUserRatings(server, user string, ratings []UserRating) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(s.dialect.rebind("DELETE FROM plex_user_rating WHERE server = ? AND user_name = ?"), server, user); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(s.dialect.rebind(
+		`INSERT INTO plex_user_rating
+		(server, user_name, rating_key, tmdb_id, media_type, title, year, rating, rated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, r := range ratings {
+		if _, err := stmt.Exec(server, user, r.RatingKey, r.TMDbID, r.MediaType, r.Title, r.Year,
+			r.Rating, r.RatedAt.UTC().Format(time.RFC3339)); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetUserRatings retrieves a user's ratings across servers, most recent first
+func (s *Store) GetUserRatings(user string) ([]UserRating, error) {
+	rows, err := s.query(
+		`SELECT server, user_name, rating_key, tmdb_id, media_type, title, year, rating, rated_at
+		FROM plex_user_rating WHERE user_name = ? ORDER BY rated_at DESC, id`,
+		user,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ratings []UserRating
+	for rows.Next() {
+		var r UserRating
+		var ratedAt string
+		if err := rows.Scan(&r.Server, &r.User, &r.RatingKey, &r.TMDbID, &r.MediaType, &r.Title, &r.Year,
+			&r.Rating, &ratedAt); err != nil {
+			return nil, err
+		}
+		r.RatedAt, _ = time.Parse(time.RFC3339, ratedAt)
+		ratings = append(ratings, r)
+	}
+	return ratings, rows.Err()
+}
+
+// ReplaceWatchlist replaces a user's watchlist snapshot
+func (s *Store) ReplaceWatchlist(user string, items []WatchlistEntry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(s.dialect.rebind("DELETE FROM plex_watchlist WHERE user_name = ?"), user); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(s.dialect.rebind(
+		`INSERT INTO plex_watchlist (user_name, guid, tmdb_id, media_type, title, year, added_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, w := range items {
+		var addedAt sql.NullString
+		if !w.AddedAt.IsZero() {
+			addedAt = sql.NullString{String: w.AddedAt.UTC().Format(time.RFC3339), Valid: true}
+		}
+		if _, err := stmt.Exec(user, w.GUID, w.TMDbID, w.MediaType, w.Title, w.Year, addedAt); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetWatchlist retrieves a user's watchlist, most recently added first
+func (s *Store) GetWatchlist(user string) ([]WatchlistEntry, error) {
+	rows, err := s.query(
+		`SELECT user_name, guid, tmdb_id, media_type, title, year, added_at
+		FROM plex_watchlist WHERE user_name = ? ORDER BY COALESCE(added_at, '') DESC, id`,
+		user,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []WatchlistEntry
+	for rows.Next() {
+		var w WatchlistEntry
+		var addedAt sql.NullString
+		if err := rows.Scan(&w.User, &w.GUID, &w.TMDbID, &w.MediaType, &w.Title, &w.Year, &addedAt); err != nil {
+			return nil, err
+		}
+		if addedAt.Valid {
+			w.AddedAt, _ = time.Parse(time.RFC3339, addedAt.String)
+		}
+		items = append(items, w)
+	}
+	return items, rows.Err()
+}
@@ -6,23 +6,53 @@ import (
 	"strings"
 	"time"
 
+	"github.com/dppeppel/scryarr/internal/logging"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/rs/zerolog"
 )
 
-// Store handles all database operations
+var log zerolog.Logger
+
+func init() {
+	log = logging.GetLogger("store")
+}
+
+// Store is the SQL-backed Repository. It speaks SQLite by default and
+// Postgres when opened with a postgres:// URL.
 type Store struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect dialect
 }
 
-// NewStore creates a new Store instance and initializes the schema
+// NewStore creates a new SQLite Store instance and initializes the schema
 func NewStore(dbPath string) (*Store, error) {
-	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL")
+	return open(dialectSQLite, dbPath)
+}
+
+// Open creates a Store from a db_url. postgres:// and postgresql:// URLs use
+// Postgres; sqlite:// URLs and bare file paths use SQLite.
+func Open(dbURL string) (*Store, error) {
+	d, dsn := parseDBURL(dbURL)
+	return open(d, dsn)
+}
+
+func open(d dialect, dsn string) (*Store, error) {
+	if d == dialectSQLite {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		dsn += sep + "_journal_mode=WAL"
+	}
+
+	db, err := sql.Open(d.driverName(), dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	store := &Store{db: db}
-	if err := store.initSchema(); err != nil {
+	store := &Store{db: db, dialect: d}
+	if err := store.migrate(); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("failed to initialize schema: %w", err)
 	}
@@ -35,78 +65,32 @@ func (s *Store) Close() error {
 	return s.db.Close()
 }
 
-// initSchema creates all tables if they don't exist
-func (s *Store) initSchema() error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS job_run (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		started_at TEXT NOT NULL,
-		finished_at TEXT,
-		mode TEXT NOT NULL,
-		status TEXT NOT NULL,
-		error_msg TEXT
-	);
-
-	CREATE TABLE IF NOT EXISTS category_run (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		job_id INTEGER NOT NULL REFERENCES job_run(id),
-		label TEXT NOT NULL,
-		type TEXT NOT NULL,
-		raw_json_path TEXT,
-		resolved_json_path TEXT,
-		pmm_movie_yaml_path TEXT,
-		pmm_tv_yaml_path TEXT,
-		status TEXT NOT NULL,
-		error_msg TEXT
-	);
-
-	CREATE TABLE IF NOT EXISTS recommendation_history (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		label TEXT NOT NULL,
-		tmdb_id INTEGER NOT NULL,
-		media_type TEXT CHECK (media_type IN ('movie','tv')),
-		first_seen_at TEXT NOT NULL,
-		last_seen_at TEXT NOT NULL
-	);
-	CREATE UNIQUE INDEX IF NOT EXISTS ix_history_label_tmdb ON recommendation_history(label, tmdb_id, media_type);
-
-	CREATE TABLE IF NOT EXISTS title_resolution_cache (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		title TEXT NOT NULL,
-		year INTEGER,
-		media_type TEXT CHECK (media_type IN ('movie','tv')),
-		tmdb_id INTEGER,
-		imdb_id TEXT,
-		country TEXT,
-		runtime_min INTEGER,
-		resolved_at TEXT NOT NULL
-	);
-
-	CREATE TABLE IF NOT EXISTS plex_inventory (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		rating_key TEXT NOT NULL,
-		tmdb_id INTEGER NOT NULL,
-		media_type TEXT CHECK (media_type IN ('movie','tv')),
-		present_at TEXT NOT NULL
-	);
-	CREATE UNIQUE INDEX IF NOT EXISTS ix_inventory_tmdb ON plex_inventory(tmdb_id, media_type);
-	CREATE UNIQUE INDEX IF NOT EXISTS ix_inventory_ratingkey ON plex_inventory(rating_key);
-	`
-
-	if _, err := s.db.Exec(schema); err != nil {
-		return err
-	}
+func (s *Store) exec(query string, args ...interface{}) (sql.Result, error) {
+	return s.db.Exec(s.dialect.rebind(query), args...)
+}
 
-	// Migration: Add rating_key column if it doesn't exist
-	_, err := s.db.Exec(`
-		ALTER TABLE plex_inventory ADD COLUMN rating_key TEXT DEFAULT '';
-	`)
-	// Ignore error if column already exists
-	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
-		return err
+func (s *Store) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.Query(s.dialect.rebind(query), args...)
+}
+
+func (s *Store) queryRow(query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRow(s.dialect.rebind(query), args...)
+}
+
+// insertID runs an INSERT and returns the new row's id. Postgres has no
+// LastInsertId, so it uses RETURNING instead.
+func (s *Store) insertID(query string, args ...interface{}) (int64, error) {
+	if s.dialect == dialectPostgres {
+		var id int64
+		err := s.queryRow(query+" RETURNING id", args...).Scan(&id)
+		return id, err
 	}
 
-	return nil
+	result, err := s.exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
 }
 
 // JobRun represents a job run record
@@ -121,22 +105,18 @@ type JobRun struct {
 
 // CreateJobRun creates a new job run record
 func (s *Store) CreateJobRun(mode string) (int64, error) {
-	result, err := s.db.Exec(
+	return s.insertID(
 		"INSERT INTO job_run (started_at, mode, status) VALUES (?, ?, ?)",
 		time.Now().UTC().Format(time.RFC3339),
 		mode,
 		"running",
 	)
-	if err != nil {
-		return 0, err
-	}
-	return result.LastInsertId()
 }
 
 // UpdateJobRun updates a job run record
 func (s *Store) UpdateJobRun(id int64, status string, errorMsg *string) error {
 	now := time.Now().UTC().Format(time.RFC3339)
-	_, err := s.db.Exec(
+	_, err := s.exec(
 		"UPDATE job_run SET finished_at = ?, status = ?, error_msg = ? WHERE id = ?",
 		now, status, errorMsg, id,
 	)
@@ -145,7 +125,7 @@ func (s *Store) UpdateJobRun(id int64, status string, errorMsg *string) error {
 
 // GetLatestJobRun retrieves the most recent job run
 func (s *Store) GetLatestJobRun() (*JobRun, error) {
-	row := s.db.QueryRow("SELECT id, started_at, finished_at, mode, status, error_msg FROM job_run ORDER BY id DESC LIMIT 1")
+	row := s.queryRow("SELECT id, started_at, finished_at, mode, status, error_msg FROM job_run ORDER BY id DESC LIMIT 1")
 
 	var jr JobRun
 	var startedAt, finishedAt sql.NullString
@@ -174,28 +154,31 @@ func (s *Store) GetLatestJobRun() (*JobRun, error) {
 
 // CategoryRun represents a category run record
 type CategoryRun struct {
-	ID                 int64
-	JobID              int64
-	Label              string
-	Type               string
-	RawJSONPath        *string
-	ResolvedJSONPath   *string
-	PMMMovieYAMLPath   *string
-	PMMTVYAMLPath      *string
-	Status             string // running, completed, failed
-	ErrorMsg           *string
+	ID               int64
+	JobID            int64
+	Label            string
+	Type             string
+	RawJSONPath      *string
+	ResolvedJSONPath *string
+	PMMMovieYAMLPath *string
+	PMMTVYAMLPath    *string
+	Status           string // running, completed, failed
+	ErrorMsg         *string
+	Model            string  // LLM model used
+	Provider         string  // LLM provider used; empty for the default
+	SettingsJSON     *string // effective recommender settings as JSON
+	Experiment       string  // experiment the run belongs to, if any
+	Variant          string  // the experiment variant it ran
+	PromptTemplate   string  // prompt template name
+	PromptVersion    string  // hash of the prompt template sources
 }
 
 // CreateCategoryRun creates a new category run record
 func (s *Store) CreateCategoryRun(jobID int64, label, catType string) (int64, error) {
-	result, err := s.db.Exec(
+	return s.insertID(
 		"INSERT INTO category_run (job_id, label, type, status) VALUES (?, ?, ?, ?)",
 		jobID, label, catType, "running",
 	)
-	if err != nil {
-		return 0, err
-	}
-	return result.LastInsertId()
 }
 
 // UpdateCategoryRun updates a category run record
@@ -205,7 +188,7 @@ func (s *Store) UpdateCategoryRun(id int64, status string, paths map[string]*str
 	pmmMovie := paths["pmm_movie"]
 	pmmTV := paths["pmm_tv"]
 
-	_, err := s.db.Exec(
+	_, err := s.exec(
 		`UPDATE category_run
 		SET status = ?, raw_json_path = ?, resolved_json_path = ?,
 		    pmm_movie_yaml_path = ?, pmm_tv_yaml_path = ?, error_msg = ?
@@ -215,11 +198,50 @@ func (s *Store) UpdateCategoryRun(id int64, status string, paths map[string]*str
 	return err
 }
 
+// RecordCategoryRunSettings records the recommender a category run used
+func (s *Store) RecordCategoryRunSettings(id int64, model, provider, settingsJSON string) error {
+	_, err := s.exec(
+		"UPDATE category_run SET model = ?, provider = ?, settings_json = ? WHERE id = ?",
+		model, provider, settingsJSON, id,
+	)
+	return err
+}
+
+// RecordCategoryRunVariant tags a category run with its experiment variant
+func (s *Store) RecordCategoryRunVariant(id int64, experiment, variant string) error {
+	_, err := s.exec(
+		"UPDATE category_run SET experiment = ?, variant = ? WHERE id = ?",
+		experiment, variant, id,
+	)
+	return err
+}
+
+// CountExperimentRuns counts a category's runs tagged with an experiment
+func (s *Store) CountExperimentRuns(label, experiment string) (int, error) {
+	var count int
+	err := s.queryRow(
+		"SELECT COUNT(*) FROM category_run WHERE label = ? AND experiment = ?",
+		label, experiment,
+	).Scan(&count)
+	return count, err
+}
+
+// RecordCategoryRunPrompt stores the prompt template a category run used
+func (s *Store) RecordCategoryRunPrompt(id int64, template, version string) error {
+	_, err := s.exec(
+		"UPDATE category_run SET prompt_template = ?, prompt_version = ? WHERE id = ?",
+		template, version, id,
+	)
+	return err
+}
+
 // GetCategoryRunsByJobID retrieves all category runs for a job
 func (s *Store) GetCategoryRunsByJobID(jobID int64) ([]CategoryRun, error) {
-	rows, err := s.db.Query(
+	rows, err := s.query(
 		`SELECT id, job_id, label, type, raw_json_path, resolved_json_path,
-		        pmm_movie_yaml_path, pmm_tv_yaml_path, status, error_msg
+		        pmm_movie_yaml_path, pmm_tv_yaml_path, status, error_msg,
+		        model, provider, settings_json, experiment, variant,
+		        prompt_template, prompt_version
 		FROM category_run WHERE job_id = ?`,
 		jobID,
 	)
@@ -231,10 +253,12 @@ func (s *Store) GetCategoryRunsByJobID(jobID int64) ([]CategoryRun, error) {
 	var runs []CategoryRun
 	for rows.Next() {
 		var cr CategoryRun
-		var rawJSON, resolvedJSON, pmmMovie, pmmTV, errorMsg sql.NullString
+		var rawJSON, resolvedJSON, pmmMovie, pmmTV, errorMsg, settings sql.NullString
 
 		err := rows.Scan(&cr.ID, &cr.JobID, &cr.Label, &cr.Type,
-			&rawJSON, &resolvedJSON, &pmmMovie, &pmmTV, &cr.Status, &errorMsg)
+			&rawJSON, &resolvedJSON, &pmmMovie, &pmmTV, &cr.Status, &errorMsg,
+			&cr.Model, &cr.Provider, &settings, &cr.Experiment, &cr.Variant,
+			&cr.PromptTemplate, &cr.PromptVersion)
 		if err != nil {
 			return nil, err
 		}
@@ -259,6 +283,10 @@ func (s *Store) GetCategoryRunsByJobID(jobID int64) ([]CategoryRun, error) {
 			s := errorMsg.String
 			cr.ErrorMsg = &s
 		}
+		if settings.Valid {
+			s := settings.String
+			cr.SettingsJSON = &s
+		}
 
 		runs = append(runs, cr)
 	}
@@ -268,18 +296,22 @@ func (s *Store) GetCategoryRunsByJobID(jobID int64) ([]CategoryRun, error) {
 
 // GetLatestCategoryRun retrieves the most recent category run for a label
 func (s *Store) GetLatestCategoryRun(label string) (*CategoryRun, error) {
-	row := s.db.QueryRow(
+	row := s.queryRow(
 		`SELECT id, job_id, label, type, raw_json_path, resolved_json_path,
-		        pmm_movie_yaml_path, pmm_tv_yaml_path, status, error_msg
+		        pmm_movie_yaml_path, pmm_tv_yaml_path, status, error_msg,
+		        model, provider, settings_json, experiment, variant,
+		        prompt_template, prompt_version
 		FROM category_run WHERE label = ? ORDER BY id DESC LIMIT 1`,
 		label,
 	)
 
 	var cr CategoryRun
-	var rawJSON, resolvedJSON, pmmMovie, pmmTV, errorMsg sql.NullString
+	var rawJSON, resolvedJSON, pmmMovie, pmmTV, errorMsg, settings sql.NullString
 
 	err := row.Scan(&cr.ID, &cr.JobID, &cr.Label, &cr.Type,
-		&rawJSON, &resolvedJSON, &pmmMovie, &pmmTV, &cr.Status, &errorMsg)
+		&rawJSON, &resolvedJSON, &pmmMovie, &pmmTV, &cr.Status, &errorMsg,
+		&cr.Model, &cr.Provider, &settings, &cr.Experiment, &cr.Variant,
+		&cr.PromptTemplate, &cr.PromptVersion)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -307,27 +339,35 @@ func (s *Store) GetLatestCategoryRun(label string) (*CategoryRun, error) {
 		s := errorMsg.String
 		cr.ErrorMsg = &s
 	}
+	if settings.Valid {
+		s := settings.String
+		cr.SettingsJSON = &s
+	}
 
 	return &cr, nil
 }
 
 // RecordRecommendation records or updates a recommendation in history
-func (s *Store) RecordRecommendation(label string, tmdbID int, mediaType string) error {
+func (s *Store) RecordRecommendation(label string, tmdbID int, mediaType string, catRunID int64) error {
 	now := time.Now().UTC().Format(time.RFC3339)
 
-	// Try insert first
-	_, err := s.db.Exec(
-		`INSERT INTO recommendation_history (label, tmdb_id, media_type, first_seen_at, last_seen_at)
-		VALUES (?, ?, ?, ?, ?)
+	// Try insert first; the first category run keeps the credit and lends
+	// the row its experiment variant
+	_, err := s.exec(
+		`INSERT INTO recommendation_history
+		(label, tmdb_id, media_type, first_seen_at, last_seen_at, category_run_id, experiment, variant)
+		VALUES (?, ?, ?, ?, ?, ?,
+			COALESCE((SELECT experiment FROM category_run WHERE id = ?), ''),
+			COALESCE((SELECT variant FROM category_run WHERE id = ?), ''))
 		ON CONFLICT(label, tmdb_id, media_type) DO UPDATE SET last_seen_at = ?`,
-		label, tmdbID, mediaType, now, now, now,
+		label, tmdbID, mediaType, now, now, catRunID, catRunID, catRunID, now,
 	)
 	return err
 }
 
 // GetRecommendationsSince retrieves recommendations seen since a given date
 func (s *Store) GetRecommendationsSince(label string, since time.Time) (map[int]bool, error) {
-	rows, err := s.db.Query(
+	rows, err := s.query(
 		"SELECT tmdb_id FROM recommendation_history WHERE label = ? AND last_seen_at >= ?",
 		label, since.Format(time.RFC3339),
 	)
@@ -348,6 +388,31 @@ func (s *Store) GetRecommendationsSince(label string, since time.Time) (map[int]
 	return result, rows.Err()
 }
 
+// GetRecommendationLabelsSince returns, for each title any category
+// recommended since a given date, the labels that recommended it
+func (s *Store) GetRecommendationLabelsSince(since time.Time) (map[int][]string, error) {
+	rows, err := s.query(
+		"SELECT tmdb_id, label FROM recommendation_history WHERE last_seen_at >= ?",
+		since.Format(time.RFC3339),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[int][]string)
+	for rows.Next() {
+		var id int
+		var label string
+		if err := rows.Scan(&id, &label); err != nil {
+			return nil, err
+		}
+		result[id] = append(result[id], label)
+	}
+
+	return result, rows.Err()
+}
+
 // TitleResolution represents a cached title resolution
 type TitleResolution struct {
 	Title      string
@@ -362,7 +427,7 @@ type TitleResolution struct {
 // CacheTitleResolution stores a title resolution in cache
 func (s *Store) CacheTitleResolution(tr *TitleResolution) error {
 	now := time.Now().UTC().Format(time.RFC3339)
-	_, err := s.db.Exec(
+	_, err := s.exec(
 		`INSERT INTO title_resolution_cache
 		(title, year, media_type, tmdb_id, imdb_id, country, runtime_min, resolved_at)
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
@@ -373,7 +438,7 @@ func (s *Store) CacheTitleResolution(tr *TitleResolution) error {
 
 // GetTitleResolution retrieves a cached title resolution
 func (s *Store) GetTitleResolution(title string, year int, mediaType string) (*TitleResolution, error) {
-	row := s.db.QueryRow(
+	row := s.queryRow(
 		`SELECT title, year, media_type, tmdb_id, imdb_id, country, runtime_min
 		FROM title_resolution_cache
 		WHERE title = ? AND year = ? AND media_type = ?
@@ -406,21 +471,54 @@ func (s *Store) GetTitleResolution(title string, year int, mediaType string) (*T
 	return &tr, nil
 }
 
-// UpdatePlexInventory refreshes the Plex inventory table
-func (s *Store) UpdatePlexInventory(items []struct{ RatingKey string; TMDbID int; MediaType string }) error {
+// ReplacePlexInventory replaces a server's Plex inventory (full reconcile).
+// Rows for items no longer on that server are removed.
+func (s *Store) ReplacePlexInventory(server string, items []InventoryItem) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Clear existing inventory for this server
+	if _, err := tx.Exec(s.dialect.rebind("DELETE FROM plex_inventory WHERE server = ?"), server); err != nil {
+		return err
+	}
+
+	if err := s.upsertInventory(tx, items); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpsertPlexInventory inserts or updates inventory rows by rating key
+// (incremental sync). Existing rows not in items are left untouched.
+func (s *Store) UpsertPlexInventory(items []InventoryItem) error {
 	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	// Clear existing inventory
-	if _, err := tx.Exec("DELETE FROM plex_inventory"); err != nil {
+	if err := s.upsertInventory(tx, items); err != nil {
 		return err
 	}
 
-	// Insert new inventory
-	stmt, err := tx.Prepare("INSERT INTO plex_inventory (rating_key, tmdb_id, media_type, present_at) VALUES (?, ?, ?, ?)")
+	return tx.Commit()
+}
+
+func (s *Store) upsertInventory(tx *sql.Tx, items []InventoryItem) error {
+	stmt, err := tx.Prepare(s.dialect.rebind(
+		`INSERT INTO plex_inventory
+		(server, section_key, section_title, rating_key, tmdb_id, media_type, title, year, added_at, updated_at, present_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(server, rating_key) DO UPDATE SET
+			section_key = excluded.section_key, section_title = excluded.section_title,
+			tmdb_id = excluded.tmdb_id, media_type = excluded.media_type,
+			title = excluded.title, year = excluded.year,
+			added_at = excluded.added_at, updated_at = excluded.updated_at,
+			present_at = excluded.present_at`))
 	if err != nil {
 		return err
 	}
@@ -428,27 +526,91 @@ func (s *Store) UpdatePlexInventory(items []struct{ RatingKey string; TMDbID int
 
 	now := time.Now().UTC().Format(time.RFC3339)
 	for _, item := range items {
-		if _, err := stmt.Exec(item.RatingKey, item.TMDbID, item.MediaType, now); err != nil {
+		if _, err := stmt.Exec(item.Server, item.SectionKey, item.SectionTitle,
+			item.RatingKey, item.TMDbID, item.MediaType, item.Title, item.Year,
+			item.AddedAt, item.UpdatedAt, now); err != nil {
 			return err
 		}
 	}
 
-	return tx.Commit()
+	return nil
 }
 
-// IsInPlexInventory checks if a TMDb ID is in Plex inventory
-func (s *Store) IsInPlexInventory(tmdbID int, mediaType string) (bool, error) {
+// IsInPlexInventory checks if a TMDb ID is in Plex inventory, optionally
+// limited to a set of servers/sections
+func (s *Store) IsInPlexInventory(tmdbID int, mediaType string, scope *InventoryScope) (bool, error) {
+	clause, scopeArgs := scope.sqlClause()
+	args := append([]interface{}{tmdbID, mediaType}, scopeArgs...)
+
 	var count int
-	err := s.db.QueryRow(
-		"SELECT COUNT(*) FROM plex_inventory WHERE tmdb_id = ? AND media_type = ?",
-		tmdbID, mediaType,
+	err := s.queryRow(
+		"SELECT COUNT(*) FROM plex_inventory WHERE tmdb_id = ? AND media_type = ?"+clause,
+		args...,
 	).Scan(&count)
 	return count > 0, err
 }
 
-// GetPlexInventoryCache retrieves TMDb IDs by rating key from the inventory cache
-func (s *Store) GetPlexInventoryCache() (map[string]int, error) {
-	rows, err := s.db.Query("SELECT rating_key, tmdb_id FROM plex_inventory WHERE rating_key != ''")
+// FindPlexInventory returns the library items matching a TMDb ID, optionally
+// limited to a set of servers/sections
+func (s *Store) FindPlexInventory(tmdbID int, mediaType string, scope *InventoryScope) ([]InventoryItem, error) {
+	clause, scopeArgs := scope.sqlClause()
+	args := append([]interface{}{tmdbID, mediaType}, scopeArgs...)
+
+	rows, err := s.query(
+		`SELECT server, section_key, section_title, rating_key, tmdb_id, media_type, title, year, added_at, updated_at
+		FROM plex_inventory WHERE tmdb_id = ? AND media_type = ?`+clause+` ORDER BY server, section_key, rating_key`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []InventoryItem
+	for rows.Next() {
+		var item InventoryItem
+		if err := rows.Scan(&item.Server, &item.SectionKey, &item.SectionTitle, &item.RatingKey, &item.TMDbID,
+			&item.MediaType, &item.Title, &item.Year, &item.AddedAt, &item.UpdatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// ListPlexInventory returns every inventory item inside scope, most recently
+// added first
+func (s *Store) ListPlexInventory(scope *InventoryScope) ([]InventoryItem, error) {
+	clause, args := scope.sqlClause()
+
+	rows, err := s.query(
+		`SELECT server, section_key, section_title, rating_key, tmdb_id, media_type, title, year, added_at, updated_at
+		FROM plex_inventory WHERE 1 = 1`+clause+` ORDER BY added_at DESC, server, rating_key`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []InventoryItem
+	for rows.Next() {
+		var item InventoryItem
+		if err := rows.Scan(&item.Server, &item.SectionKey, &item.SectionTitle, &item.RatingKey, &item.TMDbID,
+			&item.MediaType, &item.Title, &item.Year, &item.AddedAt, &item.UpdatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// GetPlexInventoryCache retrieves TMDb IDs by rating key for a server
+func (s *Store) GetPlexInventoryCache(server string) (map[string]int, error) {
+	rows, err := s.query(
+		"SELECT rating_key, tmdb_id FROM plex_inventory WHERE server = ? AND rating_key != '' AND tmdb_id > 0",
+		server,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -465,3 +627,89 @@ func (s *Store) GetPlexInventoryCache() (map[string]int, error) {
 	}
 	return cache, rows.Err()
 }
+
+// PlexSyncState records when a Plex server's inventory was last synced
+type PlexSyncState struct {
+	Server         string
+	LastSyncAt     time.Time
+	LastFullSyncAt time.Time
+}
+
+// GetPlexSyncState retrieves the sync state for a server, or nil if never synced
+func (s *Store) GetPlexSyncState(server string) (*PlexSyncState, error) {
+	row := s.queryRow(
+		"SELECT server, last_sync_at, last_full_sync_at FROM plex_sync_state WHERE server = ?",
+		server,
+	)
+
+	var st PlexSyncState
+	var lastSync, lastFull sql.NullString
+	err := row.Scan(&st.Server, &lastSync, &lastFull)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if lastSync.Valid {
+		st.LastSyncAt, _ = time.Parse(time.RFC3339, lastSync.String)
+	}
+	if lastFull.Valid {
+		st.LastFullSyncAt, _ = time.Parse(time.RFC3339, lastFull.String)
+	}
+
+	return &st, nil
+}
+
+// SavePlexSyncState records the sync state for a server
+func (s *Store) SavePlexSyncState(st *PlexSyncState) error {
+	var lastFull *string
+	if !st.LastFullSyncAt.IsZero() {
+		v := st.LastFullSyncAt.UTC().Format(time.RFC3339)
+		lastFull = &v
+	}
+
+	_, err := s.exec(
+		`INSERT INTO plex_sync_state (server, last_sync_at, last_full_sync_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(server) DO UPDATE SET
+			last_sync_at = excluded.last_sync_at, last_full_sync_at = excluded.last_full_sync_at`,
+		st.Server, st.LastSyncAt.UTC().Format(time.RFC3339), lastFull,
+	)
+	return err
+}
+
+// PrunePlexServers removes the inventory, sync state and ratings of every
+// server not in servers, such as the implicit "default" server left behind
+// when plex.url gives way to plex.servers. It returns the number of
+// inventory rows removed. An empty list removes nothing.
+func (s *Store) PrunePlexServers(servers []string) (int64, error) {
+	if len(servers) == 0 {
+		return 0, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(servers)), ", ")
+	args := make([]interface{}, len(servers))
+	for i, server := range servers {
+		args[i] = server
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var removed int64
+	for _, table := range []string{"plex_inventory", "plex_sync_state", "plex_user_rating"} {
+		res, err := tx.Exec(s.dialect.rebind("DELETE FROM "+table+" WHERE server NOT IN ("+placeholders+")"), args...)
+		if err != nil {
+			return 0, err
+		}
+		if table == "plex_inventory" {
+			removed, _ = res.RowsAffected()
+		}
+	}
+
+	return removed, tx.Commit()
+}
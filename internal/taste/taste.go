@@ -0,0 +1,356 @@
+package taste
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dppeppel/scryarr/internal/logging"
+	"github.com/dppeppel/scryarr/internal/tautulli"
+	"github.com/dppeppel/scryarr/internal/tmdb"
+	"github.com/rs/zerolog"
+)
+
+var log zerolog.Logger
+
+func init() {
+	log = logging.GetLogger("taste")
+}
+
+// Defaults for Options
+const (
+	DefaultMaxTitles = 25
+	DefaultMaxTags   = 10
+
+	// baseHalfLifeDays is the recency half-life at recency_weight 1. Lower
+	// weights stretch it (0.5 -> 60 days); 0 disables decay.
+	baseHalfLifeDays = 30.0
+
+	// completedPercent is the completion at which a watch counts in full
+	completedPercent = 90
+
+	// abandonedPercent is the completion below which a watch is ignored, and
+	// counted as an abandonment
+	abandonedPercent = 20
+
+	// abandonGrace is how long a barely started title may sit before it
+	// counts as abandoned
+	abandonGrace = 7 * 24 * time.Hour
+
+	// rewatchBonus is the extra credit for each complete rewatch
+	rewatchBonus = 0.5
+)
+
+// MetadataSource looks up TMDb genres and keywords for a title
+type MetadataSource interface {
+	GetDetails(tmdbID int, mediaType string) (*tmdb.TitleResult, error)
+	SearchAndResolve(title string, year int, mediaType string) (*tmdb.TitleResult, error)
+}
+
+// Options tune profile building
+type Options struct {
+	RecencyWeight float64 // 0-1; higher weights favour recent watches more
+	MaxTitles     int     // titles kept in the profile (default 25)
+	MaxTags       int     // genres and keywords kept (default 10 each)
+	Now           time.Time
+}
+
+// Title is one movie or series the viewer engaged with. Episodes are folded
+// into their series.
+type Title struct {
+	Title       string
+	Year        int
+	MediaType   string // movie or tv
+	TMDbID      int
+	Plays       int     // watches that were not abandoned (episodes for series)
+	Rewatches   int     // complete watches of something already completed
+	Abandons    int     // watches stopped before abandonedPercent
+	Completion  float64 // mean percent_complete across plays
+	LastWatched time.Time
+	Score       float64
+}
+
+// Tag is a genre or keyword with its accumulated weight
+type Tag struct {
+	Name   string
+	Weight float64
+}
+
+// Profile is a ranked, compact summary of what the viewer watches
+type Profile struct {
+	Titles   []Title // best first
+	Genres   []Tag   // heaviest first
+	Keywords []Tag
+
+	// Abandoned holds titles that were only ever started and quickly
+	// stopped, most recent first
+	Abandoned []Title
+}
+
+// Entries formats the titles as "Title (Year)" strings, best first
+func (p *Profile) Entries() []string {
+	entries := make([]string, 0, len(p.Titles))
+	for _, t := range p.Titles {
+		entries = append(entries, t.String())
+	}
+	return entries
+}
+
+// String renders the title for the prompt, e.g. "The Wire (2002) [tv]"
+func (t Title) String() string {
+	s := t.Title
+	if t.Year > 0 {
+		s = fmt.Sprintf("%s (%d)", s, t.Year)
+	}
+	if t.MediaType == "tv" {
+		s += " [tv]"
+	}
+	return s
+}
+
+// TagNames returns the names of tags, heaviest first
+func TagNames(tags []Tag) []string {
+	names := make([]string, len(tags))
+	for i, tag := range tags {
+		names[i] = tag.Name
+	}
+	return names
+}
+
+// aggregate collects the history rows that belong to one title
+type aggregate struct {
+	Title
+	credit        float64 // decayed, completion-weighted plays
+	completionSum float64
+	completed     map[string]bool // rating keys watched to completion
+}
+
+// Build scores the history into a profile. Episodes are aggregated into their
+// series. Each watch earns credit for its completion (abandoned watches earn
+// none), complete rewatches earn a bonus, and credit decays exponentially
+// with age. Scores grow logarithmically with credit so a binged series does
+// not crowd out everything else. meta may be nil to skip genres and keywords.
+func Build(history []tautulli.HistoryItem, opts Options, meta MetadataSource) *Profile {
+	if opts.MaxTitles <= 0 {
+		opts.MaxTitles = DefaultMaxTitles
+	}
+	if opts.MaxTags <= 0 {
+		opts.MaxTags = DefaultMaxTags
+	}
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+
+	aggregates := make(map[string]*aggregate)
+	var order []string
+	for _, item := range history {
+		key, title := titleOf(item)
+		agg, ok := aggregates[key]
+		if !ok {
+			agg = &aggregate{Title: title, completed: make(map[string]bool)}
+			aggregates[key] = agg
+			order = append(order, key)
+		}
+		agg.add(item, opts)
+	}
+
+	var titles, abandoned []Title
+	for _, key := range order {
+		agg := aggregates[key]
+		if agg.Plays == 0 {
+			// Give a paused watch time to be resumed before calling it abandoned
+			if opts.Now.Sub(agg.LastWatched) >= abandonGrace {
+				abandoned = append(abandoned, agg.Title)
+			}
+			continue
+		}
+		agg.Completion = agg.completionSum / float64(agg.Plays)
+		agg.Score = math.Log1p(agg.credit)
+		titles = append(titles, agg.Title)
+	}
+
+	sort.SliceStable(titles, func(i, j int) bool {
+		return titles[i].Score > titles[j].Score
+	})
+	if len(titles) > opts.MaxTitles {
+		titles = titles[:opts.MaxTitles]
+	}
+
+	sort.SliceStable(abandoned, func(i, j int) bool {
+		return abandoned[i].LastWatched.After(abandoned[j].LastWatched)
+	})
+	if len(abandoned) > opts.MaxTitles {
+		abandoned = abandoned[:opts.MaxTitles]
+	}
+
+	profile := &Profile{Titles: titles, Abandoned: abandoned}
+	if meta != nil {
+		profile.Genres, profile.Keywords = tags(titles, meta, opts.MaxTags)
+	}
+
+	log.Debug().Int("history", len(history)).Int("titles", len(titles)).Int("genres", len(profile.Genres)).Msg("built taste profile")
+	return profile
+}
+
+// titleOf returns the aggregation key and identity of the title a history
+// row belongs to
+func titleOf(item tautulli.HistoryItem) (string, Title) {
+	if item.MediaType == "episode" {
+		key := "tv:" + item.GrandparentRatingKey
+		if item.GrandparentRatingKey == "" {
+			key = "tv:" + strings.ToLower(item.GrandparentTitle)
+		}
+		return key, Title{Title: item.GrandparentTitle, MediaType: "tv", TMDbID: item.TMDbID}
+	}
+
+	key := fmt.Sprintf("movie:%s|%d", strings.ToLower(item.Title), item.Year)
+	if item.TMDbID > 0 {
+		key = fmt.Sprintf("movie:%d", item.TMDbID)
+	}
+	return key, Title{Title: item.Title, Year: item.Year, MediaType: "movie", TMDbID: item.TMDbID}
+}
+
+func (a *aggregate) add(item tautulli.HistoryItem, opts Options) {
+	percent := float64(item.PercentComplete)
+	if item.WatchedStatus >= 1 && percent < completedPercent {
+		percent = completedPercent
+	}
+	watchedAt := time.Unix(item.WatchedAt, 0)
+	if watchedAt.After(a.LastWatched) {
+		a.LastWatched = watchedAt
+	}
+	// Episodes carry the episode's year; the earliest approximates the show's
+	if a.MediaType == "tv" && item.Year > 0 && (a.Year == 0 || item.Year < a.Year) {
+		a.Year = item.Year
+	}
+	if a.TMDbID == 0 {
+		a.TMDbID = item.TMDbID
+	}
+
+	if percent < abandonedPercent {
+		a.Abandons++
+		return
+	}
+
+	credit := math.Min(percent, 100) / 100
+	if percent >= completedPercent {
+		if a.completed[item.RatingKey] {
+			a.Rewatches++
+			credit += rewatchBonus
+		}
+		a.completed[item.RatingKey] = true
+	}
+
+	a.Plays++
+	a.completionSum += percent
+	a.credit += credit * decay(opts.Now.Sub(watchedAt), opts.RecencyWeight)
+}
+
+// decay returns the recency multiplier for a watch of the given age
+func decay(age time.Duration, recencyWeight float64) float64 {
+	if recencyWeight <= 0 || age <= 0 {
+		return 1
+	}
+	halfLife := baseHalfLifeDays / recencyWeight
+	return math.Exp2(-age.Hours() / 24 / halfLife)
+}
+
+// tags accumulates TMDb genres and keywords over the titles, weighted by score
+func tags(titles []Title, meta MetadataSource, max int) ([]Tag, []Tag) {
+	genres := make(map[string]float64)
+	keywords := make(map[string]float64)
+
+	for i := range titles {
+		t := &titles[i]
+		details, err := lookup(t, meta)
+		if err != nil {
+			log.Debug().Err(err).Str("title", t.Title).Msg("no TMDb metadata for title")
+			continue
+		}
+		for _, g := range details.Genres {
+			genres[g] += t.Score
+		}
+		for _, k := range details.Keywords {
+			keywords[k] += t.Score
+		}
+	}
+	return topTags(genres, max), topTags(keywords, max)
+}
+
+// lookup fetches a title's TMDb details, resolving its ID by search first if
+// the history carried none
+func lookup(t *Title, meta MetadataSource) (*tmdb.TitleResult, error) {
+	if t.TMDbID == 0 {
+		result, err := meta.SearchAndResolve(t.Title, t.Year, t.MediaType)
+		if err != nil {
+			return nil, err
+		}
+		t.TMDbID = result.TMDbID
+	}
+	return meta.GetDetails(t.TMDbID, t.MediaType)
+}
+
+func topTags(weights map[string]float64, max int) []Tag {
+	tags := make([]Tag, 0, len(weights))
+	for name, weight := range weights {
+		tags = append(tags, Tag{Name: name, Weight: weight})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Weight != tags[j].Weight {
+			return tags[i].Weight > tags[j].Weight
+		}
+		return tags[i].Name < tags[j].Name
+	})
+	if len(tags) > max {
+		tags = tags[:max]
+	}
+	return tags
+}
+
+// Completed reports whether a history entry was watched to completion
+func Completed(item tautulli.HistoryItem) bool {
+	return item.PercentComplete >= completedPercent || item.WatchedStatus >= 1
+}
+
+// Finished returns the movies and series watched to completion since the
+// given time, most recently finished first. A series counts once any of its
+// episodes was finished, dated by the latest one.
+func Finished(history []tautulli.HistoryItem, since time.Time) []Title {
+	finished := make(map[string]*Title)
+	var order []string
+	for _, item := range history {
+		watchedAt := time.Unix(item.WatchedAt, 0)
+		if watchedAt.Before(since) || !Completed(item) {
+			continue
+		}
+
+		key, title := titleOf(item)
+		t, ok := finished[key]
+		if !ok {
+			t = &title
+			finished[key] = t
+			order = append(order, key)
+		}
+		if watchedAt.After(t.LastWatched) {
+			t.LastWatched = watchedAt
+		}
+		if t.MediaType == "tv" && item.Year > 0 && (t.Year == 0 || item.Year < t.Year) {
+			t.Year = item.Year
+		}
+		if t.TMDbID == 0 {
+			t.TMDbID = item.TMDbID
+		}
+		t.Plays++
+	}
+
+	titles := make([]Title, 0, len(order))
+	for _, key := range order {
+		titles = append(titles, *finished[key])
+	}
+	sort.SliceStable(titles, func(i, j int) bool {
+		return titles[i].LastWatched.After(titles[j].LastWatched)
+	})
+	return titles
+}
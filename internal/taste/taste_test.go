@@ -0,0 +1,106 @@
+package taste
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/dppeppel/scryarr/internal/tautulli"
+)
+
+var now = time.Date(2026, 6, 1, 20, 0, 0, 0, time.UTC)
+
+// watch is a history row of Contact played to percent, daysAgo days before now
+func watch(percent int, daysAgo float64) tautulli.HistoryItem {
+	return tautulli.HistoryItem{Title: "Contact", Year: 1997, MediaType: "movie", RatingKey: "10", TMDbID: 686,
+		PercentComplete: percent, WatchedAt: now.Add(-time.Duration(daysAgo * 24 * float64(time.Hour))).Unix()}
+}
+
+func TestBuildScoresWatches(t *testing.T) {
+	finished := watch(60, 1)
+	finished.WatchedStatus = 1
+
+	tests := []struct {
+		name          string
+		recency       float64
+		history       []tautulli.HistoryItem
+		credit        float64 // expected decayed credit; 0 = no scored title
+		plays         int
+		rewatches     int
+		abandons      int
+		abandonedList bool
+	}{
+		{name: "full watch", history: []tautulli.HistoryItem{watch(100, 1)}, credit: 1, plays: 1},
+		{name: "half watch", history: []tautulli.HistoryItem{watch(50, 1)}, credit: 0.5, plays: 1},
+		{name: "watched status counts as complete", history: []tautulli.HistoryItem{finished}, credit: 0.9, plays: 1},
+		{name: "rewatch bonus", history: []tautulli.HistoryItem{watch(100, 9), watch(95, 1)}, credit: 1 + 0.95 + rewatchBonus, plays: 2, rewatches: 1},
+		{name: "partial rewatch earns no bonus", history: []tautulli.HistoryItem{watch(100, 9), watch(50, 1)}, credit: 1.5, plays: 2},
+		{name: "abandoned", history: []tautulli.HistoryItem{watch(10, 8)}, abandons: 1, abandonedList: true},
+		{name: "paused within the grace period", history: []tautulli.HistoryItem{watch(10, 2)}, abandons: 1},
+		{name: "abandoned then finished", history: []tautulli.HistoryItem{watch(10, 30), watch(100, 1)}, credit: 1, plays: 1, abandons: 1},
+		{name: "one half-life at recency 1", recency: 1, history: []tautulli.HistoryItem{watch(100, 30)}, credit: 0.5, plays: 1},
+		{name: "two half-lives at recency 1", recency: 1, history: []tautulli.HistoryItem{watch(100, 60)}, credit: 0.25, plays: 1},
+		{name: "half-life doubles at recency 0.5", recency: 0.5, history: []tautulli.HistoryItem{watch(100, 60)}, credit: 0.5, plays: 1},
+		{name: "no decay at recency 0", history: []tautulli.HistoryItem{watch(100, 300)}, credit: 1, plays: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile := Build(tt.history, Options{RecencyWeight: tt.recency, Now: now}, nil)
+
+			if (len(profile.Abandoned) == 1) != tt.abandonedList || len(profile.Abandoned) > 1 {
+				t.Errorf("abandoned = %+v, want listed: %v", profile.Abandoned, tt.abandonedList)
+			}
+			if tt.credit == 0 {
+				if len(profile.Titles) != 0 {
+					t.Errorf("titles = %+v, want none", profile.Titles)
+				}
+				if len(profile.Abandoned) == 1 && profile.Abandoned[0].Abandons != tt.abandons {
+					t.Errorf("abandons = %d, want %d", profile.Abandoned[0].Abandons, tt.abandons)
+				}
+				return
+			}
+
+			if len(profile.Titles) != 1 {
+				t.Fatalf("titles = %+v, want Contact", profile.Titles)
+			}
+			got := profile.Titles[0]
+			if want := math.Log1p(tt.credit); math.Abs(got.Score-want) > 1e-9 {
+				t.Errorf("score = %v, want log1p(%v) = %v", got.Score, tt.credit, want)
+			}
+			if got.Plays != tt.plays || got.Rewatches != tt.rewatches || got.Abandons != tt.abandons {
+				t.Errorf("plays/rewatches/abandons = %d/%d/%d, want %d/%d/%d",
+					got.Plays, got.Rewatches, got.Abandons, tt.plays, tt.rewatches, tt.abandons)
+			}
+		})
+	}
+}
+
+func TestBuildFoldsEpisodesAndRanks(t *testing.T) {
+	episode := func(key string, percent int) tautulli.HistoryItem {
+		return tautulli.HistoryItem{Title: "Episode " + key, Year: 2015, MediaType: "episode", RatingKey: key,
+			GrandparentRatingKey: "20", GrandparentTitle: "The Expanse", PercentComplete: percent, WatchedAt: now.Unix()}
+	}
+	half := watch(50, 1)
+	half.Title, half.Year, half.RatingKey, half.TMDbID = "Moon", 2009, "30", 17431
+
+	profile := Build([]tautulli.HistoryItem{half, watch(100, 1), episode("21", 100), episode("22", 100), episode("23", 100)},
+		Options{Now: now}, nil)
+
+	var names []string
+	for _, title := range profile.Titles {
+		names = append(names, title.String())
+	}
+	want := []string{"The Expanse (2015) [tv]", "Contact (1997)", "Moon (2009)"}
+	if len(names) != len(want) {
+		t.Fatalf("titles = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("titles = %v, want %v", names, want)
+			break
+		}
+	}
+	if profile.Titles[0].Plays != 3 || profile.Titles[0].Rewatches != 0 {
+		t.Errorf("series = %+v, want 3 plays of distinct episodes", profile.Titles[0])
+	}
+}
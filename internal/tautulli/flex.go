@@ -0,0 +1,50 @@
+package tautulli
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+)
+
+// FlexString decodes a JSON string or number as a string. Tautulli, and
+// webhook payloads filled from its templates, send the same field either way.
+type FlexString string
+
+func (f *FlexString) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		*f = ""
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*f = FlexString(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*f = FlexString(n.String())
+	return nil
+}
+
+// FlexInt decodes a JSON number or numeric string as an int; empty strings
+// and null decode as zero
+type FlexInt int
+
+func (f *FlexInt) UnmarshalJSON(data []byte) error {
+	var s FlexString
+	if err := s.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	if s == "" {
+		*f = 0
+		return nil
+	}
+	n, err := strconv.ParseFloat(string(s), 64)
+	if err != nil {
+		return err
+	}
+	*f = FlexInt(n)
+	return nil
+}
@@ -6,9 +6,11 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/dppeppel/scryarr/internal/logging"
+	"github.com/dppeppel/scryarr/internal/plex"
 	"github.com/rs/zerolog"
 )
 
@@ -34,72 +36,156 @@ func NewClient(baseURL, apiKey string) *Client {
 	}
 }
 
-// HistoryItem represents a single watch history entry
+// historyPageSize is the number of rows requested per get_history call
+const historyPageSize = 1000
+
+// HistoryItem represents a single watch history entry. For episodes the
+// parent is the season and the grandparent the show. External IDs come from
+// the GUID and are empty for plex:// GUIDs; episode GUIDs from the legacy
+// TVDB agent yield the show's TVDB ID.
 type HistoryItem struct {
-	Title      string `json:"title"`
-	Year       int    `json:"year"`
-	MediaType  string `json:"media_type"` // movie, episode
-	WatchedAt  int64  `json:"stopped"`
-	TMDbID     string `json:"guid"`       // Plex GUID, may need parsing
-	Rating     int    `json:"rating_key"`
-	ParentTitle string `json:"grandparent_title"` // For TV shows
+	Title                string
+	Year                 int
+	MediaType            string // movie, episode
+	WatchedAt            int64  // unix seconds the session stopped
+	User                 string
+	UserID               int
+	RatingKey            string
+	ParentRatingKey      string
+	GrandparentRatingKey string
+	ParentTitle          string
+	GrandparentTitle     string
+	PercentComplete      int
+	WatchedStatus        float64 // 1 watched, 0.5 partially watched, 0 not
+	GUID                 string
+	TMDbID               int
+	IMDbID               string
+	TVDBID               int
+}
+
+// historyRow is a get_history row as Tautulli encodes it. Keys and years
+// arrive as numbers or strings depending on version and media type.
+type historyRow struct {
+	Title                string     `json:"title"`
+	Year                 FlexInt    `json:"year"`
+	MediaType            string     `json:"media_type"`
+	Stopped              int64      `json:"stopped"`
+	User                 string     `json:"user"`
+	UserID               FlexInt    `json:"user_id"`
+	RatingKey            FlexString `json:"rating_key"`
+	ParentRatingKey      FlexString `json:"parent_rating_key"`
+	GrandparentRatingKey FlexString `json:"grandparent_rating_key"`
+	ParentTitle          string     `json:"parent_title"`
+	GrandparentTitle     string     `json:"grandparent_title"`
+	PercentComplete      FlexInt    `json:"percent_complete"`
+	WatchedStatus        float64    `json:"watched_status"`
+	GUID                 string     `json:"guid"`
+}
+
+func (r historyRow) item() HistoryItem {
+	ids := plex.ParseGUID(r.GUID)
+	return HistoryItem{
+		Title:                r.Title,
+		Year:                 int(r.Year),
+		MediaType:            r.MediaType,
+		WatchedAt:            r.Stopped,
+		User:                 r.User,
+		UserID:               int(r.UserID),
+		RatingKey:            string(r.RatingKey),
+		ParentRatingKey:      string(r.ParentRatingKey),
+		GrandparentRatingKey: string(r.GrandparentRatingKey),
+		ParentTitle:          r.ParentTitle,
+		GrandparentTitle:     r.GrandparentTitle,
+		PercentComplete:      int(r.PercentComplete),
+		WatchedStatus:        r.WatchedStatus,
+		GUID:                 r.GUID,
+		TMDbID:               ids.TMDbID,
+		IMDbID:               ids.IMDbID,
+		TVDBID:               ids.TVDBID,
+	}
 }
 
-// GetHistory fetches watch history from Tautulli
+// GetHistory fetches watch history from Tautulli, paging until every row in
+// the lookback window has been read
 func (c *Client) GetHistory(lookbackDays int) ([]HistoryItem, error) {
 	log.Info().Int("lookback_days", lookbackDays).Msg("fetching watch history from Tautulli")
 
-	params := url.Values{}
-	params.Set("apikey", c.apiKey)
-	params.Set("cmd", "get_history")
-	params.Set("length", "1000") // Max items to fetch
-	params.Set("order_column", "date")
-	params.Set("order_dir", "desc")
+	// Tautulli filters on local dates, including the "after" day itself
+	after := time.Now().AddDate(0, 0, -lookbackDays).Format("2006-01-02")
+
+	var items []HistoryItem
+	start := 0
+	for {
+		params := url.Values{}
+		params.Set("apikey", c.apiKey)
+		params.Set("cmd", "get_history")
+		params.Set("start", strconv.Itoa(start))
+		params.Set("length", strconv.Itoa(historyPageSize))
+		params.Set("order_column", "date")
+		params.Set("order_dir", "desc")
+		params.Set("after", after)
+
+		rows, filtered, err := c.getHistoryPage(params)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			items = append(items, row.item())
+		}
+
+		start += len(rows)
+		if len(rows) == 0 || start >= filtered {
+			break
+		}
+	}
 
-	// Calculate start date
-	startDate := time.Now().AddDate(0, 0, -lookbackDays).Unix()
-	params.Set("start_date", fmt.Sprintf("%d", startDate))
+	log.Info().Int("count", len(items)).Msg("fetched watch history")
+	return items, nil
+}
 
+// getHistoryPage fetches one page of history, returning the rows and the
+// total number of rows matching the query
+func (c *Client) getHistoryPage(params url.Values) ([]historyRow, int, error) {
 	reqURL := fmt.Sprintf("%s/api/v2?%s", c.baseURL, params.Encode())
 
 	resp, err := c.client.Get(reqURL)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch history: %w", err)
+		return nil, 0, fmt.Errorf("failed to fetch history: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("tautulli returned status %d: %s", resp.StatusCode, string(body))
+		return nil, 0, fmt.Errorf("tautulli returned status %d: %s", resp.StatusCode, string(body))
 	}
 
 	var result struct {
 		Response struct {
 			Result string `json:"result"`
 			Data   struct {
-				Data []HistoryItem `json:"data"`
+				RecordsFiltered int          `json:"recordsFiltered"`
+				Data            []historyRow `json:"data"`
 			} `json:"data"`
 		} `json:"response"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+		return nil, 0, fmt.Errorf("failed to decode response: %w", err)
 	}
 
 	if result.Response.Result != "success" {
-		return nil, fmt.Errorf("tautulli API returned non-success result")
+		return nil, 0, fmt.Errorf("tautulli API returned non-success result")
 	}
 
-	log.Info().Int("count", len(result.Response.Data.Data)).Msg("fetched watch history")
-	return result.Response.Data.Data, nil
+	return result.Response.Data.Data, result.Response.Data.RecordsFiltered, nil
 }
 
 // GetMetadata fetches detailed metadata for a specific rating key
-func (c *Client) GetMetadata(ratingKey int) (map[string]interface{}, error) {
+func (c *Client) GetMetadata(ratingKey string) (map[string]interface{}, error) {
 	params := url.Values{}
 	params.Set("apikey", c.apiKey)
 	params.Set("cmd", "get_metadata")
-	params.Set("rating_key", fmt.Sprintf("%d", ratingKey))
+	params.Set("rating_key", ratingKey)
 
 	reqURL := fmt.Sprintf("%s/api/v2?%s", c.baseURL, params.Encode())
 
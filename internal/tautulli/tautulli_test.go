@@ -0,0 +1,63 @@
+package tautulli
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGetHistoryQueryAndPaging(t *testing.T) {
+	const total = 5
+	after := time.Now().AddDate(0, 0, -7).Format("2006-01-02")
+
+	var starts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/api/v2" || q.Get("cmd") != "get_history" || q.Get("apikey") != "key" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if q.Get("after") != after || q.Has("start_date") {
+			t.Errorf("query = %s, want after=%s", r.URL.RawQuery, after)
+		}
+		if q.Get("length") != strconv.Itoa(historyPageSize) || q.Get("order_column") != "date" || q.Get("order_dir") != "desc" {
+			t.Errorf("query = %s", r.URL.RawQuery)
+		}
+		starts = append(starts, q.Get("start"))
+
+		// Serve short pages, as a server capping the length would
+		start, _ := strconv.Atoi(q.Get("start"))
+		var rows []map[string]interface{}
+		for i := start; i < total && i < start+2; i++ {
+			rows = append(rows, map[string]interface{}{
+				"title": fmt.Sprintf("Movie %d", i), "media_type": "movie", "rating_key": i,
+				"guid": fmt.Sprintf("tmdb://%d", 100+i), "stopped": time.Now().Unix(), "watched_status": 1,
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"response": map[string]interface{}{
+			"result": "success",
+			"data":   map[string]interface{}{"recordsFiltered": total, "data": rows},
+		}})
+	}))
+	defer srv.Close()
+
+	items, err := NewClient(srv.URL, "key").GetHistory(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(starts) != "[0 2 4]" {
+		t.Errorf("start params = %v, want [0 2 4]", starts)
+	}
+	if len(items) != total {
+		t.Fatalf("items = %d, want %d", len(items), total)
+	}
+	for i, item := range items {
+		if item.RatingKey != strconv.Itoa(i) || item.TMDbID != 100+i {
+			t.Errorf("item %d = %+v", i, item)
+		}
+	}
+}
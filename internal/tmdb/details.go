@@ -0,0 +1,150 @@
+package tmdb
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// GetDetails looks a title up by TMDb ID, returning its genres and keywords.
+// Results are kept in memory for the life of the client.
+func (c *Client) GetDetails(tmdbID int, mediaType string) (*TitleResult, error) {
+	key := fmt.Sprintf("%s:%d", mediaType, tmdbID)
+
+	c.mu.Lock()
+	cached, ok := c.details[key]
+	c.mu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	var result *TitleResult
+	var err error
+	switch mediaType {
+	case "movie":
+		result, err = c.movieDetails(tmdbID)
+	case "tv":
+		result, err = c.tvDetails(tmdbID)
+	default:
+		return nil, fmt.Errorf("unknown media type: %s", mediaType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.details[key] = result
+	c.mu.Unlock()
+	return result, nil
+}
+
+func (c *Client) movieDetails(tmdbID int) (*TitleResult, error) {
+	details, err := c.client.GetMovieDetails(tmdbID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("TMDb movie details failed: %w", err)
+	}
+
+	result := &TitleResult{
+		TMDbID:     tmdbID,
+		IMDbID:     details.IMDbID,
+		Title:      details.Title,
+		Year:       yearOf(details.ReleaseDate),
+		MediaType:  "movie",
+		Overview:   details.Overview,
+		VoteCount:  int(details.VoteCount),
+		VoteAvg:    float64(details.VoteAverage),
+		RuntimeMin: int(details.Runtime),
+	}
+	for _, g := range details.Genres {
+		result.Genres = append(result.Genres, g.Name)
+	}
+
+	keywords, err := c.client.GetMovieKeywords(tmdbID)
+	if err == nil && keywords != nil {
+		for _, kw := range keywords.Keywords {
+			result.Keywords = append(result.Keywords, kw.Name)
+		}
+	}
+	return result, nil
+}
+
+func (c *Client) tvDetails(tmdbID int) (*TitleResult, error) {
+	details, err := c.client.GetTVDetails(tmdbID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("TMDb TV details failed: %w", err)
+	}
+
+	result := &TitleResult{
+		TMDbID:    tmdbID,
+		Title:     details.Name,
+		Year:      yearOf(details.FirstAirDate),
+		MediaType: "tv",
+		Overview:  details.Overview,
+		VoteCount: int(details.VoteCount),
+		VoteAvg:   float64(details.VoteAverage),
+	}
+	for _, g := range details.Genres {
+		result.Genres = append(result.Genres, g.Name)
+	}
+
+	keywords, err := c.client.GetTVKeywords(tmdbID)
+	if err == nil && keywords != nil {
+		for _, kw := range keywords.Results {
+			result.Keywords = append(result.Keywords, kw.Name)
+		}
+	}
+	return result, nil
+}
+
+// yearOf returns the year of a YYYY-MM-DD date, or 0
+func yearOf(date string) int {
+	if len(date) < 4 {
+		return 0
+	}
+	year, _ := strconv.Atoi(date[:4])
+	return year
+}
+
+// GetNeighbours returns the TMDb IDs TMDb recommends alongside a title (its
+// first page of recommendations), i.e. its closest neighbours. Results are
+// kept in memory for the life of the client.
+func (c *Client) GetNeighbours(tmdbID int, mediaType string) ([]int, error) {
+	key := fmt.Sprintf("%s:%d", mediaType, tmdbID)
+
+	c.mu.Lock()
+	cached, ok := c.neighbours[key]
+	c.mu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	var ids []int
+	switch mediaType {
+	case "movie":
+		recs, err := c.client.GetMovieRecommendations(tmdbID, nil)
+		if err != nil {
+			return nil, fmt.Errorf("TMDb movie recommendations failed: %w", err)
+		}
+		if recs.MovieRecommendationsResults != nil {
+			for _, r := range recs.Results {
+				ids = append(ids, int(r.ID))
+			}
+		}
+	case "tv":
+		recs, err := c.client.GetTVRecommendations(tmdbID, nil)
+		if err != nil {
+			return nil, fmt.Errorf("TMDb TV recommendations failed: %w", err)
+		}
+		if recs.TVRecommendationsResults != nil {
+			for _, r := range recs.Results {
+				ids = append(ids, int(r.ID))
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown media type: %s", mediaType)
+	}
+
+	c.mu.Lock()
+	c.neighbours[key] = ids
+	c.mu.Unlock()
+	return ids, nil
+}
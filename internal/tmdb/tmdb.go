@@ -2,6 +2,7 @@ package tmdb
 
 import (
 	"fmt"
+	"sync"
 
 	tmdb "github.com/cyruzin/golang-tmdb"
 	"github.com/dppeppel/scryarr/internal/logging"
@@ -18,19 +19,25 @@ func init() {
 // Client wraps the TMDb API client with caching
 type Client struct {
 	client *tmdb.Client
-	store  *store.Store
+	store  store.ResolutionCache
+
+	mu         sync.Mutex
+	details    map[string]*TitleResult // GetDetails results by "medium:id"
+	neighbours map[string][]int        // GetNeighbours results by "medium:id"
 }
 
-// NewClient creates a new TMDb client
-func NewClient(apiKey string, store *store.Store) (*Client, error) {
+// NewClient creates a new TMDb client. The cache may be nil to disable caching.
+func NewClient(apiKey string, store store.ResolutionCache) (*Client, error) {
 	tmdbClient, err := tmdb.Init(apiKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize TMDb client: %w", err)
 	}
 
 	return &Client{
-		client: tmdbClient,
-		store:  store,
+		client:     tmdbClient,
+		store:      store,
+		details:    make(map[string]*TitleResult),
+		neighbours: make(map[string][]int),
 	}, nil
 }
 